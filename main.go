@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
 	"os"
 
@@ -11,21 +12,179 @@ import (
 )
 
 func main() {
-	flushdb := len(os.Args) > 1 && os.Args[1] == "flushdb"
-	if flushdb {
+	command := ""
+	if len(os.Args) > 1 {
+		command = os.Args[1]
+	}
+	switch command {
+	case "flushdb":
 		redisFlushDB()
-		return
+	case "migrate":
+		migrate(os.Args[2:])
+	case "migrate-keys":
+		migrateRedisKeys(os.Args[2:])
+	case "backup":
+		backup(os.Args[2:])
+	case "restore":
+		restore(os.Args[2:])
+	default:
+		mypaste.Start()
 	}
-	mypaste.Start()
 }
 
 func redisFlushDB() {
 	fmt.Println("Running redis flushdb")
-	redisOpts, _ := redis.ParseURL(mypaste.GetEnvVerbose("REDIS_URL", true))
-	redisClient := redis.NewClient(redisOpts)
+	redisClient, err := mypaste.NewRedisClient(mypaste.GetEnvVerbose("REDIS_URL", true))
+	if err != nil {
+		fmt.Println("redis url error:", err)
+		return
+	}
+	if clusterClient, ok := redisClient.(*redis.ClusterClient); ok {
+		err = clusterClient.ForEachMaster(context.Background(), func(ctx context.Context, client *redis.Client) error {
+			return client.FlushDB(ctx).Err()
+		})
+		if err != nil {
+			fmt.Println("flushdb error:", err)
+		}
+		return
+	}
 	result, err := redisClient.FlushDB(context.Background()).Result()
 	if err != nil {
 		fmt.Println("flushdb error:", err)
 	}
 	fmt.Println(result)
 }
+
+func migrate(args []string) {
+	flags := flag.NewFlagSet("migrate", flag.ExitOnError)
+	from := flags.String("from", "", "source store url")
+	to := flags.String("to", "", "target store url")
+	fromArchive := flags.String("from-archive", "", "archive url of the source store, defaults to ARCHIVE_URL")
+	batchSize := flags.Int64("batch-size", 500, "number of events copied at once")
+	flags.Parse(args)
+	if *from == "" || *to == "" {
+		fmt.Println("usage: mypaste migrate --from <url> --to <url> [--from-archive <url>]")
+		os.Exit(2)
+	}
+	if *fromArchive == "" {
+		*fromArchive = mypaste.GetEnvVerbose("ARCHIVE_URL", true)
+	}
+	fromService := openStore(*from, *fromArchive, *batchSize)
+	defer fromService.Close()
+	toService := openStore(*to, "", *batchSize)
+	defer toService.Close()
+	if err := mypaste.Migrate(context.Background(), fromService, toService, *batchSize, os.Stdout); err != nil {
+		fmt.Println("migrate error:", err)
+		os.Exit(1)
+	}
+}
+
+func migrateRedisKeys(args []string) {
+	flags := flag.NewFlagSet("migrate-keys", flag.ExitOnError)
+	redisUrl := flags.String("redis", "", "redis url, defaults to REDIS_URL")
+	flags.Parse(args)
+	if *redisUrl == "" {
+		*redisUrl = mypaste.GetEnvVerbose("REDIS_URL", true)
+	}
+	if *redisUrl == "" {
+		fmt.Println("usage: mypaste migrate-keys [--redis <url>]")
+		os.Exit(2)
+	}
+	client, err := mypaste.NewRedisClient(*redisUrl)
+	if err != nil {
+		fmt.Println("redis url error:", err)
+		os.Exit(1)
+	}
+	defer client.Close()
+	count, err := mypaste.MigrateRedisKeys(context.Background(), client)
+	fmt.Printf("migrated %v redis keys to hash tagged keys\n", count)
+	if err != nil {
+		fmt.Println("migrate keys error:", err)
+		os.Exit(1)
+	}
+}
+
+func backup(args []string) {
+	flags := flag.NewFlagSet("backup", flag.ExitOnError)
+	store := flags.String("store", "", "store url, defaults to STORE_URL or REDIS_URL")
+	archive := flags.String("archive", "", "archive url, defaults to ARCHIVE_URL")
+	out := flags.String("out", "", "backup file to write")
+	batchSize := flags.Int64("batch-size", 500, "number of events read at once")
+	flags.Parse(args)
+	if *out == "" {
+		fmt.Println("usage: mypaste backup --out <file> [--store <url>] [--archive <url>]")
+		os.Exit(2)
+	}
+	if *archive == "" {
+		*archive = mypaste.GetEnvVerbose("ARCHIVE_URL", true)
+	}
+	streamService := openStore(*store, *archive, *batchSize)
+	defer streamService.Close()
+	file, err := os.Create(*out)
+	if err != nil {
+		fmt.Println("create backup file error:", err)
+		os.Exit(1)
+	}
+	err = mypaste.Backup(context.Background(), streamService, file, *batchSize, os.Stdout)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		fmt.Println("backup error:", err)
+		os.Exit(1)
+	}
+}
+
+func restore(args []string) {
+	flags := flag.NewFlagSet("restore", flag.ExitOnError)
+	store := flags.String("store", "", "store url, defaults to STORE_URL or REDIS_URL")
+	in := flags.String("in", "", "backup file to read")
+	stream := flags.String("stream", "", "restore only the stream of this user")
+	flags.Parse(args)
+	if *in == "" {
+		fmt.Println("usage: mypaste restore --in <file> [--store <url>] [--stream <email>]")
+		os.Exit(2)
+	}
+	streamService := openStore(*store, "", 500)
+	defer streamService.Close()
+	file, err := os.Open(*in)
+	if err != nil {
+		fmt.Println("open backup file error:", err)
+		os.Exit(1)
+	}
+	defer file.Close()
+	if err := mypaste.Restore(context.Background(), streamService, file, *stream, os.Stdout); err != nil {
+		fmt.Println("restore error:", err)
+		os.Exit(1)
+	}
+}
+
+// openStore opens the store without max len so that no events are trimmed,
+// with an archive url the events moved to the archive are read as part of the stream.
+func openStore(storeUrl, archiveUrl string, readCount int64) mypaste.StreamService {
+	if storeUrl == "" {
+		storeUrl = mypaste.GetEnvVerbose("STORE_URL", true)
+	}
+	if storeUrl == "" {
+		storeUrl = mypaste.GetEnvVerbose("REDIS_URL", true)
+	}
+	if storeUrl == "" {
+		fmt.Println("store url is required")
+		os.Exit(2)
+	}
+	streamService, err := mypaste.OpenStreamService(storeUrl, mypaste.StreamConfig{ReadCount: readCount, ReadBlock: -1})
+	if err != nil {
+		fmt.Println("open store error:", err)
+		os.Exit(1)
+	}
+	if archiveUrl == "" {
+		return streamService
+	}
+	archive, err := mypaste.OpenArchiveStore(archiveUrl)
+	if err != nil {
+		fmt.Println("open archive error:", err)
+		os.Exit(1)
+	}
+	// without max len nothing is archived, the archive is only read
+	return mypaste.NewArchivingStreamService(streamService, archive, mypaste.ArchiveConfig{ReadCount: readCount})
+}
@@ -0,0 +1,181 @@
+package mypaste
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	ScopeEventsRead  = "events:read"
+	ScopeEventsWrite = "events:write"
+	ScopeDevicesRead = "devices:read"
+
+	// apiTokenPrefix tells api tokens apart from login jwts in the Authorization header.
+	apiTokenPrefix        = "mpt_"
+	maxApiTokenNameLength = 100
+)
+
+var apiTokenScopes = []string{ScopeEventsRead, ScopeEventsWrite, ScopeDevicesRead}
+
+type CreateApiTokenRequest struct {
+	Name   string
+	Scopes []string
+}
+
+// CreatedApiToken is returned once when the token is created, only its hash is stored.
+type CreatedApiToken struct {
+	ApiToken
+	Token string
+}
+
+func CreateApiTokenHandler(apiTokens ApiTokenStore) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		user := GetAuthorizedUser(c)
+		var req CreateApiTokenRequest
+		if err := c.Bind(&req); err != nil {
+			return c.String(http.StatusBadRequest, err.Error())
+		}
+		scopes, err := validateApiTokenRequest(req)
+		if err != nil {
+			return c.String(http.StatusBadRequest, err.Error())
+		}
+		id, err := randomString()
+		if err != nil {
+			return c.String(http.StatusInternalServerError, err.Error())
+		}
+		secret, err := randomString()
+		if err != nil {
+			return c.String(http.StatusInternalServerError, err.Error())
+		}
+		secret = apiTokenPrefix + secret
+		token := ApiToken{
+			Id:        id[:16],
+			Name:      req.Name,
+			Scopes:    scopes,
+			CreatedAt: time.Now().Unix(),
+			User:      user,
+			Hash:      hashApiToken(secret),
+		}
+		if err := apiTokens.AddApiToken(c.Request().Context(), user.Email, token); err != nil {
+			return c.String(http.StatusInternalServerError, err.Error())
+		}
+		c.Logger().Infof("api token created, email: %v, id: %v", user.Email, token.Id)
+		return c.JSON(http.StatusOK, CreatedApiToken{token, secret})
+	}
+}
+
+func validateApiTokenRequest(req CreateApiTokenRequest) ([]string, error) {
+	if strings.TrimSpace(req.Name) == "" {
+		return nil, fmt.Errorf("missing token name")
+	}
+	if len(req.Name) > maxApiTokenNameLength {
+		return nil, fmt.Errorf("token name longer than %v", maxApiTokenNameLength)
+	}
+	if len(req.Scopes) == 0 {
+		return nil, fmt.Errorf("missing token scopes")
+	}
+	scopes := make([]string, 0, len(req.Scopes))
+	for _, scope := range req.Scopes {
+		if !slices.Contains(apiTokenScopes, scope) {
+			return nil, fmt.Errorf("invalid token scope: %v", scope)
+		}
+		if !slices.Contains(scopes, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+	return scopes, nil
+}
+
+func GetApiTokensHandler(apiTokens ApiTokenStore) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		user := GetAuthorizedUser(c)
+		tokens, err := apiTokens.GetApiTokens(c.Request().Context(), user.Email)
+		if err != nil {
+			return c.String(http.StatusInternalServerError, err.Error())
+		}
+		return c.JSON(http.StatusOK, tokens)
+	}
+}
+
+func DeleteApiTokenHandler(apiTokens ApiTokenStore) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		user := GetAuthorizedUser(c)
+		err := apiTokens.DeleteApiToken(c.Request().Context(), user.Email, c.Param("id"))
+		if errors.Is(err, ErrApiTokenNotFound) {
+			return c.String(http.StatusNotFound, err.Error())
+		}
+		if err != nil {
+			return c.String(http.StatusInternalServerError, err.Error())
+		}
+		c.Logger().Infof("api token revoked, email: %v, id: %v", user.Email, c.Param("id"))
+		return c.NoContent(http.StatusOK)
+	}
+}
+
+// RequireScopes rejects api tokens missing any of scopes, login jwts have every scope.
+func RequireScopes(scopes ...string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			claims := getTokenClaims(c)
+			if claims.ApiTokenId == "" {
+				return next(c)
+			}
+			for _, scope := range scopes {
+				if !slices.Contains(claims.Scopes, scope) {
+					return c.String(http.StatusForbidden, "api token missing scope: "+scope)
+				}
+			}
+			return next(c)
+		}
+	}
+}
+
+// RejectApiTokens keeps api tokens away from routes managing credentials,
+// so a leaked token cannot mint a login jwt or new tokens.
+func RejectApiTokens(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if getTokenClaims(c).ApiTokenId != "" {
+			return c.String(http.StatusForbidden, "api tokens are not allowed")
+		}
+		return next(c)
+	}
+}
+
+func getTokenClaims(c echo.Context) *TokenClaims {
+	return c.Get("user").(*jwt.Token).Claims.(*TokenClaims)
+}
+
+func authorizeApiToken(c echo.Context, apiTokens ApiTokenStore, secret string, next echo.HandlerFunc) error {
+	token, err := apiTokens.GetApiToken(c.Request().Context(), hashApiToken(secret))
+	if errors.Is(err, ErrApiTokenNotFound) {
+		return c.String(http.StatusUnauthorized, "invalid api token")
+	}
+	if err != nil {
+		return c.String(http.StatusInternalServerError, err.Error())
+	}
+	// handlers read the user from the same claims as a login jwt
+	c.Set("user", jwt.NewWithClaims(jwt.SigningMethodHS256, &TokenClaims{
+		User:       token.User,
+		Scopes:     token.Scopes,
+		ApiTokenId: token.Id,
+	}))
+	return next(c)
+}
+
+func isApiToken(secret string) bool {
+	return strings.HasPrefix(secret, apiTokenPrefix)
+}
+
+func hashApiToken(secret string) string {
+	hash := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(hash[:])
+}
@@ -0,0 +1,123 @@
+package mypaste
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestApiTokens(t *testing.T) {
+	const jwtSignKey = "secret"
+
+	t.Run("create list and revoke", func(t *testing.T) {
+		svc := NewMemoryStreamService(StreamConfig{})
+		created := createApiTokenT(t, svc, "email", `{"Name":"script","Scopes":["events:read","events:read"]}`)
+		assert.True(t, strings.HasPrefix(created.Token, apiTokenPrefix))
+		assert.Equal(t, "script", created.Name)
+		assert.Equal(t, []string{ScopeEventsRead}, created.Scopes)
+
+		rec := apiTokenRequestT(t, GetApiTokensHandler(svc), http.MethodGet, "email", "", nil)
+		require.Equal(t, http.StatusOK, rec.Code)
+		assert.NotContains(t, rec.Body.String(), created.Token, "should not return the secret again")
+		var tokens []ApiToken
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &tokens))
+		require.Len(t, tokens, 1)
+		assert.Equal(t, created.Id, tokens[0].Id)
+
+		assert.Equal(t, http.StatusOK, authorizeT(svc, jwtSignKey, created.Token, nil))
+		_, err := svc.GetApiToken(context.Background(), created.Token)
+		assert.ErrorIs(t, err, ErrApiTokenNotFound, "should store only the hash")
+
+		rec = apiTokenRequestT(t, DeleteApiTokenHandler(svc), http.MethodDelete, "email2", "", map[string]string{"id": created.Id})
+		assert.Equal(t, http.StatusNotFound, rec.Code, "should not revoke token of other user")
+		rec = apiTokenRequestT(t, DeleteApiTokenHandler(svc), http.MethodDelete, "email", "", map[string]string{"id": created.Id})
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, http.StatusUnauthorized, authorizeT(svc, jwtSignKey, created.Token, nil))
+	})
+
+	t.Run("invalid request", func(t *testing.T) {
+		svc := NewMemoryStreamService(StreamConfig{})
+		for _, body := range []string{
+			`{"Scopes":["events:read"]}`,
+			`{"Name":"script"}`,
+			`{"Name":"script","Scopes":["events:admin"]}`,
+			`{"Name":"` + strings.Repeat("a", maxApiTokenNameLength+1) + `","Scopes":["events:read"]}`,
+		} {
+			rec := apiTokenRequestT(t, CreateApiTokenHandler(svc), http.MethodPost, "email", body, nil)
+			assert.Equal(t, http.StatusBadRequest, rec.Code, body)
+		}
+	})
+
+	t.Run("authorize with scopes", func(t *testing.T) {
+		svc := NewMemoryStreamService(StreamConfig{})
+		created := createApiTokenT(t, svc, "email", `{"Name":"script","Scopes":["events:read"]}`)
+
+		var user User
+		handler := func(c echo.Context) error {
+			user = GetAuthorizedUser(c)
+			return c.NoContent(http.StatusOK)
+		}
+		assert.Equal(t, http.StatusOK, authorizeT(svc, jwtSignKey, created.Token, handler, RequireScopes(ScopeEventsRead)))
+		assert.Equal(t, User{"name", "email"}, user)
+		assert.Equal(t, http.StatusForbidden, authorizeT(svc, jwtSignKey, created.Token, handler, RequireScopes(ScopeEventsWrite)))
+		assert.Equal(t, http.StatusForbidden, authorizeT(svc, jwtSignKey, created.Token, handler, RejectApiTokens))
+		assert.Equal(t, http.StatusUnauthorized, authorizeT(svc, jwtSignKey, created.Token+"x", handler))
+	})
+
+	t.Run("login token has every scope", func(t *testing.T) {
+		svc := NewMemoryStreamService(StreamConfig{})
+		signed, err := startSession(context.Background(), svc, User{"name", "email"}, "", "", jwtSignKey)
+		require.NoError(t, err)
+
+		assert.Equal(t, http.StatusOK, authorizeT(svc, jwtSignKey, signed, nil, RequireScopes(ScopeEventsWrite, ScopeDevicesRead)))
+		assert.Equal(t, http.StatusOK, authorizeT(svc, jwtSignKey, signed, nil, RejectApiTokens))
+	})
+}
+
+func createApiTokenT(t *testing.T, svc Store, email, body string) CreatedApiToken {
+	rec := apiTokenRequestT(t, CreateApiTokenHandler(svc), http.MethodPost, email, body, nil)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	var created CreatedApiToken
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &created))
+	return created
+}
+
+func apiTokenRequestT(t *testing.T, handler echo.HandlerFunc, method, email, body string, params map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, "/", strings.NewReader(body))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	c := echo.New().NewContext(req, rec)
+	c.Set("user", generateToken(User{"name", email}, ""))
+	for name, value := range params {
+		c.SetParamNames(name)
+		c.SetParamValues(value)
+	}
+	require.NoError(t, handler(c))
+	return rec
+}
+
+// authorizeT sends bearer through the auth middleware and then middlewares to handler, it returns the status code.
+func authorizeT(svc Store, jwtSignKey, bearer string, handler echo.HandlerFunc, middlewares ...echo.MiddlewareFunc) int {
+	if handler == nil {
+		handler = func(c echo.Context) error { return c.NoContent(http.StatusOK) }
+	}
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		handler = middlewares[i](handler)
+	}
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(echo.HeaderAuthorization, "Bearer "+bearer)
+	rec := httptest.NewRecorder()
+	e := echo.New()
+	c := e.NewContext(req, rec)
+	if err := NewAuthMiddleware(jwtSignKey, svc, svc)(handler)(c); err != nil {
+		e.HTTPErrorHandler(err, c)
+	}
+	return rec.Code
+}
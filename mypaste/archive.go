@@ -0,0 +1,552 @@
+package mypaste
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ArchiveStore keeps events trimmed from the live stream.
+type ArchiveStore interface {
+	// Append archives events sorted by id, events already archived are skipped.
+	Append(ctx context.Context, stream string, events []Event) error
+	// ReadAfter returns up to count archived events after lastId in id order.
+	ReadAfter(ctx context.Context, stream, lastId string, count int64) ([]Event, error)
+	// Range returns up to count archived events between start and end inclusive, like StreamService.Range.
+	Range(ctx context.Context, stream, start, end string, count int64) ([]Event, error)
+	// RevRange is Range in reverse order, newest first.
+	RevRange(ctx context.Context, stream, end, start string, count int64) ([]Event, error)
+	Delete(ctx context.Context, stream string, ids ...string) (int64, error)
+	// Trim removes archived events with ids less than minId.
+	Trim(ctx context.Context, stream, minId string) (int64, error)
+	// SetMaxDeletedId records id as the greatest id removed from the stream unless a greater id is recorded.
+	SetMaxDeletedId(ctx context.Context, stream, id string) error
+	// MaxDeletedId returns the recorded id or an empty string, Reset clears it.
+	MaxDeletedId(ctx context.Context, stream string) (string, error)
+	Reset(ctx context.Context, stream string) error
+}
+
+// OpenArchiveStore picks the ArchiveStore implementation by the scheme of archiveUrl,
+// eg. file:///var/lib/mypaste/archive or s3://bucket/prefix?endpoint=http://localhost:9000.
+func OpenArchiveStore(archiveUrl string) (ArchiveStore, error) {
+	u, err := url.Parse(archiveUrl)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse archive url, %w", err)
+	}
+	switch u.Scheme {
+	case "file":
+		return NewFileArchiveStore(u.Host + u.Path), nil
+	case "s3":
+		bucket, err := parseS3URL(u)
+		if err != nil {
+			return nil, err
+		}
+		return newSegmentArchiveStore(bucket), nil
+	}
+	return nil, fmt.Errorf("unsupported archive url scheme: %v", u.Scheme)
+}
+
+func NewFileArchiveStore(dir string) ArchiveStore {
+	return newSegmentArchiveStore(&fileArchiveBucket{dir: dir})
+}
+
+var errArchiveObjectNotFound = errors.New("archive object not found")
+
+// archiveBucket is the object storage used by segmentArchiveStore, keys are slash separated.
+type archiveBucket interface {
+	List(ctx context.Context, prefix string) ([]string, error)
+	Get(ctx context.Context, key string) ([]byte, error)
+	Put(ctx context.Context, key string, data []byte) error
+	Delete(ctx context.Context, key string) error
+}
+
+// segmentArchiveStore writes every appended batch as a json lines segment named by the ids
+// of its first and last event, eg. <stream>/1700000000000-0_1700000000500-3.jsonl.
+// The name is kept when events are removed from a segment, so it still bounds the ids in it.
+type segmentArchiveStore struct {
+	bucket archiveBucket
+	mtx    sync.Mutex
+}
+
+type archiveSegment struct {
+	stream  string
+	key     string
+	firstId streamId
+	lastId  streamId
+	// hasLastId is false for segments named only by their first id,
+	// their last id is bounded by the next segment instead
+	hasLastId bool
+}
+
+func newSegmentArchiveStore(bucket archiveBucket) *segmentArchiveStore {
+	return &segmentArchiveStore{bucket: bucket}
+}
+
+func (a *segmentArchiveStore) Append(ctx context.Context, stream string, events []Event) error {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+
+	segments, err := a.segments(ctx, stream)
+	if err != nil {
+		return err
+	}
+	if len(segments) > 0 {
+		lastId, err := a.segmentLastId(ctx, segments[len(segments)-1])
+		if err != nil {
+			return err
+		}
+		events = eventsAfter(events, lastId)
+	}
+	if len(events) == 0 {
+		return nil
+	}
+	firstId, err := parseStreamId(events[0].Id)
+	if err != nil {
+		return err
+	}
+	lastId, err := parseStreamId(events[len(events)-1].Id)
+	if err != nil {
+		return err
+	}
+	return a.writeSegment(ctx, a.segmentKey(stream, firstId, lastId), events)
+}
+
+// segmentLastId reads the segment only when its name does not have the last id.
+func (a *segmentArchiveStore) segmentLastId(ctx context.Context, segment archiveSegment) (streamId, error) {
+	if segment.hasLastId {
+		return segment.lastId, nil
+	}
+	events, err := a.readSegment(ctx, segment)
+	if err != nil || len(events) == 0 {
+		return segment.firstId, err
+	}
+	return parseStreamId(events[len(events)-1].Id)
+}
+
+func (a *segmentArchiveStore) ReadAfter(ctx context.Context, stream, lastId string, count int64) ([]Event, error) {
+	after, err := parseStreamId(lastId)
+	if err != nil {
+		return nil, err
+	}
+	segments, err := a.segments(ctx, stream)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]Event, 0)
+	for i, segment := range segments {
+		if (segment.hasLastId && !after.Less(segment.lastId)) ||
+			(i+1 < len(segments) && !after.Less(segments[i+1].firstId)) {
+			continue
+		}
+		events, err := a.readSegment(ctx, segment)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, eventsAfter(events, after)...)
+		if count > 0 && int64(len(result)) >= count {
+			return result[:count], nil
+		}
+	}
+	return result, nil
+}
+
+func (a *segmentArchiveStore) Range(ctx context.Context, stream, start, end string, count int64) ([]Event, error) {
+	startId, err := parseRangeStart(start)
+	if err != nil {
+		return nil, err
+	}
+	endId, err := parseRangeEnd(end)
+	if err != nil {
+		return nil, err
+	}
+	segments, err := a.segments(ctx, stream)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]Event, 0)
+	for i, segment := range segments {
+		if endId.Less(segment.firstId) {
+			break
+		}
+		if (segment.hasLastId && segment.lastId.Less(startId)) ||
+			(i+1 < len(segments) && !startId.Less(segments[i+1].firstId)) {
+			continue
+		}
+		events, err := a.readSegment(ctx, segment)
+		if err != nil {
+			return nil, err
+		}
+		for _, event := range events {
+			id, err := parseStreamId(event.Id)
+			if err != nil || id.Less(startId) || endId.Less(id) {
+				continue
+			}
+			result = append(result, event)
+			if count > 0 && int64(len(result)) >= count {
+				return result, nil
+			}
+		}
+	}
+	return result, nil
+}
+
+func (a *segmentArchiveStore) RevRange(ctx context.Context, stream, end, start string, count int64) ([]Event, error) {
+	startId, err := parseRangeStart(start)
+	if err != nil {
+		return nil, err
+	}
+	endId, err := parseRangeEnd(end)
+	if err != nil {
+		return nil, err
+	}
+	segments, err := a.segments(ctx, stream)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]Event, 0)
+	for i := len(segments) - 1; i >= 0; i-- {
+		if endId.Less(segments[i].firstId) {
+			continue
+		}
+		events, err := a.readSegment(ctx, segments[i])
+		if err != nil {
+			return nil, err
+		}
+		for j := len(events) - 1; j >= 0; j-- {
+			id, err := parseStreamId(events[j].Id)
+			if err != nil || id.Less(startId) || endId.Less(id) {
+				continue
+			}
+			result = append(result, events[j])
+			if count > 0 && int64(len(result)) >= count {
+				return result, nil
+			}
+		}
+		if !startId.Less(segments[i].firstId) {
+			break
+		}
+	}
+	return result, nil
+}
+
+func (a *segmentArchiveStore) Delete(ctx context.Context, stream string, ids ...string) (int64, error) {
+	parsed := make([]streamId, 0, len(ids))
+	for _, id := range ids {
+		p, err := parseStreamId(id)
+		if err != nil {
+			return 0, err
+		}
+		parsed = append(parsed, p)
+	}
+
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+
+	segments, err := a.segments(ctx, stream)
+	if err != nil {
+		return 0, err
+	}
+	// group the ids by the segment covering them, other segments are not read
+	remove := make(map[int]map[string]bool)
+	for _, id := range parsed {
+		i := sort.Search(len(segments), func(i int) bool {
+			return id.Less(segments[i].firstId)
+		}) - 1
+		if i < 0 || (segments[i].hasLastId && segments[i].lastId.Less(id)) {
+			continue
+		}
+		if remove[i] == nil {
+			remove[i] = make(map[string]bool)
+		}
+		remove[i][id.String()] = true
+	}
+	var count int64
+	for i, segment := range segments {
+		if remove[i] == nil {
+			continue
+		}
+		removed, err := a.removeEvents(ctx, segment, func(event Event) bool {
+			return remove[i][event.Id]
+		})
+		count += removed
+		if err != nil {
+			return count, err
+		}
+	}
+	return count, nil
+}
+
+func (a *segmentArchiveStore) Trim(ctx context.Context, stream, minId string) (int64, error) {
+	min, err := parseStreamId(minId)
+	if err != nil {
+		return 0, err
+	}
+
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+
+	segments, err := a.segments(ctx, stream)
+	if err != nil {
+		return 0, err
+	}
+	var count int64
+	// segments are sorted, only the ones starting before min have events to remove
+	for _, segment := range segments {
+		if !segment.firstId.Less(min) {
+			break
+		}
+		removed, err := a.removeEvents(ctx, segment, func(event Event) bool {
+			id, err := parseStreamId(event.Id)
+			return err == nil && id.Less(min)
+		})
+		count += removed
+		if err != nil {
+			return count, err
+		}
+	}
+	return count, nil
+}
+
+// removeEvents rewrites segment without the events to remove, an empty segment is deleted.
+func (a *segmentArchiveStore) removeEvents(ctx context.Context, segment archiveSegment, remove func(event Event) bool) (int64, error) {
+	events, err := a.readSegment(ctx, segment)
+	if err != nil {
+		return 0, err
+	}
+	kept := make([]Event, 0, len(events))
+	for _, event := range events {
+		if !remove(event) {
+			kept = append(kept, event)
+		}
+	}
+	if len(kept) == len(events) {
+		return 0, nil
+	}
+	if len(kept) == 0 {
+		err = a.bucket.Delete(ctx, segment.key)
+	} else {
+		err = a.writeSegment(ctx, segment.key, kept)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return int64(len(events) - len(kept)), nil
+}
+
+func (a *segmentArchiveStore) Reset(ctx context.Context, stream string) error {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+
+	segments, err := a.segments(ctx, stream)
+	if err != nil {
+		return err
+	}
+	for _, segment := range segments {
+		if err := a.bucket.Delete(ctx, segment.key); err != nil {
+			return err
+		}
+	}
+	return a.bucket.Delete(ctx, a.maxDeletedKey(stream))
+}
+
+func (a *segmentArchiveStore) SetMaxDeletedId(ctx context.Context, stream, id string) error {
+	parsed, err := parseStreamId(id)
+	if err != nil {
+		return err
+	}
+
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+
+	current, err := a.maxDeletedId(ctx, stream)
+	if err != nil || !current.Less(parsed) {
+		return err
+	}
+	return a.bucket.Put(ctx, a.maxDeletedKey(stream), []byte(parsed.String()))
+}
+
+func (a *segmentArchiveStore) MaxDeletedId(ctx context.Context, stream string) (string, error) {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+
+	id, err := a.maxDeletedId(ctx, stream)
+	if err != nil || id == (streamId{}) {
+		return "", err
+	}
+	return id.String(), nil
+}
+
+func (a *segmentArchiveStore) maxDeletedId(ctx context.Context, stream string) (streamId, error) {
+	data, err := a.bucket.Get(ctx, a.maxDeletedKey(stream))
+	if err == errArchiveObjectNotFound {
+		return streamId{}, nil
+	}
+	if err != nil {
+		return streamId{}, err
+	}
+	return parseStreamId(string(data))
+}
+
+func (a *segmentArchiveStore) segments(ctx context.Context, stream string) ([]archiveSegment, error) {
+	keys, err := a.bucket.List(ctx, a.streamPrefix(stream))
+	if err != nil {
+		return nil, err
+	}
+	segments := make([]archiveSegment, 0, len(keys))
+	for _, key := range keys {
+		first, last, hasLast := strings.Cut(strings.TrimSuffix(path.Base(key), ".jsonl"), "_")
+		segment := archiveSegment{stream: stream, key: key, hasLastId: hasLast}
+		if segment.firstId, err = parseStreamId(first); err != nil {
+			continue
+		}
+		if hasLast {
+			if segment.lastId, err = parseStreamId(last); err != nil {
+				continue
+			}
+		}
+		segments = append(segments, segment)
+	}
+	sort.Slice(segments, func(i, j int) bool {
+		return segments[i].firstId.Less(segments[j].firstId)
+	})
+	return segments, nil
+}
+
+// archiveEntry is a line of a segment. Lines written before events were versioned
+// are the json of the event itself, they have no V and are read as version 1.
+type archiveEntry struct {
+	Id   string
+	V    int    `json:",omitempty"`
+	Json string `json:",omitempty"`
+}
+
+// readSegment skips and reports corrupt lines, they are dropped when events are removed from the segment.
+func (a *segmentArchiveStore) readSegment(ctx context.Context, segment archiveSegment) ([]Event, error) {
+	data, err := a.bucket.Get(ctx, segment.key)
+	if err != nil {
+		return nil, err
+	}
+	events := make([]Event, 0)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(nil, 1<<24)
+	for line := 1; scanner.Scan(); line++ {
+		var entry archiveEntry
+		err := json.Unmarshal(scanner.Bytes(), &entry)
+		if err == nil {
+			_, err = parseStreamId(entry.Id)
+		}
+		if err != nil {
+			id := fmt.Sprintf("%v:%v", segment.key, line)
+			reportCorruptEvent(&CorruptEventError{Stream: segment.stream, Id: id, Err: err})
+			continue
+		}
+		version, value := entry.V, entry.Json
+		if version == 0 {
+			version, value = 1, scanner.Text()
+		}
+		if event, ok := decodeStoredEvent(segment.stream, entry.Id, version, value); ok {
+			events = append(events, event)
+		}
+	}
+	return events, scanner.Err()
+}
+
+func (a *segmentArchiveStore) writeSegment(ctx context.Context, key string, events []Event) error {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	for _, event := range events {
+		entry := archiveEntry{Id: event.Id, V: eventEncodingVersion, Json: encodeEvent(event)}
+		if err := encoder.Encode(entry); err != nil {
+			return err
+		}
+	}
+	return a.bucket.Put(ctx, key, buf.Bytes())
+}
+
+func (a *segmentArchiveStore) streamPrefix(stream string) string {
+	return url.PathEscape(stream) + "/"
+}
+
+// maxDeletedKey is beside the segments of the stream, its name is not parsed as a segment.
+func (a *segmentArchiveStore) maxDeletedKey(stream string) string {
+	return a.streamPrefix(stream) + "maxdeleted"
+}
+
+func (a *segmentArchiveStore) segmentKey(stream string, firstId, lastId streamId) string {
+	return a.streamPrefix(stream) + firstId.String() + "_" + lastId.String() + ".jsonl"
+}
+
+func eventsAfter(events []Event, after streamId) []Event {
+	result := make([]Event, 0, len(events))
+	for _, event := range events {
+		if id, err := parseStreamId(event.Id); err == nil && after.Less(id) {
+			result = append(result, event)
+		}
+	}
+	return result
+}
+
+type fileArchiveBucket struct {
+	dir string
+}
+
+func (b *fileArchiveBucket) List(ctx context.Context, prefix string) ([]string, error) {
+	entries, err := os.ReadDir(filepath.Join(b.dir, filepath.FromSlash(prefix)))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			keys = append(keys, prefix+entry.Name())
+		}
+	}
+	return keys, nil
+}
+
+func (b *fileArchiveBucket) Get(ctx context.Context, key string) ([]byte, error) {
+	data, err := os.ReadFile(b.path(key))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, errArchiveObjectNotFound
+	}
+	return data, err
+}
+
+func (b *fileArchiveBucket) Put(ctx context.Context, key string, data []byte) error {
+	name := b.path(key)
+	if err := os.MkdirAll(filepath.Dir(name), 0o700); err != nil {
+		return err
+	}
+	// write then rename so readers never see a partial segment
+	tmp := name + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, name)
+}
+
+func (b *fileArchiveBucket) Delete(ctx context.Context, key string) error {
+	err := os.Remove(b.path(key))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+func (b *fileArchiveBucket) path(key string) string {
+	return filepath.Join(b.dir, filepath.FromSlash(key))
+}
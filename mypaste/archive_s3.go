@@ -0,0 +1,225 @@
+package mypaste
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// s3ArchiveBucket talks to any S3 compatible object storage using path style requests
+// signed with AWS signature version 4.
+type s3ArchiveBucket struct {
+	endpoint  *url.URL
+	bucket    string
+	prefix    string
+	region    string
+	accessKey string
+	secretKey string
+	client    *http.Client
+}
+
+// parseS3URL parses s3://bucket/prefix?endpoint=http://localhost:9000&region=us-east-1.
+// Credentials are taken from the url user info or AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY.
+func parseS3URL(u *url.URL) (*s3ArchiveBucket, error) {
+	if u.Host == "" {
+		return nil, fmt.Errorf("s3 archive url requires a bucket")
+	}
+	query := u.Query()
+	region := query.Get("region")
+	if region == "" {
+		region = "us-east-1"
+	}
+	endpoint := query.Get("endpoint")
+	if endpoint == "" {
+		endpoint = "https://s3." + region + ".amazonaws.com"
+	}
+	endpointUrl, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse s3 endpoint, %w", err)
+	}
+	accessKey := os.Getenv("AWS_ACCESS_KEY_ID")
+	secretKey := os.Getenv("AWS_SECRET_ACCESS_KEY")
+	if u.User != nil {
+		accessKey = u.User.Username()
+		secretKey, _ = u.User.Password()
+	}
+	prefix := strings.Trim(u.Path, "/")
+	if prefix != "" {
+		prefix += "/"
+	}
+	return &s3ArchiveBucket{
+		endpoint:  endpointUrl,
+		bucket:    u.Host,
+		prefix:    prefix,
+		region:    region,
+		accessKey: accessKey,
+		secretKey: secretKey,
+		client:    &http.Client{Timeout: time.Minute},
+	}, nil
+}
+
+type s3ListBucketResult struct {
+	Contents []struct {
+		Key string
+	}
+	IsTruncated           bool
+	NextContinuationToken string
+}
+
+func (b *s3ArchiveBucket) List(ctx context.Context, prefix string) ([]string, error) {
+	keys := make([]string, 0)
+	continuationToken := ""
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {b.prefix + prefix}}
+		if continuationToken != "" {
+			query.Set("continuation-token", continuationToken)
+		}
+		data, err := b.do(ctx, http.MethodGet, "", query, nil)
+		if err != nil {
+			return nil, err
+		}
+		var result s3ListBucketResult
+		if err := xml.Unmarshal(data, &result); err != nil {
+			return nil, fmt.Errorf("invalid s3 list response, %w", err)
+		}
+		for _, content := range result.Contents {
+			keys = append(keys, strings.TrimPrefix(content.Key, b.prefix))
+		}
+		if !result.IsTruncated {
+			return keys, nil
+		}
+		continuationToken = result.NextContinuationToken
+	}
+}
+
+func (b *s3ArchiveBucket) Get(ctx context.Context, key string) ([]byte, error) {
+	return b.do(ctx, http.MethodGet, b.prefix+key, nil, nil)
+}
+
+func (b *s3ArchiveBucket) Put(ctx context.Context, key string, data []byte) error {
+	_, err := b.do(ctx, http.MethodPut, b.prefix+key, nil, data)
+	return err
+}
+
+func (b *s3ArchiveBucket) Delete(ctx context.Context, key string) error {
+	_, err := b.do(ctx, http.MethodDelete, b.prefix+key, nil, nil)
+	if err == errArchiveObjectNotFound {
+		return nil
+	}
+	return err
+}
+
+func (b *s3ArchiveBucket) do(ctx context.Context, method, key string, query url.Values, body []byte) ([]byte, error) {
+	escapedPath := "/" + s3Escape(b.bucket, false)
+	if key != "" {
+		escapedPath += "/" + s3Escape(key, true)
+	}
+	rawQuery := s3CanonicalQuery(query)
+	reqUrl := b.endpoint.Scheme + "://" + b.endpoint.Host + escapedPath
+	if rawQuery != "" {
+		reqUrl += "?" + rawQuery
+	}
+	req, err := http.NewRequestWithContext(ctx, method, reqUrl, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	b.sign(req, escapedPath, rawQuery, body, time.Now().UTC())
+
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, errArchiveObjectNotFound
+	}
+	if resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("s3 %v %v failed, status: %v, body: %s", method, escapedPath, resp.StatusCode, data)
+	}
+	return data, nil
+}
+
+func (b *s3ArchiveBucket) sign(req *http.Request, escapedPath, rawQuery string, body []byte, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	payloadHash := sha256Hex(body)
+	req.Header.Set("x-amz-date", amzDate)
+	req.Header.Set("x-amz-content-sha256", payloadHash)
+
+	signedHeaders := "host;x-amz-content-sha256;x-amz-date"
+	canonicalHeaders := "host:" + req.URL.Host + "\n" +
+		"x-amz-content-sha256:" + payloadHash + "\n" +
+		"x-amz-date:" + amzDate + "\n"
+	canonicalRequest := strings.Join([]string{
+		req.Method, escapedPath, rawQuery, canonicalHeaders, signedHeaders, payloadHash,
+	}, "\n")
+	scope := date + "/" + b.region + "/s3/aws4_request"
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256", amzDate, scope, sha256Hex([]byte(canonicalRequest)),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+b.secretKey), date)
+	key = hmacSHA256(key, b.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		b.accessKey, scope, signedHeaders, signature))
+}
+
+func s3CanonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	parts := make([]string, 0, len(keys))
+	for _, key := range keys {
+		for _, value := range query[key] {
+			parts = append(parts, s3Escape(key, false)+"="+s3Escape(value, false))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// s3Escape percent encodes everything except unreserved characters as required by signature version 4.
+func s3Escape(value string, keepSlash bool) string {
+	var sb strings.Builder
+	for _, c := range []byte(value) {
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~', keepSlash && c == '/':
+			sb.WriteByte(c)
+		default:
+			fmt.Fprintf(&sb, "%%%02X", c)
+		}
+	}
+	return sb.String()
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,317 @@
+package mypaste
+
+import (
+	"context"
+	"fmt"
+	"sort"
+)
+
+type ArchiveConfig struct {
+	// MaxLen is the number of events kept in the live stream.
+	MaxLen int64
+	// BatchSize is the number of events the live stream may grow past MaxLen
+	// before the oldest events are archived together.
+	BatchSize int64
+	ReadCount int64
+}
+
+// archivingStreamService moves events over MaxLen to the archive before trimming them
+// from the wrapped stream service, which should be configured without MaxLen.
+type archivingStreamService struct {
+	StreamService
+	archive ArchiveStore
+	config  ArchiveConfig
+}
+
+var _ StreamService = (*archivingStreamService)(nil)
+
+func NewArchivingStreamService(streamService StreamService, archive ArchiveStore, config ArchiveConfig) StreamService {
+	return &archivingStreamService{
+		StreamService: streamService,
+		archive:       archive,
+		config:        config,
+	}
+}
+
+func (s *archivingStreamService) Add(ctx context.Context, stream string, event Event) (Event, error) {
+	event, err := s.StreamService.Add(ctx, stream, event)
+	if err != nil {
+		return event, err
+	}
+	if err := s.archiveOverflow(ctx, stream); err != nil {
+		// the event is added, the overflow is archived again by the next add
+		fmt.Printf("failed to archive stream: %v, %v\n", stream, err)
+	}
+	return event, nil
+}
+
+func (s *archivingStreamService) AddBatch(ctx context.Context, stream string, events []Event) ([]Event, error) {
+	added, err := s.StreamService.AddBatch(ctx, stream, events)
+	if err != nil {
+		return added, err
+	}
+	if err := s.archiveOverflow(ctx, stream); err != nil {
+		fmt.Printf("failed to archive stream: %v, %v\n", stream, err)
+	}
+	return added, nil
+}
+
+func (s *archivingStreamService) archiveOverflow(ctx context.Context, stream string) error {
+	length, err := s.StreamService.Len(ctx, stream)
+	if err != nil {
+		return err
+	}
+	if s.config.MaxLen <= 0 || length <= s.config.MaxLen+s.config.BatchSize {
+		return nil
+	}
+	events, err := s.StreamService.Range(ctx, stream, "-", "+", length-s.config.MaxLen)
+	if err != nil || len(events) == 0 {
+		return err
+	}
+	if err := s.archive.Append(ctx, stream, events); err != nil {
+		return err
+	}
+	lastId, err := parseStreamId(events[len(events)-1].Id)
+	if err != nil {
+		return err
+	}
+	_, err = s.StreamService.Trim(ctx, stream, lastId.successor().String())
+	return err
+}
+
+func (s *archivingStreamService) Import(ctx context.Context, stream string, events []Event) (int64, error) {
+	count, err := s.StreamService.Import(ctx, stream, events)
+	if err != nil {
+		return count, err
+	}
+	return count, s.archiveOverflow(ctx, stream)
+}
+
+// Read continues from the archive when lastId was trimmed from the live stream,
+// an empty lastId starts from the first archived event.
+func (s *archivingStreamService) Read(ctx context.Context, stream, lastId string) ([]Event, error) {
+	if lastId == "" {
+		lastId = "0"
+	}
+	after, err := parseStreamId(lastId)
+	if err != nil {
+		return nil, err
+	}
+	firstId, hasFirst, err := s.firstLiveId(ctx, stream)
+	if err != nil {
+		return nil, err
+	}
+	if !hasFirst || !after.Less(firstId) || after.successor() == firstId {
+		return s.StreamService.Read(ctx, stream, lastId)
+	}
+	archived, err := s.archive.ReadAfter(ctx, stream, lastId, s.config.ReadCount)
+	if err != nil {
+		return nil, err
+	}
+	events := make([]Event, 0, len(archived))
+	for _, event := range archived {
+		// events can be in both stores between archiving and trimming
+		if id, err := parseStreamId(event.Id); err == nil && id.Less(firstId) {
+			events = append(events, event)
+		}
+	}
+	if len(events) == 0 {
+		return s.StreamService.Read(ctx, stream, lastId)
+	}
+	return events, nil
+}
+
+// Range includes the archived events before the first event of the live stream.
+func (s *archivingStreamService) Range(ctx context.Context, stream, start, end string, count int64) ([]Event, error) {
+	firstId, hasFirst, err := s.firstLiveId(ctx, stream)
+	if err != nil {
+		return nil, err
+	}
+	archived, err := s.archive.Range(ctx, stream, start, end, count)
+	if err != nil {
+		return nil, err
+	}
+	events := make([]Event, 0, len(archived))
+	for _, event := range archived {
+		// events can be in both stores between archiving and trimming
+		if id, err := parseStreamId(event.Id); err == nil && (!hasFirst || id.Less(firstId)) {
+			events = append(events, event)
+		}
+	}
+	if count > 0 && int64(len(events)) >= count {
+		return events, nil
+	}
+	if count > 0 {
+		count -= int64(len(events))
+	}
+	live, err := s.StreamService.Range(ctx, stream, start, end, count)
+	if err != nil {
+		return nil, err
+	}
+	return append(events, live...), nil
+}
+
+// RevRange continues with the archived events before the first event of the live stream.
+func (s *archivingStreamService) RevRange(ctx context.Context, stream, end, start string, count int64) ([]Event, error) {
+	firstId, hasFirst, err := s.firstLiveId(ctx, stream)
+	if err != nil {
+		return nil, err
+	}
+	events, err := s.StreamService.RevRange(ctx, stream, end, start, count)
+	if err != nil {
+		return nil, err
+	}
+	if count > 0 && int64(len(events)) >= count {
+		return events, nil
+	}
+	if hasFirst {
+		if firstId == (streamId{}) {
+			return events, nil
+		}
+		endId, err := parseRangeEnd(end)
+		if err != nil {
+			return nil, err
+		}
+		if firstId.predecessor().Less(endId) {
+			end = firstId.predecessor().String()
+		}
+	}
+	if count > 0 {
+		count -= int64(len(events))
+	}
+	archived, err := s.archive.RevRange(ctx, stream, end, start, count)
+	if err != nil {
+		return nil, err
+	}
+	return append(events, archived...), nil
+}
+
+// Count includes the archived events before the first event of the live stream.
+func (s *archivingStreamService) Count(ctx context.Context, stream, start, end string) (int64, error) {
+	firstId, hasFirst, err := s.firstLiveId(ctx, stream)
+	if err != nil {
+		return 0, err
+	}
+	archived, err := s.archive.Range(ctx, stream, start, end, 0)
+	if err != nil {
+		return 0, err
+	}
+	var count int64
+	for _, event := range archived {
+		if id, err := parseStreamId(event.Id); err == nil && (!hasFirst || id.Less(firstId)) {
+			count++
+		}
+	}
+	live, err := s.StreamService.Count(ctx, stream, start, end)
+	return count + live, err
+}
+
+func (s *archivingStreamService) Get(ctx context.Context, stream, id string) (Event, error) {
+	event, err := s.StreamService.Get(ctx, stream, id)
+	if err != ErrEventNotFound {
+		return event, err
+	}
+	parsed, err := parseStreamId(id)
+	if err != nil {
+		return Event{}, err
+	}
+	archived, err := s.archive.Range(ctx, stream, parsed.String(), parsed.String(), 1)
+	if err != nil {
+		return Event{}, err
+	}
+	if len(archived) == 0 {
+		return Event{}, ErrEventNotFound
+	}
+	return archived[0], nil
+}
+
+func (s *archivingStreamService) Trim(ctx context.Context, stream, minId string) (int64, error) {
+	min, err := parseStreamId(minId)
+	if err != nil || min == (streamId{}) {
+		return 0, err
+	}
+	last, err := s.RevRange(ctx, stream, min.predecessor().String(), "-", 1)
+	if err != nil || len(last) == 0 {
+		return 0, err
+	}
+	count, err := s.StreamService.Trim(ctx, stream, minId)
+	if err != nil {
+		return count, err
+	}
+	archivedCount, err := s.archive.Trim(ctx, stream, minId)
+	if err != nil {
+		return count + archivedCount, err
+	}
+	return count + archivedCount, s.archive.SetMaxDeletedId(ctx, stream, last[0].Id)
+}
+
+// MaxDeletedId is recorded in the archive, as the wrapped service also counts the events moved to the archive.
+func (s *archivingStreamService) MaxDeletedId(ctx context.Context, stream string) (string, error) {
+	return s.archive.MaxDeletedId(ctx, stream)
+}
+
+func (s *archivingStreamService) firstLiveId(ctx context.Context, stream string) (streamId, bool, error) {
+	first, err := s.StreamService.Range(ctx, stream, "-", "+", 1)
+	if err != nil || len(first) == 0 {
+		return streamId{}, false, err
+	}
+	firstId, err := parseStreamId(first[0].Id)
+	return firstId, err == nil, err
+}
+
+func (s *archivingStreamService) Delete(ctx context.Context, stream string, ids ...string) (int64, error) {
+	count, err := s.StreamService.Delete(ctx, stream, ids...)
+	if err != nil {
+		return count, err
+	}
+	archivedCount, err := s.archive.Delete(ctx, stream, ids...)
+	return count + archivedCount, err
+}
+
+func (s *archivingStreamService) Expire(ctx context.Context, stream string, ids ...string) (int64, error) {
+	maxId, err := s.maxExistingId(ctx, stream, ids)
+	if err != nil {
+		return 0, err
+	}
+	count, err := s.StreamService.Expire(ctx, stream, ids...)
+	if err != nil {
+		return count, err
+	}
+	archivedCount, err := s.archive.Delete(ctx, stream, ids...)
+	if err != nil || maxId == "" {
+		return count + archivedCount, err
+	}
+	return count + archivedCount, s.archive.SetMaxDeletedId(ctx, stream, maxId)
+}
+
+// maxExistingId returns the greatest of ids having an event, or an empty string if none has.
+func (s *archivingStreamService) maxExistingId(ctx context.Context, stream string, ids []string) (string, error) {
+	parsed := make([]streamId, 0, len(ids))
+	for _, id := range ids {
+		p, err := parseStreamId(id)
+		if err != nil {
+			return "", err
+		}
+		parsed = append(parsed, p)
+	}
+	sort.Slice(parsed, func(i, j int) bool {
+		return parsed[j].Less(parsed[i])
+	})
+	for _, id := range parsed {
+		_, err := s.Get(ctx, stream, id.String())
+		if err == nil {
+			return id.String(), nil
+		}
+		if err != ErrEventNotFound {
+			return "", err
+		}
+	}
+	return "", nil
+}
+
+func (s *archivingStreamService) Reset(ctx context.Context, stream string) error {
+	if err := s.StreamService.Reset(ctx, stream); err != nil {
+		return err
+	}
+	return s.archive.Reset(ctx, stream)
+}
@@ -0,0 +1,345 @@
+package mypaste
+
+import (
+	"context"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testArchiveStores = map[string]func(t *testing.T) ArchiveStore{
+	"file": func(t *testing.T) ArchiveStore {
+		return NewFileArchiveStore(t.TempDir())
+	},
+	"s3": func(t *testing.T) ArchiveStore {
+		server := httptest.NewServer(newFakeS3Handler())
+		t.Cleanup(server.Close)
+		archive, err := OpenArchiveStore("s3://key:secret@bucket/archive?endpoint=" + url.QueryEscape(server.URL))
+		require.NoError(t, err)
+		return archive
+	},
+}
+
+func TestArchiveStore(t *testing.T) {
+	for name, newArchive := range testArchiveStores {
+		t.Run(name, func(t *testing.T) {
+			archive := newArchive(t)
+			ctx := context.Background()
+			events := testArchiveEvents(5)
+
+			require.NoError(t, archive.Append(ctx, "email", events[:3]))
+			// already archived events are skipped
+			require.NoError(t, archive.Append(ctx, "email", events[2:]))
+			require.NoError(t, archive.Append(ctx, "email2", events[:1]))
+
+			res, err := archive.ReadAfter(ctx, "email", "0", 0)
+			require.NoError(t, err)
+			assert.Equal(t, events, res)
+
+			res, err = archive.ReadAfter(ctx, "email", events[1].Id, 2)
+			require.NoError(t, err)
+			assert.Equal(t, events[2:4], res)
+
+			res, err = archive.Range(ctx, "email", events[1].Id, events[3].Id, 0)
+			require.NoError(t, err)
+			assert.Equal(t, events[1:4], res)
+
+			res, err = archive.Range(ctx, "email", "-", "+", 2)
+			require.NoError(t, err)
+			assert.Equal(t, events[:2], res)
+
+			res, err = archive.RevRange(ctx, "email", events[3].Id, events[1].Id, 0)
+			require.NoError(t, err)
+			assert.Equal(t, []Event{events[3], events[2], events[1]}, res)
+
+			res, err = archive.RevRange(ctx, "email", "+", "-", 2)
+			require.NoError(t, err)
+			assert.Equal(t, []Event{events[4], events[3]}, res)
+
+			count, err := archive.Delete(ctx, "email", events[0].Id, events[3].Id)
+			require.NoError(t, err)
+			assert.Equal(t, int64(2), count)
+
+			res, err = archive.ReadAfter(ctx, "email", "0", 0)
+			require.NoError(t, err)
+			assert.Equal(t, []Event{events[1], events[2], events[4]}, res)
+
+			count, err = archive.Trim(ctx, "email", events[2].Id)
+			require.NoError(t, err)
+			assert.Equal(t, int64(1), count)
+
+			res, err = archive.ReadAfter(ctx, "email", "0", 0)
+			require.NoError(t, err)
+			assert.Equal(t, []Event{events[2], events[4]}, res)
+
+			require.NoError(t, archive.Reset(ctx, "email"))
+			res, err = archive.ReadAfter(ctx, "email", "0", 0)
+			require.NoError(t, err)
+			assert.Empty(t, res)
+
+			res, err = archive.ReadAfter(ctx, "email2", "0", 0)
+			require.NoError(t, err)
+			assert.Equal(t, events[:1], res)
+		})
+	}
+}
+
+func TestArchivingStreamService(t *testing.T) {
+	for name, newArchive := range testArchiveStores {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			inner := NewMemoryStreamService(StreamConfig{ReadCount: 100, ReadBlock: -1})
+			svc := NewArchivingStreamService(inner, newArchive(t), ArchiveConfig{MaxLen: 3, BatchSize: 2, ReadCount: 4})
+
+			var added []Event
+			for i := 0; i < 10; i++ {
+				event, err := svc.Add(ctx, "email", Event{Payload: "hello"})
+				require.NoError(t, err)
+				added = append(added, event)
+			}
+			length, err := inner.Len(ctx, "email")
+			require.NoError(t, err)
+			assert.LessOrEqual(t, length, int64(5))
+
+			// a device that missed the trimmed events pages through the archive
+			var events []Event
+			lastId := added[0].Id
+			for lastId != added[len(added)-1].Id {
+				res, err := svc.Read(ctx, "email", lastId)
+				require.NoError(t, err)
+				require.NotEmpty(t, res)
+				events = append(events, res...)
+				lastId = res[len(res)-1].Id
+			}
+			assert.Equal(t, added[1:], events)
+
+			// older pages continue from the archive
+			page, err := svc.RevRange(ctx, "email", "+", "-", 8)
+			require.NoError(t, err)
+			require.Len(t, page, 8)
+			for i, event := range page {
+				assert.Equal(t, added[9-i], event)
+			}
+			page, err = svc.RevRange(ctx, "email", added[2].Id, "-", 0)
+			require.NoError(t, err)
+			assert.Equal(t, []Event{added[2], added[1], added[0]}, page)
+
+			event, err := svc.Get(ctx, "email", added[0].Id)
+			require.NoError(t, err)
+			assert.Equal(t, added[0], event)
+
+			count, err := svc.Delete(ctx, "email", added[1].Id, added[9].Id)
+			require.NoError(t, err)
+			assert.Equal(t, int64(2), count)
+			res, err := svc.Read(ctx, "email", added[0].Id)
+			require.NoError(t, err)
+			assert.Equal(t, added[2], res[0])
+
+			require.NoError(t, svc.Reset(ctx, "email"))
+			res, err = svc.Read(ctx, "email", added[0].Id)
+			require.NoError(t, err)
+			assert.Empty(t, res)
+		})
+	}
+}
+
+func TestArchiveStoreTouchesCoveringSegments(t *testing.T) {
+	ctx := context.Background()
+	bucket := &countingArchiveBucket{archiveBucket: &fileArchiveBucket{dir: t.TempDir()}}
+	archive := newSegmentArchiveStore(bucket)
+	events := testArchiveEvents(6)
+	for i := 0; i < len(events); i += 2 {
+		require.NoError(t, archive.Append(ctx, "email", events[i:i+2]))
+	}
+	assert.Zero(t, bucket.gets, "should not read the last segment to skip archived events")
+
+	count, err := archive.Delete(ctx, "email", events[3].Id, streamId{Ms: 1}.String())
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), count)
+	assert.Equal(t, []string{bucket.keys[1]}, bucket.got, "should read only the segment covering the id")
+
+	bucket.got = nil
+	count, err = archive.Trim(ctx, "email", events[1].Id)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), count)
+	assert.Equal(t, []string{bucket.keys[0]}, bucket.got, "should read only the segments before min id")
+
+	res, err := archive.ReadAfter(ctx, "email", "0", 0)
+	require.NoError(t, err)
+	assert.Equal(t, []Event{events[1], events[2], events[4], events[5]}, res)
+
+	// segments named only by their first id are still read
+	require.NoError(t, bucket.Delete(ctx, bucket.keys[2]))
+	require.NoError(t, archive.writeSegment(ctx, "email/"+events[4].Id+".jsonl", events[4:]))
+	count, err = archive.Delete(ctx, "email", events[5].Id)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), count)
+	res, err = archive.ReadAfter(ctx, "email", "0", 0)
+	require.NoError(t, err)
+	assert.Equal(t, []Event{events[1], events[2], events[4]}, res)
+}
+
+func TestArchiveSegmentEncoding(t *testing.T) {
+	reported := captureCorruptEvents(t)
+	ctx := context.Background()
+	bucket := &fileArchiveBucket{dir: t.TempDir()}
+	archive := newSegmentArchiveStore(bucket)
+	events := testArchiveEvents(2)
+	require.NoError(t, archive.Append(ctx, "email", events[1:]))
+	keys, err := bucket.List(ctx, "email/")
+	require.NoError(t, err)
+	require.Len(t, keys, 1)
+	data, err := bucket.Get(ctx, keys[0])
+	require.NoError(t, err)
+	assert.Contains(t, string(data), `"V":2`)
+
+	// a segment from before events were versioned, with a corrupt line
+	legacy := `{"Id":"` + events[0].Id + `","Payload":"legacy"}` + "\n" + `{"Id":` + "\n" + `{"Id":"1-0","V":2,"Json":"{"}` + "\n"
+	require.NoError(t, bucket.Put(ctx, "email/"+events[0].Id+"_"+events[0].Id+".jsonl", []byte(legacy)))
+	res, err := archive.ReadAfter(ctx, "email", "0", 0)
+	require.NoError(t, err)
+	assert.Equal(t, []Event{{Id: events[0].Id, Payload: "legacy"}, events[1]}, res, "should skip only the corrupt lines")
+	require.Len(t, *reported, 2)
+	assert.Equal(t, "email", (*reported)[0].Stream)
+	assert.Equal(t, "1-0", (*reported)[1].Id)
+}
+
+func TestArchivingStreamServiceReadFromStart(t *testing.T) {
+	ctx := context.Background()
+	inner := NewMemoryStreamService(StreamConfig{ReadCount: 100, ReadBlock: -1})
+	svc := NewArchivingStreamService(inner, NewFileArchiveStore(t.TempDir()), ArchiveConfig{MaxLen: 2, BatchSize: 1, ReadCount: 10})
+	var added []Event
+	for i := 0; i < 6; i++ {
+		event, err := svc.Add(ctx, "email", Event{Payload: "hello"})
+		require.NoError(t, err)
+		added = append(added, event)
+	}
+
+	for _, lastId := range []string{"", "0"} {
+		res, err := svc.Read(ctx, "email", lastId)
+		require.NoError(t, err)
+		require.NotEmpty(t, res)
+		assert.Equal(t, added[0], res[0], "should start from the archive for %q", lastId)
+	}
+}
+
+// countingArchiveBucket records the segments read and the keys written.
+type countingArchiveBucket struct {
+	archiveBucket
+	gets int
+	got  []string
+	keys []string
+}
+
+func (b *countingArchiveBucket) Get(ctx context.Context, key string) ([]byte, error) {
+	b.gets++
+	b.got = append(b.got, key)
+	return b.archiveBucket.Get(ctx, key)
+}
+
+func (b *countingArchiveBucket) Put(ctx context.Context, key string, data []byte) error {
+	if !slices.Contains(b.keys, key) {
+		b.keys = append(b.keys, key)
+	}
+	return b.archiveBucket.Put(ctx, key, data)
+}
+
+func TestParseS3URL(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "env-key")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "env-secret")
+
+	bucket, err := parseS3URL(mustParseURL(t, "s3://bucket/some/prefix/?region=eu-west-1"))
+	require.NoError(t, err)
+	assert.Equal(t, "bucket", bucket.bucket)
+	assert.Equal(t, "some/prefix/", bucket.prefix)
+	assert.Equal(t, "https://s3.eu-west-1.amazonaws.com", bucket.endpoint.String())
+	assert.Equal(t, "env-key", bucket.accessKey)
+	assert.Equal(t, "env-secret", bucket.secretKey)
+
+	bucket, err = parseS3URL(mustParseURL(t, "s3://key:secret@bucket?endpoint=http://localhost:9000"))
+	require.NoError(t, err)
+	assert.Equal(t, "", bucket.prefix)
+	assert.Equal(t, "us-east-1", bucket.region)
+	assert.Equal(t, "http://localhost:9000", bucket.endpoint.String())
+	assert.Equal(t, "key", bucket.accessKey)
+	assert.Equal(t, "secret", bucket.secretKey)
+
+	_, err = parseS3URL(mustParseURL(t, "s3:///prefix"))
+	assert.Error(t, err)
+}
+
+func testArchiveEvents(n int) []Event {
+	events := make([]Event, n)
+	for i := range events {
+		events[i] = Event{
+			Id:        streamId{Ms: uint64(time.Now().UnixMilli()), Seq: uint64(i)}.String(),
+			Payload:   "hello",
+			Timestamp: time.Now().Unix(),
+		}
+	}
+	return events
+}
+
+// newFakeS3Handler serves the subset of the S3 API used by s3ArchiveBucket from memory.
+func newFakeS3Handler() http.Handler {
+	var mtx sync.Mutex
+	objects := make(map[string][]byte)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mtx.Lock()
+		defer mtx.Unlock()
+
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=key/") {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+		if bucket != "bucket" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		switch {
+		case r.Method == http.MethodGet && key == "":
+			var result struct {
+				XMLName  xml.Name `xml:"ListBucketResult"`
+				Contents []struct{ Key string }
+			}
+			keys := make([]string, 0)
+			for k := range objects {
+				if strings.HasPrefix(k, r.URL.Query().Get("prefix")) {
+					keys = append(keys, k)
+				}
+			}
+			sort.Strings(keys)
+			for _, k := range keys {
+				result.Contents = append(result.Contents, struct{ Key string }{k})
+			}
+			xml.NewEncoder(w).Encode(result)
+		case r.Method == http.MethodGet:
+			data, ok := objects[key]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.Write(data)
+		case r.Method == http.MethodPut:
+			data, _ := io.ReadAll(r.Body)
+			objects[key] = data
+		case r.Method == http.MethodDelete:
+			delete(objects, key)
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	})
+}
@@ -2,8 +2,10 @@ package mypaste
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
@@ -14,12 +16,16 @@ import (
 
 type TokenClaims struct {
 	User
+	// Scopes limit the access of an api token, ApiTokenId is empty for a browser login with full access.
+	Scopes     []string `json:",omitempty"`
+	ApiTokenId string   `json:",omitempty"`
 	jwt.RegisteredClaims
 }
 
 const (
 	tokenCookieName = "my_paste_token"
 	csrfCookieName  = "g_csrf_token"
+	sessionTTL      = 72 * time.Hour
 )
 
 type IdTokenValidator interface {
@@ -38,7 +44,9 @@ func (v *idTokenValidator) Validate(ctx context.Context, idToken string) (*idtok
 	return idtoken.Validate(ctx, idToken, v.gClientId)
 }
 
-func NewAuthMiddleware(jwtSignKey string) echo.MiddlewareFunc {
+// NewAuthMiddleware accepts the login jwt of an unrevoked session from the Authorization header or the token cookie,
+// and api tokens from the Authorization header.
+func NewAuthMiddleware(jwtSignKey string, sessions SessionStore, apiTokens ApiTokenStore) echo.MiddlewareFunc {
 	config := echojwt.Config{
 		TokenLookup: "header:Authorization:Bearer ,cookie:" + tokenCookieName,
 		NewClaimsFunc: func(c echo.Context) jwt.Claims {
@@ -46,41 +54,101 @@ func NewAuthMiddleware(jwtSignKey string) echo.MiddlewareFunc {
 		},
 		SigningKey: []byte(jwtSignKey),
 	}
-	return echojwt.WithConfig(config)
+	jwtmw := echojwt.WithConfig(config)
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		jwtNext := jwtmw(checkSession(sessions, next))
+		return func(c echo.Context) error {
+			auth := c.Request().Header.Get(echo.HeaderAuthorization)
+			if secret, ok := strings.CutPrefix(auth, "Bearer "); ok && isApiToken(secret) {
+				return authorizeApiToken(c, apiTokens, secret, next)
+			}
+			return jwtNext(c)
+		}
+	}
+}
+
+func checkSession(sessions SessionStore, next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		claims := getTokenClaims(c)
+		ctx := c.Request().Context()
+		session, err := sessions.GetSession(ctx, claims.Email, claims.ID)
+		if err == nil {
+			err = touchSession(ctx, sessions, claims.Email, session, c.RealIP(), c.Request().UserAgent())
+		}
+		if errors.Is(err, ErrSessionNotFound) {
+			return c.String(http.StatusUnauthorized, "session expired or revoked")
+		}
+		if err != nil {
+			return c.String(http.StatusInternalServerError, err.Error())
+		}
+		return next(c)
+	}
+}
+
+// checkRevoked returns ErrSessionNotFound or ErrApiTokenNotFound once the session or api token of claims is revoked,
+// long lived requests call it again after the auth middleware accepted them.
+func checkRevoked(ctx context.Context, sessions SessionStore, apiTokens ApiTokenStore, claims *TokenClaims) error {
+	if claims.ApiTokenId == "" {
+		_, err := sessions.GetSession(ctx, claims.Email, claims.ID)
+		return err
+	}
+	tokens, err := apiTokens.GetApiTokens(ctx, claims.Email)
+	if err != nil {
+		return err
+	}
+	for _, token := range tokens {
+		if token.Id == claims.ApiTokenId {
+			return nil
+		}
+	}
+	return ErrApiTokenNotFound
+}
+
+func isRevoked(err error) bool {
+	return errors.Is(err, ErrSessionNotFound) || errors.Is(err, ErrApiTokenNotFound)
 }
 
 func GetAuthorizedUser(c echo.Context) User {
-	token := c.Get("user").(*jwt.Token)
-	return token.Claims.(*TokenClaims).User
+	return getTokenClaims(c).User
+}
+
+// LoginProvider is a sign in link shown on the login page beside google sign in.
+type LoginProvider struct {
+	Name     string
+	LoginUrl string
 }
 
-func LoginPageHandler(gClientId, callbackUri string) echo.HandlerFunc {
+func LoginPageHandler(gClientId, callbackUri string, providers ...LoginProvider) echo.HandlerFunc {
 	loginTemplateData := struct {
 		GoogleClientId   string
 		LoginCallbackUri string
-	}{gClientId, callbackUri}
+		Providers        []LoginProvider
+	}{gClientId, callbackUri, providers}
 	return func(c echo.Context) error {
 		return c.Render(http.StatusOK, "login", loginTemplateData)
 	}
 }
 
-func LoginCallbackHandler(validator IdTokenValidator, jwtSignKey string) echo.HandlerFunc {
+func LoginCallbackHandler(validator IdTokenValidator, sessions SessionStore, jwtSignKey string) echo.HandlerFunc {
 	return func(c echo.Context) error {
 		user, err := validateGoogleSignin(c, validator)
 		if err != nil {
 			return handleLoginError(c, err)
 		}
-		token := generateToken(*user)
-		signedToken, err := token.SignedString([]byte(jwtSignKey))
-		if err != nil {
-			return handleLoginError(c, fmt.Errorf("failed to sign token. %w", err))
-		}
-		c.Logger().Infof("login success, email: %v", user.Email)
-		c.SetCookie(newTokenCookie(signedToken))
-		return c.Redirect(http.StatusSeeOther, "/")
+		return loginSuccess(c, sessions, *user, jwtSignKey)
 	}
 }
 
+func loginSuccess(c echo.Context, sessions SessionStore, user User, jwtSignKey string) error {
+	signedToken, err := startSession(c.Request().Context(), sessions, user, c.RealIP(), c.Request().UserAgent(), jwtSignKey)
+	if err != nil {
+		return handleLoginError(c, err)
+	}
+	c.Logger().Infof("login success, email: %v", user.Email)
+	c.SetCookie(newTokenCookie(signedToken))
+	return c.Redirect(http.StatusSeeOther, "/")
+}
+
 func validateGoogleSignin(c echo.Context, v IdTokenValidator) (*User, error) {
 	body := new(struct {
 		Credential string `form:"credential"`
@@ -122,10 +190,38 @@ func handleLoginError(c echo.Context, err error) error {
 	return c.Redirect(http.StatusSeeOther, "/login-failed")
 }
 
-func generateToken(user User) *jwt.Token {
+// startSession records a new session of user and signs a login jwt carrying the session id.
+func startSession(ctx context.Context, sessions SessionStore, user User, ip, userAgent, jwtSignKey string) (string, error) {
+	id, err := randomString()
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	session := Session{
+		Id:         id,
+		CreatedAt:  now.Unix(),
+		LastUsedAt: now.Unix(),
+		ExpiresAt:  now.Add(sessionTTL).Unix(),
+		Ip:         ip,
+		UserAgent:  userAgent,
+	}
+	if err := sessions.SetSession(ctx, user.Email, session); err != nil {
+		return "", fmt.Errorf("failed to add session, %w", err)
+	}
+	signedToken, err := generateToken(user, session.Id).SignedString([]byte(jwtSignKey))
+	if err != nil {
+		return "", fmt.Errorf("failed to sign token. %w", err)
+	}
+	return signedToken, nil
+}
+
+func generateToken(user User, sessionId string) *jwt.Token {
 	claims := &TokenClaims{
-		user,
-		jwt.RegisteredClaims{ExpiresAt: jwt.NewNumericDate(time.Now().Add(72 * time.Hour))},
+		User: user,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        sessionId,
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(sessionTTL)),
+		},
 	}
 	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 }
@@ -138,7 +234,7 @@ func newTokenCookie(token string) *http.Cookie {
 		HttpOnly: true,
 		Secure:   true,
 		SameSite: http.SameSiteStrictMode,
-		Expires:  time.Now().Add(72 * time.Hour),
+		Expires:  time.Now().Add(sessionTTL),
 	}
 }
 
@@ -150,21 +246,56 @@ func expiredTokenCookie() *http.Cookie {
 	}
 }
 
-func AuthenticateHandler(jwtSignKey string) echo.HandlerFunc {
+// AuthenticateHandler extends the session of the request and re-mints its login jwt.
+func AuthenticateHandler(sessions SessionStore, jwtSignKey string) echo.HandlerFunc {
 	return func(c echo.Context) error {
-		user := GetAuthorizedUser(c)
-		token := generateToken(user)
-		signedToken, err := token.SignedString([]byte(jwtSignKey))
+		claims := getTokenClaims(c)
+		ctx := c.Request().Context()
+		session, err := sessions.GetSession(ctx, claims.Email, claims.ID)
+		if errors.Is(err, ErrSessionNotFound) {
+			return c.String(http.StatusUnauthorized, err.Error())
+		}
+		if err != nil {
+			return c.String(http.StatusInternalServerError, err.Error())
+		}
+		session.ExpiresAt = time.Now().Add(sessionTTL).Unix()
+		err = sessions.UpdateSession(ctx, claims.Email, session)
+		if errors.Is(err, ErrSessionNotFound) {
+			return c.String(http.StatusUnauthorized, err.Error())
+		}
+		if err != nil {
+			return c.String(http.StatusInternalServerError, err.Error())
+		}
+		signedToken, err := generateToken(claims.User, session.Id).SignedString([]byte(jwtSignKey))
 		if err != nil {
 			return fmt.Errorf("failed to sign token. %w", err)
 		}
 		c.SetCookie(newTokenCookie(signedToken))
-		return c.JSON(http.StatusOK, user)
+		return c.JSON(http.StatusOK, claims.User)
 	}
 }
 
-func LogoutHandler() echo.HandlerFunc {
+// LogoutHandler revokes the session of the request.
+func LogoutHandler(sessions SessionStore) echo.HandlerFunc {
 	return func(c echo.Context) error {
+		claims := getTokenClaims(c)
+		err := sessions.DeleteSession(c.Request().Context(), claims.Email, claims.ID)
+		if err != nil && !errors.Is(err, ErrSessionNotFound) {
+			return c.String(http.StatusInternalServerError, err.Error())
+		}
+		c.SetCookie(expiredTokenCookie())
+		return c.NoContent(http.StatusOK)
+	}
+}
+
+// LogoutEverywhereHandler revokes every session of the user, signing out all browsers and grpc clients.
+func LogoutEverywhereHandler(sessions SessionStore) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		user := GetAuthorizedUser(c)
+		if err := sessions.DeleteSessions(c.Request().Context(), user.Email); err != nil {
+			return c.String(http.StatusInternalServerError, err.Error())
+		}
+		c.Logger().Infof("signed out everywhere, email: %v", user.Email)
 		c.SetCookie(expiredTokenCookie())
 		return c.NoContent(http.StatusOK)
 	}
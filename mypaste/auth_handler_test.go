@@ -1,6 +1,7 @@
 package mypaste
 
 import (
+	"context"
 	"errors"
 	"net/http"
 	"net/http/httptest"
@@ -10,6 +11,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/golang-jwt/jwt/v5"
 	"github.com/labstack/echo/v4"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -23,6 +25,7 @@ func TestLoginCallbackHandler(t *testing.T) {
 		jwtSignKey        = "secret"
 		headerContentType = "Content-Type"
 	)
+	svc := NewMemoryStreamService(StreamConfig{})
 
 	newRequest := func(form url.Values, cookie *http.Cookie) *http.Request {
 		req := httptest.NewRequest(http.MethodPost, "/login-callback", strings.NewReader(form.Encode()))
@@ -59,12 +62,12 @@ func TestLoginCallbackHandler(t *testing.T) {
 		mockV := NewMockIdTokenValidator(t)
 		mockV.EXPECT().Validate(c.Request().Context(), "cred").Return(payload, nil)
 
-		err := LoginCallbackHandler(mockV, jwtSignKey)(c)
+		err := LoginCallbackHandler(mockV, svc, jwtSignKey)(c)
 
 		require.NoError(t, err)
 		assert.Equal(t, http.StatusSeeOther, rec.Result().StatusCode)
 		assert.Equal(t, "/", rec.Result().Header.Get("Location"))
-		assertResponseTokenCookie(t, rec.Result(), jwtSignKey)
+		assertResponseTokenCookie(t, rec.Result(), svc, jwtSignKey)
 	})
 
 	t.Run("fail if wrong content type", func(t *testing.T) {
@@ -75,7 +78,7 @@ func TestLoginCallbackHandler(t *testing.T) {
 		rec := httptest.NewRecorder()
 		c := echo.New().NewContext(req, rec)
 
-		err := LoginCallbackHandler(nil, jwtSignKey)(c)
+		err := LoginCallbackHandler(nil, svc, jwtSignKey)(c)
 
 		require.NoError(t, err)
 		assertFailedResponse(t, rec.Result())
@@ -87,7 +90,7 @@ func TestLoginCallbackHandler(t *testing.T) {
 		rec := httptest.NewRecorder()
 		c := echo.New().NewContext(req, rec)
 
-		err := LoginCallbackHandler(nil, jwtSignKey)(c)
+		err := LoginCallbackHandler(nil, svc, jwtSignKey)(c)
 
 		require.NoError(t, err)
 		assertFailedResponse(t, rec.Result())
@@ -99,7 +102,7 @@ func TestLoginCallbackHandler(t *testing.T) {
 		rec := httptest.NewRecorder()
 		c := echo.New().NewContext(req, rec)
 
-		err := LoginCallbackHandler(nil, jwtSignKey)(c)
+		err := LoginCallbackHandler(nil, svc, jwtSignKey)(c)
 
 		require.NoError(t, err)
 		assertFailedResponse(t, rec.Result())
@@ -112,7 +115,7 @@ func TestLoginCallbackHandler(t *testing.T) {
 		rec := httptest.NewRecorder()
 		c := echo.New().NewContext(req, rec)
 
-		err := LoginCallbackHandler(nil, jwtSignKey)(c)
+		err := LoginCallbackHandler(nil, svc, jwtSignKey)(c)
 
 		require.NoError(t, err)
 		assertFailedResponse(t, rec.Result())
@@ -125,7 +128,7 @@ func TestLoginCallbackHandler(t *testing.T) {
 		rec := httptest.NewRecorder()
 		c := echo.New().NewContext(req, rec)
 
-		err := LoginCallbackHandler(nil, jwtSignKey)(c)
+		err := LoginCallbackHandler(nil, svc, jwtSignKey)(c)
 
 		require.NoError(t, err)
 		assertFailedResponse(t, rec.Result())
@@ -141,7 +144,7 @@ func TestLoginCallbackHandler(t *testing.T) {
 		mockV := NewMockIdTokenValidator(t)
 		mockV.EXPECT().Validate(c.Request().Context(), "cred").Return(nil, errors.New("invalid idtoken"))
 
-		err := LoginCallbackHandler(mockV, jwtSignKey)(c)
+		err := LoginCallbackHandler(mockV, svc, jwtSignKey)(c)
 
 		require.NoError(t, err)
 		assertFailedResponse(t, rec.Result())
@@ -151,33 +154,94 @@ func TestLoginCallbackHandler(t *testing.T) {
 
 func TestAuthenticateHandler(t *testing.T) {
 	const jwtSignKey = "secret"
-	req := httptest.NewRequest(http.MethodPost, "/", nil)
-	rec := httptest.NewRecorder()
-	c := echo.New().NewContext(req, rec)
-	c.Set("user", generateToken(User{"name", "email"}))
+	svc := NewMemoryStreamService(StreamConfig{})
+	c, rec := sessionContextT(t, svc, jwtSignKey, "email")
 
-	err := AuthenticateHandler(jwtSignKey)(c)
+	err := AuthenticateHandler(svc, jwtSignKey)(c)
 
 	require.NoError(t, err)
 	assert.Equal(t, http.StatusOK, rec.Result().StatusCode)
-	assertResponseTokenCookie(t, rec.Result(), jwtSignKey)
+	assertResponseTokenCookie(t, rec.Result(), svc, jwtSignKey)
+	assert.Equal(t, getTokenClaims(c).ID, parseTokenCookieT(t, rec.Result(), jwtSignKey).ID, "should keep the session")
 }
 
 func TestMakeLogoutHandler(t *testing.T) {
-	req := httptest.NewRequest(http.MethodPost, "/", nil)
-	rec := httptest.NewRecorder()
-	c := echo.New().NewContext(req, rec)
+	const jwtSignKey = "secret"
+	svc := NewMemoryStreamService(StreamConfig{})
+	c, rec := sessionContextT(t, svc, jwtSignKey, "email")
+	other, _ := sessionContextT(t, svc, jwtSignKey, "email")
 
-	err := LogoutHandler()(c)
+	err := LogoutHandler(svc)(c)
 
 	require.NoError(t, err)
 	tokenCookie := getResponseTokenCookie(rec.Result())
 	require.NotNil(t, tokenCookie, "should return token cookie")
 	assert.Empty(t, tokenCookie.Value)
 	assert.Less(t, tokenCookie.Expires, time.Now())
+	_, err = svc.GetSession(context.Background(), "email", getTokenClaims(c).ID)
+	assert.ErrorIs(t, err, ErrSessionNotFound, "should revoke the session")
+	_, err = svc.GetSession(context.Background(), "email", getTokenClaims(other).ID)
+	assert.NoError(t, err, "should keep other sessions")
+}
+
+func TestLogoutEverywhereHandler(t *testing.T) {
+	const jwtSignKey = "secret"
+	svc := NewMemoryStreamService(StreamConfig{})
+	c, rec := sessionContextT(t, svc, jwtSignKey, "email")
+	other, _ := sessionContextT(t, svc, jwtSignKey, "email")
+	otherUser, _ := sessionContextT(t, svc, jwtSignKey, "email2")
+
+	err := LogoutEverywhereHandler(svc)(c)
+
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	for _, c := range []echo.Context{c, other} {
+		_, err = svc.GetSession(context.Background(), "email", getTokenClaims(c).ID)
+		assert.ErrorIs(t, err, ErrSessionNotFound)
+	}
+	_, err = svc.GetSession(context.Background(), "email2", getTokenClaims(otherUser).ID)
+	assert.NoError(t, err, "should keep sessions of other users")
+}
+
+func TestAuthMiddlewareSession(t *testing.T) {
+	const jwtSignKey = "secret"
+	svc := NewMemoryStreamService(StreamConfig{})
+	token, err := startSession(context.Background(), svc, User{"name", "email"}, "", "", jwtSignKey)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, authorizeT(svc, jwtSignKey, token, nil))
+
+	require.NoError(t, svc.DeleteSessions(context.Background(), "email"))
+	assert.Equal(t, http.StatusUnauthorized, authorizeT(svc, jwtSignKey, token, nil), "should reject revoked session")
+
+	noSession, err := generateToken(User{"name", "email"}, "").SignedString([]byte(jwtSignKey))
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusUnauthorized, authorizeT(svc, jwtSignKey, noSession, nil), "should reject token without session")
+}
+
+// sessionContextT returns a context authorized by the login jwt of a new session.
+func sessionContextT(t *testing.T, svc Store, jwtSignKey, email string) (echo.Context, *httptest.ResponseRecorder) {
+	token, err := startSession(context.Background(), svc, User{"name", email}, "", "", jwtSignKey)
+	require.NoError(t, err)
+	claims := new(TokenClaims)
+	parsed, err := jwt.ParseWithClaims(token, claims, func(token *jwt.Token) (interface{}, error) { return []byte(jwtSignKey), nil })
+	require.NoError(t, err)
+	req := httptest.NewRequest(http.MethodPost, "/", nil)
+	rec := httptest.NewRecorder()
+	c := echo.New().NewContext(req, rec)
+	c.Set("user", parsed)
+	return c, rec
+}
+
+func parseTokenCookieT(t *testing.T, resp *http.Response, jwtSignKey string) *TokenClaims {
+	tokenCookie := getResponseTokenCookie(resp)
+	require.NotNil(t, tokenCookie, "should return token cookie")
+	claims := new(TokenClaims)
+	_, err := jwt.ParseWithClaims(tokenCookie.Value, claims, func(token *jwt.Token) (interface{}, error) { return []byte(jwtSignKey), nil })
+	require.NoError(t, err)
+	return claims
 }
 
-func assertResponseTokenCookie(t *testing.T, resp *http.Response, jwtSignKey string) {
+func assertResponseTokenCookie(t *testing.T, resp *http.Response, svc Store, jwtSignKey string) {
 	tokenCookie := getResponseTokenCookie(resp)
 	require.NotNil(t, tokenCookie, "should return token cookie")
 
@@ -189,7 +253,7 @@ func assertResponseTokenCookie(t *testing.T, resp *http.Response, jwtSignKey str
 	req.AddCookie(tokenCookie)
 	rec := httptest.NewRecorder()
 	c := echo.New().NewContext(req, rec)
-	authmw := NewAuthMiddleware(jwtSignKey)
+	authmw := NewAuthMiddleware(jwtSignKey, svc, svc)
 	okHandler := func(c echo.Context) error { return c.NoContent(http.StatusOK) }
 
 	err := authmw(okHandler)(c)
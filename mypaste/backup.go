@@ -0,0 +1,159 @@
+package mypaste
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+)
+
+const (
+	backupFormat  = "mypaste-backup"
+	backupVersion = 1
+)
+
+// backupHeader is the first line of a backup, the remaining lines are backupRecords.
+type backupHeader struct {
+	Format    string
+	Version   int
+	CreatedAt int64
+}
+
+// backupRecord holds either the devices or a batch of events of a stream.
+type backupRecord struct {
+	Stream  string
+	Devices []Device `json:",omitempty"`
+	Events  []Event  `json:",omitempty"`
+}
+
+// Backup writes all streams and devices as gzipped json lines.
+// Events moved to an archive are included only if svc is an archiving stream service.
+func Backup(ctx context.Context, svc StreamService, w io.Writer, batchSize int64, out io.Writer) error {
+	streams, err := svc.Streams(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list streams, %w", err)
+	}
+	gz := gzip.NewWriter(w)
+	encoder := json.NewEncoder(gz)
+	err = encoder.Encode(backupHeader{Format: backupFormat, Version: backupVersion, CreatedAt: time.Now().Unix()})
+	if err != nil {
+		return err
+	}
+	for i, stream := range streams {
+		count, err := backupStream(ctx, svc, encoder, stream, batchSize)
+		if err != nil {
+			return fmt.Errorf("failed to backup stream %v, %w", stream, err)
+		}
+		fmt.Fprintf(out, "[%v/%v] %v: %v events\n", i+1, len(streams), stream, count)
+	}
+	return gz.Close()
+}
+
+func backupStream(ctx context.Context, svc StreamService, encoder *json.Encoder, stream string, batchSize int64) (int, error) {
+	devices, err := svc.GetDevices(ctx, stream)
+	if err != nil {
+		return 0, err
+	}
+	if err := encoder.Encode(backupRecord{Stream: stream, Devices: devices}); err != nil {
+		return 0, err
+	}
+	count := 0
+	start := "-"
+	for {
+		events, err := svc.Range(ctx, stream, start, "+", batchSize)
+		if err != nil || len(events) == 0 {
+			return count, err
+		}
+		if err := encoder.Encode(backupRecord{Stream: stream, Events: events}); err != nil {
+			return count, err
+		}
+		count += len(events)
+		lastId, err := parseStreamId(events[len(events)-1].Id)
+		if err != nil {
+			return count, err
+		}
+		start = lastId.successor().String()
+	}
+}
+
+// Restore loads a backup into an empty store, or only the given stream when stream is not empty.
+func Restore(ctx context.Context, svc StreamService, r io.Reader, stream string, out io.Writer) error {
+	if err := checkRestoreTarget(ctx, svc, stream); err != nil {
+		return err
+	}
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return fmt.Errorf("invalid backup, %w", err)
+	}
+	scanner := bufio.NewScanner(gz)
+	scanner.Buffer(nil, 1<<26)
+	if !scanner.Scan() {
+		return fmt.Errorf("invalid backup, missing header")
+	}
+	var header backupHeader
+	if err := json.Unmarshal(scanner.Bytes(), &header); err != nil || header.Format != backupFormat {
+		return fmt.Errorf("invalid backup, unknown format")
+	}
+	if header.Version > backupVersion {
+		return fmt.Errorf("unsupported backup version: %v", header.Version)
+	}
+	counts := make(map[string]int64)
+	for scanner.Scan() {
+		var record backupRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return fmt.Errorf("invalid backup record, %w", err)
+		}
+		if stream != "" && record.Stream != stream {
+			continue
+		}
+		for _, device := range record.Devices {
+			if _, err := svc.AddDevice(ctx, record.Stream, device); err != nil {
+				return fmt.Errorf("failed to restore devices of %v, %w", record.Stream, err)
+			}
+		}
+		if _, ok := counts[record.Stream]; !ok {
+			fmt.Fprintf(out, "restoring %v\n", record.Stream)
+		}
+		count, err := svc.Import(ctx, record.Stream, record.Events)
+		if err != nil {
+			return fmt.Errorf("failed to restore events of %v, %w", record.Stream, err)
+		}
+		counts[record.Stream] += count
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("invalid backup, %w", err)
+	}
+	if _, ok := counts[stream]; stream != "" && !ok {
+		return fmt.Errorf("stream not found in backup: %v", stream)
+	}
+	fmt.Fprintf(out, "restored %v streams\n", len(counts))
+	return nil
+}
+
+func checkRestoreTarget(ctx context.Context, svc StreamService, stream string) error {
+	if stream == "" {
+		streams, err := svc.Streams(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to list streams, %w", err)
+		}
+		if len(streams) > 0 {
+			return fmt.Errorf("store is not empty, %v streams found", len(streams))
+		}
+		return nil
+	}
+	length, err := svc.Len(ctx, stream)
+	if err != nil {
+		return err
+	}
+	devices, err := svc.GetDevices(ctx, stream)
+	if err != nil {
+		return err
+	}
+	if length > 0 || len(devices) > 0 {
+		return fmt.Errorf("stream is not empty: %v", stream)
+	}
+	return nil
+}
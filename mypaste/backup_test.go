@@ -0,0 +1,119 @@
+package mypaste
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBackupRestore(t *testing.T) {
+	ctx := context.Background()
+	cfg := StreamConfig{ReadCount: 100, ReadBlock: -1}
+	svc := NewMemoryStreamService(cfg)
+	var added []Event
+	for i := 0; i < 12; i++ {
+		event, err := svc.Add(ctx, "email1", Event{Payload: "hello", Kind: "Text", IsSensitive: i%2 == 0})
+		require.NoError(t, err)
+		added = append(added, event)
+	}
+	_, err := svc.AddDevice(ctx, "email1", Device{Id: "d1", Description: "device 1"})
+	require.NoError(t, err)
+	event2, err := svc.Add(ctx, "email2", Event{Payload: "hello 2"})
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	require.NoError(t, Backup(ctx, svc, &buf, 5, io.Discard))
+
+	restored := newTestSqliteStreamService(t, cfg)
+	require.NoError(t, Restore(ctx, restored, bytes.NewReader(buf.Bytes()), "", io.Discard))
+	events, err := restored.Range(ctx, "email1", "-", "+", 0)
+	require.NoError(t, err)
+	assert.Equal(t, added, events)
+	devices, err := restored.GetDevices(ctx, "email1")
+	require.NoError(t, err)
+	assert.Equal(t, []Device{{Id: "d1", Description: "device 1"}}, devices)
+	events, err = restored.Range(ctx, "email2", "-", "+", 0)
+	require.NoError(t, err)
+	assert.Equal(t, []Event{event2}, events)
+
+	err = Restore(ctx, restored, bytes.NewReader(buf.Bytes()), "", io.Discard)
+	assert.ErrorContains(t, err, "store is not empty")
+}
+
+func TestBackupArchivedEvents(t *testing.T) {
+	ctx := context.Background()
+	cfg := StreamConfig{ReadCount: 100, ReadBlock: -1}
+	live := NewMemoryStreamService(cfg)
+	archive := NewFileArchiveStore(t.TempDir())
+	svc := NewArchivingStreamService(live, archive, ArchiveConfig{MaxLen: 2, ReadCount: 100})
+	var added []Event
+	for i := 0; i < 5; i++ {
+		event, err := svc.Add(ctx, "email", Event{Payload: "hello"})
+		require.NoError(t, err)
+		added = append(added, event)
+	}
+	liveEvents, err := live.Range(ctx, "email", "-", "+", 0)
+	require.NoError(t, err)
+	require.Equal(t, added[3:], liveEvents, "should keep the trimmed events only in the archive")
+
+	// the backup command opens the archive without max len
+	var buf bytes.Buffer
+	require.NoError(t, Backup(ctx, NewArchivingStreamService(live, archive, ArchiveConfig{ReadCount: 100}), &buf, 2, io.Discard))
+
+	restored := NewMemoryStreamService(cfg)
+	require.NoError(t, Restore(ctx, restored, bytes.NewReader(buf.Bytes()), "", io.Discard))
+	events, err := restored.Range(ctx, "email", "-", "+", 0)
+	require.NoError(t, err)
+	assert.Equal(t, added, events)
+}
+
+func TestRestoreStream(t *testing.T) {
+	ctx := context.Background()
+	cfg := StreamConfig{ReadCount: 100, ReadBlock: -1}
+	svc := NewMemoryStreamService(cfg)
+	event1, err := svc.Add(ctx, "email1", Event{Payload: "hello 1"})
+	require.NoError(t, err)
+	_, err = svc.Add(ctx, "email2", Event{Payload: "hello 2"})
+	require.NoError(t, err)
+	var buf bytes.Buffer
+	require.NoError(t, Backup(ctx, svc, &buf, 5, io.Discard))
+
+	// other streams of the target are kept
+	restored := NewMemoryStreamService(cfg)
+	event3, err := restored.Add(ctx, "email3", Event{Payload: "hello 3"})
+	require.NoError(t, err)
+	require.NoError(t, Restore(ctx, restored, bytes.NewReader(buf.Bytes()), "email1", io.Discard))
+
+	streams, err := restored.Streams(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"email1", "email3"}, streams)
+	events, err := restored.Range(ctx, "email1", "-", "+", 0)
+	require.NoError(t, err)
+	assert.Equal(t, []Event{event1}, events)
+	events, err = restored.Range(ctx, "email3", "-", "+", 0)
+	require.NoError(t, err)
+	assert.Equal(t, []Event{event3}, events)
+
+	err = Restore(ctx, restored, bytes.NewReader(buf.Bytes()), "email1", io.Discard)
+	assert.ErrorContains(t, err, "stream is not empty")
+	err = Restore(ctx, restored, bytes.NewReader(buf.Bytes()), "email4", io.Discard)
+	assert.ErrorContains(t, err, "stream not found in backup")
+}
+
+func TestRestoreVersion(t *testing.T) {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	gz.Write([]byte(`{"Format":"mypaste-backup","Version":99}` + "\n"))
+	gz.Close()
+	svc := NewMemoryStreamService(StreamConfig{})
+	err := Restore(context.Background(), svc, &buf, "", io.Discard)
+	assert.ErrorContains(t, err, "unsupported backup version: 99")
+
+	err = Restore(context.Background(), svc, bytes.NewReader([]byte("not a backup")), "", io.Discard)
+	assert.ErrorContains(t, err, "invalid backup")
+}
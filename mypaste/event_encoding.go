@@ -0,0 +1,99 @@
+package mypaste
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+)
+
+// eventEncodingVersion is stored with every event written.
+// Events written before versioning have no version and are read as version 1.
+const eventEncodingVersion = 2
+
+// CorruptEventError is reported for a stored event that cannot be decoded.
+type CorruptEventError struct {
+	Stream string
+	Id     string
+	Err    error
+}
+
+func (e *CorruptEventError) Error() string {
+	return fmt.Sprintf("corrupt event %v in stream %v, %v", e.Id, e.Stream, e.Err)
+}
+
+func (e *CorruptEventError) Unwrap() error {
+	return e.Err
+}
+
+// encodeEvent encodes the event without its id, which is kept by the storage.
+func encodeEvent(event Event) string {
+	event.Id = ""
+	data, _ := json.Marshal(event)
+	return string(data)
+}
+
+// decodeEvent decodes an event of any known version, upgrading older versions.
+func decodeEvent(version int, data string) (Event, error) {
+	var event Event
+	switch version {
+	case 1:
+		// version 1 may hold a stale id, the id given by the storage is used instead
+		if err := json.Unmarshal([]byte(data), &event); err != nil {
+			return event, err
+		}
+		event.Id = ""
+		return event, nil
+	case 2:
+		err := json.Unmarshal([]byte(data), &event)
+		return event, err
+	}
+	return event, fmt.Errorf("unknown event encoding version: %v", version)
+}
+
+// decodeStoredEvent decodes an event, corrupt events are reported and skipped.
+func decodeStoredEvent(stream, id string, version int, data string) (Event, bool) {
+	event, err := decodeEvent(version, data)
+	if err != nil {
+		reportCorruptEvent(&CorruptEventError{Stream: stream, Id: id, Err: err})
+		return event, false
+	}
+	event.Id = id
+	return event, true
+}
+
+// maxReportedCorruptEvents bounds the corrupt events remembered as reported,
+// the oldest is forgotten and may be logged again.
+const maxReportedCorruptEvents = 1000
+
+// reportedCorruptEvents keeps the corrupt events already logged, a corrupt event at
+// the end of a stream is read again by every Read until new events are added.
+var reportedCorruptEvents = struct {
+	mtx   sync.Mutex
+	keys  map[string]bool
+	order []string
+}{keys: make(map[string]bool)}
+
+var reportCorruptEvent = func(err *CorruptEventError) {
+	if !markCorruptEventReported(err.Stream + "/" + err.Id) {
+		return
+	}
+	fmt.Printf("%v, the event is skipped by reads until it is deleted\n", err)
+}
+
+// markCorruptEventReported returns false if the key was already reported.
+func markCorruptEventReported(key string) bool {
+	reported := &reportedCorruptEvents
+	reported.mtx.Lock()
+	defer reported.mtx.Unlock()
+
+	if reported.keys[key] {
+		return false
+	}
+	if len(reported.order) >= maxReportedCorruptEvents {
+		delete(reported.keys, reported.order[0])
+		reported.order = reported.order[1:]
+	}
+	reported.keys[key] = true
+	reported.order = append(reported.order, key)
+	return true
+}
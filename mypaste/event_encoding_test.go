@@ -0,0 +1,168 @@
+package mypaste
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecodeEvent(t *testing.T) {
+	event := Event{Payload: "hello", Timestamp: 1, Kind: "Text", IsSensitive: true}
+	decoded, err := decodeEvent(eventEncodingVersion, encodeEvent(Event{Id: "1-0", Payload: "hello", Timestamp: 1, Kind: "Text", IsSensitive: true}))
+	require.NoError(t, err)
+	assert.Equal(t, event, decoded)
+
+	decoded, err = decodeEvent(1, `{"Id":"1-0","Payload":"hello","Timestamp":1,"Kind":"Text","IsSensitive":true}`)
+	require.NoError(t, err)
+	assert.Equal(t, event, decoded, "version 1 should be upgraded")
+
+	_, err = decodeEvent(eventEncodingVersion, `{"Payload":`)
+	assert.Error(t, err)
+
+	_, err = decodeEvent(eventEncodingVersion+1, `{}`)
+	assert.ErrorContains(t, err, "unknown event encoding version")
+}
+
+func TestMarkCorruptEventReported(t *testing.T) {
+	assert.True(t, markCorruptEventReported("test/1-0"))
+	assert.False(t, markCorruptEventReported("test/1-0"), "should report once")
+	for i := 0; i < maxReportedCorruptEvents; i++ {
+		markCorruptEventReported(fmt.Sprintf("test/%v-1", i))
+	}
+	assert.Len(t, reportedCorruptEvents.keys, maxReportedCorruptEvents)
+	assert.True(t, markCorruptEventReported("test/1-0"), "should forget the oldest reports")
+}
+
+func captureCorruptEvents(t *testing.T) *[]*CorruptEventError {
+	reported := make([]*CorruptEventError, 0)
+	report := reportCorruptEvent
+	reportCorruptEvent = func(err *CorruptEventError) {
+		reported = append(reported, err)
+	}
+	t.Cleanup(func() { reportCorruptEvent = report })
+	return &reported
+}
+
+func TestRedisEventEncoding(t *testing.T) {
+	reported := captureCorruptEvents(t)
+	ctx := context.Background()
+	mredis := miniredis.RunT(t)
+	rclient := redis.NewClient(&redis.Options{Addr: mredis.Addr()})
+	svc := NewRedisStreamService(rclient, StreamConfig{ReadCount: 10, ReadBlock: -1})
+	key := redisKey(redisEventKeyPrefix, "email")
+
+	rclient.XAdd(ctx, &redis.XAddArgs{Stream: key, ID: "1-0", Values: map[string]any{"Json": `{"Payload":"legacy"}`}})
+	rclient.XAdd(ctx, &redis.XAddArgs{Stream: key, ID: "2-0", Values: map[string]any{"V": "2", "Json": `{"Payload":`}})
+	rclient.XAdd(ctx, &redis.XAddArgs{Stream: key, ID: "3-0", Values: map[string]any{"V": "2"}})
+	event, err := svc.Add(ctx, "email", Event{Payload: "current"})
+	require.NoError(t, err)
+
+	values, err := rclient.XRange(ctx, key, event.Id, event.Id).Result()
+	require.NoError(t, err)
+	assert.Equal(t, "2", values[0].Values["V"])
+
+	events, err := svc.Read(ctx, "email", "")
+	require.NoError(t, err)
+	assert.Equal(t, []Event{{Id: "1-0", Payload: "legacy"}, event}, events)
+	require.Len(t, *reported, 2)
+	assert.Equal(t, "2-0", (*reported)[0].Id)
+	assert.Equal(t, "3-0", (*reported)[1].Id)
+	assert.Equal(t, "email", (*reported)[1].Stream)
+}
+
+func TestSqliteEventEncoding(t *testing.T) {
+	reported := captureCorruptEvents(t)
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "mypaste.db")
+	db, err := OpenSqliteDB(path)
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	// schema from before events were versioned
+	_, err = db.Exec(`CREATE TABLE events (
+		stream TEXT NOT NULL, ms INTEGER NOT NULL, seq INTEGER NOT NULL, json TEXT NOT NULL,
+		PRIMARY KEY (stream, ms, seq)
+	);
+	INSERT INTO events (stream, ms, seq, json) VALUES
+		('email', 1, 0, '{"Id":"1-0","Payload":"legacy"}'),
+		('email', 2, 0, '{"Payload":');`)
+	require.NoError(t, err)
+
+	svc, err := NewSqliteStreamService(db, StreamConfig{ReadCount: 10, ReadBlock: -1})
+	require.NoError(t, err)
+	event, err := svc.Add(ctx, "email", Event{Payload: "current"})
+	require.NoError(t, err)
+
+	events, err := svc.Read(ctx, "email", "")
+	require.NoError(t, err)
+	assert.Equal(t, []Event{{Id: "1-0", Payload: "legacy"}, event}, events)
+	require.Len(t, *reported, 1)
+	assert.Equal(t, "2-0", (*reported)[0].Id)
+}
+
+func TestReadSkipsCorruptEvents(t *testing.T) {
+	// every backend gets a stream with two corrupt events, inserted by addCorrupt, before a good one
+	backends := map[string]func(t *testing.T, cfg StreamConfig) (StreamService, func(id streamId)){
+		"redis": func(t *testing.T, cfg StreamConfig) (StreamService, func(id streamId)) {
+			rclient := redis.NewClient(&redis.Options{Addr: miniredis.RunT(t).Addr()})
+			return NewRedisStreamService(rclient, cfg), func(id streamId) {
+				err := rclient.XAdd(context.Background(), &redis.XAddArgs{
+					Stream: redisKey(redisEventKeyPrefix, "email"), ID: id.String(), Values: map[string]any{"V": "2", "Json": `{"Payload":`},
+				}).Err()
+				require.NoError(t, err)
+			}
+		},
+		"sqlite": func(t *testing.T, cfg StreamConfig) (StreamService, func(id streamId)) {
+			db, err := OpenSqliteDB(filepath.Join(t.TempDir(), "mypaste.db"))
+			require.NoError(t, err)
+			t.Cleanup(func() { db.Close() })
+			svc, err := NewSqliteStreamService(db, cfg)
+			require.NoError(t, err)
+			return svc, func(id streamId) {
+				_, err := db.Exec(`INSERT INTO events (stream, ms, seq, version, json) VALUES ('email', ?, ?, 2, '{"Payload":')`, id.Ms, id.Seq)
+				require.NoError(t, err)
+			}
+		},
+		"postgres": func(t *testing.T, cfg StreamConfig) (StreamService, func(id streamId)) {
+			svc := newTestPostgresStreamService(t, cfg)
+			pool, err := pgxpool.New(context.Background(), os.Getenv("TEST_POSTGRES_URL"))
+			require.NoError(t, err)
+			t.Cleanup(pool.Close)
+			return svc, func(id streamId) {
+				_, err := pool.Exec(context.Background(), `INSERT INTO mypaste_events (stream, ms, seq, version, json)
+					VALUES ('email', $1, $2, 2, '{"Payload":')`, id.Ms, id.Seq)
+				require.NoError(t, err)
+			}
+		},
+	}
+	for name, newBackend := range backends {
+		t.Run(name, func(t *testing.T) {
+			reported := captureCorruptEvents(t)
+			ctx := context.Background()
+			// corrupt events take the whole page of a read
+			svc, addCorrupt := newBackend(t, StreamConfig{ReadCount: 1, ReadBlock: -1})
+			addCorrupt(streamId{Ms: 1})
+			addCorrupt(streamId{Ms: 2})
+			event, err := svc.Add(ctx, "email", Event{Payload: "hello"})
+			require.NoError(t, err)
+
+			events, err := svc.Read(ctx, "email", "")
+			require.NoError(t, err)
+			assert.Equal(t, []Event{event}, events)
+			require.Len(t, *reported, 2)
+			assert.Equal(t, "2-0", (*reported)[1].Id)
+
+			events, err = svc.Read(ctx, "email", event.Id)
+			require.NoError(t, err)
+			assert.Empty(t, events)
+		})
+	}
+}
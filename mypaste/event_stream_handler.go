@@ -0,0 +1,102 @@
+package mypaste
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// StreamEventsHandler pushes new events as server-sent events, each with the event id as the sse id
+// so a reconnecting client continues after the Last-Event-ID header.
+// A truncated event with the oldest retained id is sent first when events after the last id were deleted or trimmed.
+// Every heartbeat checks the session again and ends the stream with an error event once it is revoked.
+func StreamEventsHandler(streamService StreamService, sessions SessionStore, apiTokens ApiTokenStore, heartbeat time.Duration) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		claims := getTokenClaims(c)
+		user := claims.User
+		lastId := c.Request().Header.Get("Last-Event-ID")
+		if lastId == "" {
+			lastId = c.QueryParam("lastId")
+		}
+		if lastId != "" {
+			if _, err := parseStreamId(lastId); err != nil {
+				return c.String(http.StatusBadRequest, err.Error())
+			}
+		}
+		oldestId, truncated, err := historyTruncated(c.Request().Context(), streamService, user.Email, lastId)
+		if err != nil {
+			return c.String(http.StatusInternalServerError, err.Error())
+		}
+		ctx, cancel := context.WithCancel(c.Request().Context())
+		defer cancel()
+		eventsCh := make(chan []Event)
+		errCh := make(chan error, 1)
+		go func() {
+			errCh <- subscribeEvents(ctx, streamService, user.Email, lastId, eventsCh)
+		}()
+
+		res := c.Response()
+		res.Header().Set(echo.HeaderContentType, "text/event-stream")
+		res.Header().Set("Cache-Control", "no-store")
+		res.Header().Set("X-Accel-Buffering", "no")
+		res.WriteHeader(http.StatusOK)
+		if truncated {
+			fmt.Fprintf(res, "event: truncated\ndata: %s\n\n", oldestId)
+		}
+		res.Flush()
+
+		ticker := time.NewTicker(heartbeat)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case err := <-errCh:
+				if ctx.Err() != nil {
+					return nil
+				}
+				c.Logger().Errorf("event stream error, email: %v, %v", user.Email, err)
+				fmt.Fprintf(res, "event: error\ndata: %s\n\n", err.Error())
+				res.Flush()
+				return nil
+			case <-ticker.C:
+				if err := checkRevoked(ctx, sessions, apiTokens, claims); isRevoked(err) {
+					fmt.Fprintf(res, "event: error\ndata: %s\n\n", err.Error())
+					res.Flush()
+					return nil
+				}
+				fmt.Fprint(res, ": heartbeat\n\n")
+				res.Flush()
+			case events := <-eventsCh:
+				for _, event := range events {
+					data, _ := json.Marshal(event)
+					fmt.Fprintf(res, "id: %s\ndata: %s\n\n", event.Id, data)
+				}
+				res.Flush()
+			}
+		}
+	}
+}
+
+// subscribeEvents reads the stream after lastId until ctx is done, sending every batch of new events.
+func subscribeEvents(ctx context.Context, streamService StreamService, stream, lastId string, eventsCh chan<- []Event) error {
+	for {
+		events, err := streamService.Read(ctx, stream, lastId)
+		if err != nil {
+			return err
+		}
+		if len(events) == 0 {
+			continue
+		}
+		select {
+		case eventsCh <- events:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		lastId = events[len(events)-1].Id
+	}
+}
@@ -0,0 +1,154 @@
+package mypaste
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStreamEventsHandler(t *testing.T) {
+	for name, newTestStreamService := range testStreamServices {
+		t.Run(name, func(t *testing.T) {
+			svc := newTestStreamService(t, StreamConfig{MaxLen: 10, ReadCount: 10, ReadBlock: 50 * time.Millisecond})
+			server := newTestEventStreamServer(t, svc, "email", time.Hour)
+			e1 := addEventWithJustPayloadT(t, svc, "email", "hello 1")
+
+			body := openEventStreamT(t, server.URL, "")
+			assertServerSentEventT(t, body, e1)
+			e2 := addEventWithJustPayloadT(t, svc, "email", "hello 2")
+			addEventWithJustPayloadT(t, svc, "email2", "other stream")
+			e3 := addEventWithJustPayloadT(t, svc, "email", "hello 3")
+			assertServerSentEventT(t, body, e2)
+			assertServerSentEventT(t, body, e3)
+
+			resumed := openEventStreamT(t, server.URL, e2.Id)
+			assertServerSentEventT(t, resumed, e3)
+		})
+	}
+}
+
+func TestStreamEventsHandlerHeartbeat(t *testing.T) {
+	svc := NewMemoryStreamService(StreamConfig{ReadCount: 10, ReadBlock: time.Minute})
+	server := newTestEventStreamServer(t, svc, "email", 10*time.Millisecond)
+	body := openEventStreamT(t, server.URL, "")
+	line, err := body.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, ": heartbeat\n", line)
+}
+
+func TestStreamEventsHandlerTruncated(t *testing.T) {
+	svc := NewMemoryStreamService(StreamConfig{MaxLen: 2, ReadCount: 10, ReadBlock: time.Minute})
+	server := newTestEventStreamServer(t, svc, "email", time.Hour)
+	e1 := addEventWithJustPayloadT(t, svc, "email", "hello 1")
+	addEventWithJustPayloadT(t, svc, "email", "hello 2")
+	e3 := addEventWithJustPayloadT(t, svc, "email", "hello 3")
+	e4 := addEventWithJustPayloadT(t, svc, "email", "hello 4")
+
+	body := openEventStreamT(t, server.URL, e1.Id)
+	for _, expected := range []string{"event: truncated\n", "data: " + e3.Id + "\n", "\n"} {
+		line, err := body.ReadString('\n')
+		require.NoError(t, err)
+		assert.Equal(t, expected, line)
+	}
+	assertServerSentEventT(t, body, e3)
+	assertServerSentEventT(t, body, e4)
+}
+
+func TestStreamEventsHandlerRevokedSession(t *testing.T) {
+	svc := NewMemoryStreamService(StreamConfig{ReadCount: 10, ReadBlock: time.Minute})
+	server := newTestEventStreamServer(t, svc, "email", 10*time.Millisecond)
+	body := openEventStreamT(t, server.URL, "")
+	line, err := body.ReadString('\n')
+	require.NoError(t, err)
+	require.Equal(t, ": heartbeat\n", line)
+
+	require.NoError(t, svc.DeleteSessions(context.Background(), "email"))
+	var lines []string
+	for {
+		line, err := body.ReadString('\n')
+		if err != nil {
+			break
+		}
+		if line != ": heartbeat\n" && line != "\n" {
+			lines = append(lines, line)
+		}
+	}
+	assert.Equal(t, []string{"event: error\n", "data: " + ErrSessionNotFound.Error() + "\n"}, lines,
+		"should end the stream once the session is revoked")
+}
+
+func TestStreamEventsHandlerInvalidLastId(t *testing.T) {
+	svc := NewMemoryStreamService(StreamConfig{ReadCount: 10, ReadBlock: time.Minute})
+	server := newTestEventStreamServer(t, svc, "email", time.Hour)
+	req, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+	req.Header.Set("Last-Event-ID", "invalid")
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func newTestEventStreamServer(t *testing.T, svc Store, email string, heartbeat time.Duration) *httptest.Server {
+	e := echo.New()
+	sessionId := newTestSessionT(t, svc, email)
+	e.GET("/", StreamEventsHandler(svc, svc, svc, heartbeat), func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			c.Set("user", generateToken(User{"name", email}, sessionId))
+			return next(c)
+		}
+	})
+	server := httptest.NewServer(e)
+	t.Cleanup(server.Close)
+	return server
+}
+
+// newTestSessionT adds a session to the stream and returns its id.
+func newTestSessionT(t *testing.T, svc Store, email string) string {
+	id, err := randomString()
+	require.NoError(t, err)
+	now := time.Now()
+	session := Session{Id: id, CreatedAt: now.Unix(), LastUsedAt: now.Unix(), ExpiresAt: now.Add(time.Hour).Unix()}
+	require.NoError(t, svc.SetSession(context.Background(), email, session))
+	return id
+}
+
+func openEventStreamT(t *testing.T, url, lastEventId string) *bufio.Reader {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if lastEventId != "" {
+		req.Header.Set("Last-Event-ID", lastEventId)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	t.Cleanup(func() { resp.Body.Close() })
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+	return bufio.NewReader(resp.Body)
+}
+
+func assertServerSentEventT(t *testing.T, body *bufio.Reader, expected Event) {
+	lines := make([]string, 0, 2)
+	for {
+		line, err := body.ReadString('\n')
+		require.NoError(t, err)
+		if line == "\n" {
+			break
+		}
+		lines = append(lines, strings.TrimSuffix(line, "\n"))
+	}
+	require.Len(t, lines, 2)
+	assert.Equal(t, "id: "+expected.Id, lines[0])
+	var event Event
+	require.NoError(t, json.Unmarshal([]byte(strings.TrimPrefix(lines[1], "data: ")), &event))
+	assert.Equal(t, expected, event)
+}
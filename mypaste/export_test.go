@@ -0,0 +1,4 @@
+package mypaste
+
+// TestStreamServices exposes the test stream services to the tests in package mypaste_test.
+var TestStreamServices = testStreamServices
@@ -0,0 +1,131 @@
+package mypaste
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"golang.org/x/oauth2"
+)
+
+type GitHubConfig struct {
+	ClientId     string
+	ClientSecret string
+	RedirectUri  string
+	// WebUrl and ApiUrl default to github.com, they can point to a GitHub Enterprise server.
+	WebUrl string
+	ApiUrl string
+}
+
+// GitHubProvider signs in with a GitHub OAuth app using the verified primary email of the account.
+type GitHubProvider struct {
+	oauth2 oauth2.Config
+	apiUrl string
+}
+
+func NewGitHubProvider(config GitHubConfig) *GitHubProvider {
+	webUrl := strings.TrimSuffix(config.WebUrl, "/")
+	if webUrl == "" {
+		webUrl = "https://github.com"
+	}
+	apiUrl := strings.TrimSuffix(config.ApiUrl, "/")
+	if apiUrl == "" {
+		apiUrl = "https://api.github.com"
+	}
+	return &GitHubProvider{
+		oauth2: oauth2.Config{
+			ClientID:     config.ClientId,
+			ClientSecret: config.ClientSecret,
+			RedirectURL:  config.RedirectUri,
+			Endpoint: oauth2.Endpoint{
+				AuthURL:  webUrl + "/login/oauth/authorize",
+				TokenURL: webUrl + "/login/oauth/access_token",
+			},
+			Scopes: []string{"read:user", "user:email"},
+		},
+		apiUrl: apiUrl,
+	}
+}
+
+func GitHubLoginHandler(provider *GitHubProvider, jwtSignKey string) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		flow, err := startOAuthFlow(c, jwtSignKey)
+		if err != nil {
+			return handleLoginError(c, err)
+		}
+		authUrl := provider.oauth2.AuthCodeURL(flow.State, oauth2.S256ChallengeOption(flow.Verifier))
+		return c.Redirect(http.StatusFound, authUrl)
+	}
+}
+
+func GitHubCallbackHandler(provider *GitHubProvider, sessions SessionStore, jwtSignKey string) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		user, err := validateGitHubCallback(c, provider, jwtSignKey)
+		if err != nil {
+			return handleLoginError(c, err)
+		}
+		return loginSuccess(c, sessions, *user, jwtSignKey)
+	}
+}
+
+func validateGitHubCallback(c echo.Context, provider *GitHubProvider, jwtSignKey string) (*User, error) {
+	flow, err := finishOAuthFlow(c, jwtSignKey)
+	if err != nil {
+		return nil, err
+	}
+	ctx := c.Request().Context()
+	token, err := provider.oauth2.Exchange(ctx, c.QueryParam("code"), oauth2.VerifierOption(flow.Verifier))
+	if err != nil {
+		return nil, fmt.Errorf("failed to exchange code, %w", err)
+	}
+	client := provider.oauth2.Client(ctx, token)
+
+	var account struct {
+		Login string `json:"login"`
+		Name  string `json:"name"`
+	}
+	if err := provider.get(ctx, client, "/user", &account); err != nil {
+		return nil, err
+	}
+	var emails []struct {
+		Email    string `json:"email"`
+		Primary  bool   `json:"primary"`
+		Verified bool   `json:"verified"`
+	}
+	if err := provider.get(ctx, client, "/user/emails", &emails); err != nil {
+		return nil, err
+	}
+	for _, email := range emails {
+		if email.Primary && email.Verified {
+			name := account.Name
+			if name == "" {
+				name = account.Login
+			}
+			return &User{name, email.Email}, nil
+		}
+	}
+	return nil, fmt.Errorf("no verified primary email for github user: %v", account.Login)
+}
+
+func (p *GitHubProvider) get(ctx context.Context, client *http.Client, path string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.apiUrl+path, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to get github %v, %w", path, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to get github %v, status: %v", path, resp.StatusCode)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("invalid github %v response, %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+package mypaste
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeGitHubEmail struct {
+	Email    string `json:"email"`
+	Primary  bool   `json:"primary"`
+	Verified bool   `json:"verified"`
+}
+
+func TestGitHubLogin(t *testing.T) {
+	const jwtSignKey = "secret"
+	svc := NewMemoryStreamService(StreamConfig{})
+
+	t.Run("ok", func(t *testing.T) {
+		provider := newTestGitHubProvider(t, []fakeGitHubEmail{
+			{Email: "other@example.com", Verified: true},
+			{Email: "user@example.com", Primary: true, Verified: true},
+		})
+
+		rec := githubLoginFlowT(t, provider, svc, jwtSignKey)
+
+		assert.Equal(t, http.StatusSeeOther, rec.Code)
+		assert.Equal(t, "/", rec.Header().Get("Location"))
+		assertResponseTokenCookie(t, rec.Result(), svc, jwtSignKey)
+		token, err := jwt.ParseWithClaims(getResponseTokenCookie(rec.Result()).Value, new(TokenClaims),
+			func(token *jwt.Token) (interface{}, error) { return []byte(jwtSignKey), nil })
+		require.NoError(t, err)
+		assert.Equal(t, User{"The Octocat", "user@example.com"}, token.Claims.(*TokenClaims).User)
+	})
+
+	t.Run("fail if primary email not verified", func(t *testing.T) {
+		provider := newTestGitHubProvider(t, []fakeGitHubEmail{
+			{Email: "other@example.com", Verified: true},
+			{Email: "user@example.com", Primary: true},
+		})
+
+		rec := githubLoginFlowT(t, provider, svc, jwtSignKey)
+
+		assertLoginFailedT(t, rec)
+	})
+}
+
+// newTestGitHubProvider serves the oauth and api endpoints of GitHub used by the login flow.
+func newTestGitHubProvider(t *testing.T, emails []fakeGitHubEmail) *GitHubProvider {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/login/oauth/authorize", func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		redirect, _ := url.Parse(query.Get("redirect_uri"))
+		redirect.RawQuery = url.Values{"code": {"code"}, "state": {query.Get("state")}}.Encode()
+		http.Redirect(w, r, redirect.String(), http.StatusFound)
+	})
+	mux.HandleFunc("/login/oauth/access_token", func(w http.ResponseWriter, r *http.Request) {
+		if r.PostFormValue("code") != "code" || r.PostFormValue("code_verifier") == "" {
+			w.Write([]byte("error=bad_verification_code"))
+			return
+		}
+		w.Header().Set("Content-Type", "application/x-www-form-urlencoded")
+		w.Write([]byte("access_token=access-token&scope=read%3Auser%2Cuser%3Aemail&token_type=bearer"))
+	})
+	authorized := func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Authorization") != "Bearer access-token" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			next(w, r)
+		}
+	}
+	mux.HandleFunc("/api/v3/user", authorized(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{"login": "octocat", "name": "The Octocat"})
+	}))
+	mux.HandleFunc("/api/v3/user/emails", authorized(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(emails)
+	}))
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return NewGitHubProvider(GitHubConfig{
+		ClientId:     "client",
+		ClientSecret: "client-secret",
+		RedirectUri:  "https://mypaste.test/login/github/callback",
+		WebUrl:       server.URL,
+		ApiUrl:       server.URL + "/api/v3",
+	})
+}
+
+func githubLoginFlowT(t *testing.T, provider *GitHubProvider, svc Store, jwtSignKey string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/login/github", nil)
+	rec := httptest.NewRecorder()
+	err := GitHubLoginHandler(provider, jwtSignKey)(echo.New().NewContext(req, rec))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusFound, rec.Code)
+
+	client := &http.Client{CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	resp, err := client.Get(rec.Header().Get("Location"))
+	require.NoError(t, err)
+	resp.Body.Close()
+	callbackUrl, err := url.Parse(resp.Header.Get("Location"))
+	require.NoError(t, err)
+
+	req = httptest.NewRequest(http.MethodGet, callbackUrl.RequestURI(), nil)
+	for _, cookie := range rec.Result().Cookies() {
+		req.AddCookie(cookie)
+	}
+	rec = httptest.NewRecorder()
+	err = GitHubCallbackHandler(provider, svc, jwtSignKey)(echo.New().NewContext(req, rec))
+	require.NoError(t, err)
+	return rec
+}
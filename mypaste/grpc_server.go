@@ -0,0 +1,261 @@
+package mypaste
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/aungmawjj/mypaste/mypaste/mypastepb"
+	"github.com/golang-jwt/jwt/v5"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// NewGrpcServer serves StreamService over grpc, authorized by the same jwt and session of sessions as NewAuthMiddleware.
+// maxRecvMsgSize limits the size of a request message like the request body limit,
+// Subscribe checks the session again every revokeCheckInterval. opts are added to the server options, eg. grpc.Creds.
+func NewGrpcServer(streamService StreamService, sessions SessionStore, apiTokens ApiTokenStore, jwtSignKey string, maxRecvMsgSize int, revokeCheckInterval time.Duration, opts ...grpc.ServerOption) *grpc.Server {
+	auth := &grpcAuth{jwtSignKey: jwtSignKey, sessions: sessions}
+	server := grpc.NewServer(append([]grpc.ServerOption{
+		grpc.MaxRecvMsgSize(maxRecvMsgSize),
+		grpc.UnaryInterceptor(auth.unary),
+		grpc.StreamInterceptor(auth.stream),
+	}, opts...)...)
+	mypastepb.RegisterMyPasteServer(server, &grpcServer{
+		streamService:       streamService,
+		sessions:            sessions,
+		apiTokens:           apiTokens,
+		revokeCheckInterval: revokeCheckInterval,
+	})
+	return server
+}
+
+type grpcClaimsKey struct{}
+
+type grpcAuth struct {
+	jwtSignKey string
+	sessions   SessionStore
+}
+
+func (a *grpcAuth) unary(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	ctx, err := a.authorize(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+func (a *grpcAuth) stream(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, err := a.authorize(ss.Context())
+	if err != nil {
+		return err
+	}
+	return handler(srv, &grpcAuthorizedStream{ServerStream: ss, ctx: ctx})
+}
+
+func (a *grpcAuth) authorize(ctx context.Context) (context.Context, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	values := md.Get("authorization")
+	if len(values) == 0 || !strings.HasPrefix(values[0], "Bearer ") {
+		return ctx, status.Error(codes.Unauthenticated, "missing bearer token")
+	}
+	claims := new(TokenClaims)
+	_, err := jwt.ParseWithClaims(strings.TrimPrefix(values[0], "Bearer "), claims, func(t *jwt.Token) (any, error) {
+		return []byte(a.jwtSignKey), nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
+	if err != nil {
+		return ctx, status.Error(codes.Unauthenticated, err.Error())
+	}
+	session, err := a.sessions.GetSession(ctx, claims.Email, claims.ID)
+	if err == nil {
+		err = touchSession(ctx, a.sessions, claims.Email, session, grpcPeerIp(ctx), strings.Join(md.Get("user-agent"), " "))
+	}
+	if errors.Is(err, ErrSessionNotFound) {
+		return ctx, status.Error(codes.Unauthenticated, "session expired or revoked")
+	}
+	if err != nil {
+		return ctx, status.Error(codes.Internal, err.Error())
+	}
+	return context.WithValue(ctx, grpcClaimsKey{}, claims), nil
+}
+
+func grpcPeerIp(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(p.Addr.String())
+	if err != nil {
+		return p.Addr.String()
+	}
+	return host
+}
+
+type grpcAuthorizedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *grpcAuthorizedStream) Context() context.Context {
+	return s.ctx
+}
+
+func grpcAuthorizedUser(ctx context.Context) User {
+	return grpcAuthorizedClaims(ctx).User
+}
+
+func grpcAuthorizedClaims(ctx context.Context) *TokenClaims {
+	claims, ok := ctx.Value(grpcClaimsKey{}).(*TokenClaims)
+	if !ok {
+		return new(TokenClaims)
+	}
+	return claims
+}
+
+type grpcServer struct {
+	mypastepb.UnimplementedMyPasteServer
+	streamService       StreamService
+	sessions            SessionStore
+	apiTokens           ApiTokenStore
+	revokeCheckInterval time.Duration
+}
+
+func (s *grpcServer) AddEvent(ctx context.Context, req *mypastepb.AddEventRequest) (*mypastepb.Event, error) {
+	if req.Event == nil {
+		return nil, status.Error(codes.InvalidArgument, "missing event")
+	}
+	event, err := addEvent(ctx, s.streamService, grpcAuthorizedUser(ctx).Email, fromProtoEvent(req.Event))
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return toProtoEvent(event), nil
+}
+
+func (s *grpcServer) ListEvents(ctx context.Context, req *mypastepb.ListEventsRequest) (*mypastepb.ListEventsResponse, error) {
+	start := "-"
+	if req.LastId != "" {
+		lastId, err := parseStreamId(req.LastId)
+		if err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		start = lastId.successor().String()
+	}
+	limit := int64(defaultPageLimit)
+	if req.Limit < 0 {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid limit: %v", req.Limit)
+	}
+	if req.Limit > 0 {
+		limit = req.Limit
+		if limit > maxPageLimit {
+			limit = maxPageLimit
+		}
+	}
+	// one more event tells whether there is a next page
+	events, err := s.streamService.Range(ctx, grpcAuthorizedUser(ctx).Email, start, "+", limit+1)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	res := &mypastepb.ListEventsResponse{}
+	if int64(len(events)) > limit {
+		events = events[:limit]
+		res.Cursor = events[limit-1].Id
+	}
+	res.Events = toProtoEvents(events)
+	return res, nil
+}
+
+func (s *grpcServer) DeleteEvents(ctx context.Context, req *mypastepb.DeleteEventsRequest) (*mypastepb.DeleteEventsResponse, error) {
+	count, err := s.streamService.Delete(ctx, grpcAuthorizedUser(ctx).Email, req.Ids...)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return &mypastepb.DeleteEventsResponse{Count: count}, nil
+}
+
+func (s *grpcServer) ResetStream(ctx context.Context, req *mypastepb.ResetStreamRequest) (*mypastepb.ResetStreamResponse, error) {
+	if err := s.streamService.Reset(ctx, grpcAuthorizedUser(ctx).Email); err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return &mypastepb.ResetStreamResponse{}, nil
+}
+
+func (s *grpcServer) ListDevices(ctx context.Context, req *mypastepb.ListDevicesRequest) (*mypastepb.ListDevicesResponse, error) {
+	devices, err := s.streamService.GetDevices(ctx, grpcAuthorizedUser(ctx).Email)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	res := &mypastepb.ListDevicesResponse{Devices: make([]*mypastepb.Device, 0, len(devices))}
+	for _, device := range devices {
+		res.Devices = append(res.Devices, &mypastepb.Device{Id: device.Id, Description: device.Description})
+	}
+	return res, nil
+}
+
+func (s *grpcServer) Subscribe(req *mypastepb.SubscribeRequest, stream mypastepb.MyPaste_SubscribeServer) error {
+	if req.LastId != "" {
+		if _, err := parseStreamId(req.LastId); err != nil {
+			return status.Error(codes.InvalidArgument, err.Error())
+		}
+	}
+	ctx, cancel := context.WithCancel(stream.Context())
+	defer cancel()
+	eventsCh := make(chan []Event)
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- subscribeEvents(ctx, s.streamService, grpcAuthorizedUser(ctx).Email, req.LastId, eventsCh)
+	}()
+	ticker := time.NewTicker(s.revokeCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case err := <-errCh:
+			if ctx.Err() != nil {
+				return nil
+			}
+			return status.Error(codes.Internal, err.Error())
+		case <-ticker.C:
+			if err := checkRevoked(ctx, s.sessions, s.apiTokens, grpcAuthorizedClaims(ctx)); isRevoked(err) {
+				return status.Error(codes.Unauthenticated, "session expired or revoked")
+			}
+		case events := <-eventsCh:
+			for _, event := range events {
+				if err := stream.Send(toProtoEvent(event)); err != nil {
+					return err
+				}
+			}
+		}
+	}
+}
+
+func toProtoEvent(event Event) *mypastepb.Event {
+	return &mypastepb.Event{
+		Id:          event.Id,
+		Payload:     event.Payload,
+		Timestamp:   event.Timestamp,
+		Kind:        event.Kind,
+		IsSensitive: event.IsSensitive,
+	}
+}
+
+func toProtoEvents(events []Event) []*mypastepb.Event {
+	res := make([]*mypastepb.Event, 0, len(events))
+	for _, event := range events {
+		res = append(res, toProtoEvent(event))
+	}
+	return res
+}
+
+func fromProtoEvent(event *mypastepb.Event) Event {
+	return Event{
+		Payload:     event.Payload,
+		Kind:        event.Kind,
+		IsSensitive: event.IsSensitive,
+	}
+}
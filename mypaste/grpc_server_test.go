@@ -0,0 +1,197 @@
+package mypaste
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aungmawjj/mypaste/mypaste/mypastepb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestGrpcServer(t *testing.T) {
+	svc := NewMemoryStreamService(StreamConfig{MaxLen: 10, ReadCount: 10, ReadBlock: 50 * time.Millisecond})
+	client := newTestGrpcClient(t, svc, "secret")
+	ctx := grpcTokenContextT(t, svc, "secret", "email")
+
+	e1, err := client.AddEvent(ctx, &mypastepb.AddEventRequest{Event: &mypastepb.Event{Payload: "hello 1", Kind: "Text"}})
+	require.NoError(t, err)
+	assert.NotEmpty(t, e1.Id)
+	assert.Equal(t, "hello 1", e1.Payload)
+	e2, err := client.AddEvent(ctx, &mypastepb.AddEventRequest{Event: &mypastepb.Event{Payload: "hello 2", IsSensitive: true}})
+	require.NoError(t, err)
+	assert.Equal(t, []Event{fromProtoEventT(e1), fromProtoEventT(e2)}, readEventsT(t, svc, "email", ""))
+
+	res, err := client.ListEvents(ctx, &mypastepb.ListEventsRequest{})
+	require.NoError(t, err)
+	assert.Equal(t, []string{e1.Id, e2.Id}, protoEventIdsT(res.Events))
+	assert.Empty(t, res.Cursor)
+	res, err = client.ListEvents(ctx, &mypastepb.ListEventsRequest{LastId: e1.Id})
+	require.NoError(t, err)
+	assert.Equal(t, []string{e2.Id}, protoEventIdsT(res.Events))
+	res, err = client.ListEvents(ctx, &mypastepb.ListEventsRequest{Limit: 1})
+	require.NoError(t, err)
+	assert.Equal(t, []string{e1.Id}, protoEventIdsT(res.Events))
+	assert.Equal(t, e1.Id, res.Cursor)
+	res, err = client.ListEvents(ctx, &mypastepb.ListEventsRequest{LastId: res.Cursor, Limit: 1})
+	require.NoError(t, err)
+	assert.Equal(t, []string{e2.Id}, protoEventIdsT(res.Events))
+	assert.Empty(t, res.Cursor)
+	_, err = client.ListEvents(ctx, &mypastepb.ListEventsRequest{Limit: -1})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	_, err = client.AddEvent(ctx, &mypastepb.AddEventRequest{Event: &mypastepb.Event{Payload: strings.Repeat("a", testGrpcMaxRecvMsgSize)}})
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+
+	_, err = client.AddEvent(ctx, &mypastepb.AddEventRequest{Event: &mypastepb.Event{
+		Kind:    "DeviceAdded",
+		Payload: `{"Id":"d1","Description":"device 1"}`,
+	}})
+	require.NoError(t, err)
+	devices, err := client.ListDevices(ctx, &mypastepb.ListDevicesRequest{})
+	require.NoError(t, err)
+	require.Len(t, devices.Devices, 1)
+	assert.Equal(t, "d1", devices.Devices[0].Id)
+
+	deleted, err := client.DeleteEvents(ctx, &mypastepb.DeleteEventsRequest{Ids: []string{e1.Id}})
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), deleted.Count)
+
+	_, err = client.ResetStream(ctx, &mypastepb.ResetStreamRequest{})
+	require.NoError(t, err)
+	assert.Empty(t, readEventsT(t, svc, "email", ""))
+}
+
+func TestGrpcServerSubscribe(t *testing.T) {
+	svc := NewMemoryStreamService(StreamConfig{MaxLen: 10, ReadCount: 10, ReadBlock: 50 * time.Millisecond})
+	client := newTestGrpcClient(t, svc, "secret")
+	ctx, cancel := context.WithCancel(grpcTokenContextT(t, svc, "secret", "email"))
+	defer cancel()
+
+	e1 := addEventWithJustPayloadT(t, svc, "email", "hello 1")
+	stream, err := client.Subscribe(ctx, &mypastepb.SubscribeRequest{LastId: e1.Id})
+	require.NoError(t, err)
+	e2 := addEventWithJustPayloadT(t, svc, "email", "hello 2")
+	addEventWithJustPayloadT(t, svc, "email2", "other stream")
+	e3 := addEventWithJustPayloadT(t, svc, "email", "hello 3")
+
+	for _, expected := range []Event{e2, e3} {
+		event, err := stream.Recv()
+		require.NoError(t, err)
+		assert.Equal(t, expected, fromProtoEventT(event))
+	}
+}
+
+func TestGrpcServerSubscribeRevokedSession(t *testing.T) {
+	svc := NewMemoryStreamService(StreamConfig{ReadCount: 10, ReadBlock: time.Minute})
+	client := newTestGrpcClient(t, svc, "secret")
+	stream, err := client.Subscribe(grpcTokenContextT(t, svc, "secret", "email"), &mypastepb.SubscribeRequest{})
+	require.NoError(t, err)
+	e1 := addEventWithJustPayloadT(t, svc, "email", "hello 1")
+	event, err := stream.Recv()
+	require.NoError(t, err)
+	assert.Equal(t, e1, fromProtoEventT(event))
+
+	require.NoError(t, svc.DeleteSessions(context.Background(), "email"))
+	_, err = stream.Recv()
+	assert.Equal(t, codes.Unauthenticated, status.Code(err), "should end the stream once the session is revoked")
+}
+
+func TestGrpcServerUnauthenticated(t *testing.T) {
+	svc := NewMemoryStreamService(StreamConfig{ReadCount: 10, ReadBlock: time.Minute})
+	client := newTestGrpcClient(t, svc, "secret")
+
+	_, err := client.ListDevices(context.Background(), &mypastepb.ListDevicesRequest{})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	_, err = client.ListDevices(grpcTokenContextT(t, svc, "other secret", "email"), &mypastepb.ListDevicesRequest{})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	ctx := grpcTokenContextT(t, svc, "secret", "email")
+	require.NoError(t, svc.DeleteSessions(context.Background(), "email"))
+	_, err = client.ListDevices(ctx, &mypastepb.ListDevicesRequest{})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err), "should reject revoked session")
+
+	stream, err := client.Subscribe(context.Background(), &mypastepb.SubscribeRequest{})
+	require.NoError(t, err)
+	_, err = stream.Recv()
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+}
+
+func TestGrpcServerTLS(t *testing.T) {
+	// the test server certificate is valid for 127.0.0.1
+	tlsServer := httptest.NewTLSServer(http.NotFoundHandler())
+	t.Cleanup(tlsServer.Close)
+	svc := NewMemoryStreamService(StreamConfig{ReadCount: 10, ReadBlock: time.Minute})
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	creds := credentials.NewTLS(&tls.Config{Certificates: tlsServer.TLS.Certificates})
+	server := NewGrpcServer(svc, svc, svc, "secret", testGrpcMaxRecvMsgSize, testRevokeCheckInterval, grpc.Creds(creds))
+	go server.Serve(lis)
+	t.Cleanup(server.Stop)
+	ctx := grpcTokenContextT(t, svc, "secret", "email")
+
+	conn, err := grpc.Dial(lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	_, err = mypastepb.NewMyPasteClient(conn).ListDevices(ctx, &mypastepb.ListDevicesRequest{})
+	assert.Equal(t, codes.Unavailable, status.Code(err), "should refuse plain text clients")
+
+	rootCAs := tlsServer.Client().Transport.(*http.Transport).TLSClientConfig.RootCAs
+	conn, err = grpc.Dial(lis.Addr().String(), grpc.WithTransportCredentials(credentials.NewTLS(&tls.Config{RootCAs: rootCAs})))
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	_, err = mypastepb.NewMyPasteClient(conn).ListDevices(ctx, &mypastepb.ListDevicesRequest{})
+	assert.NoError(t, err)
+}
+
+const testGrpcMaxRecvMsgSize = 1 << 10
+
+func newTestGrpcClient(t *testing.T, svc Store, jwtSignKey string) mypastepb.MyPasteClient {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	server := NewGrpcServer(svc, svc, svc, jwtSignKey, testGrpcMaxRecvMsgSize, testRevokeCheckInterval)
+	go server.Serve(lis)
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.Dial(lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return mypastepb.NewMyPasteClient(conn)
+}
+
+func grpcTokenContextT(t *testing.T, svc Store, jwtSignKey, email string) context.Context {
+	token, err := startSession(context.Background(), svc, User{"name", email}, "", "", jwtSignKey)
+	require.NoError(t, err)
+	return metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer "+token)
+}
+
+func fromProtoEventT(event *mypastepb.Event) Event {
+	return Event{
+		Id:          event.Id,
+		Payload:     event.Payload,
+		Timestamp:   event.Timestamp,
+		Kind:        event.Kind,
+		IsSensitive: event.IsSensitive,
+	}
+}
+
+func protoEventIdsT(events []*mypastepb.Event) []string {
+	ids := make([]string, 0, len(events))
+	for _, event := range events {
+		ids = append(ids, event.Id)
+	}
+	return ids
+}
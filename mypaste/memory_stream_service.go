@@ -0,0 +1,544 @@
+package mypaste
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+type memoryStream struct {
+	events       []Event
+	lastId       streamId
+	maxDeletedId streamId
+	updated      chan struct{}
+}
+
+// removeEvents keeps the events for which keep returns true, the greatest removed id is recorded if record is set.
+func (ms *memoryStream) removeEvents(record bool, keep func(id streamId) bool) int64 {
+	events := make([]Event, 0, len(ms.events))
+	for _, event := range ms.events {
+		id, _ := parseStreamId(event.Id)
+		if keep(id) {
+			events = append(events, event)
+		} else if record && ms.maxDeletedId.Less(id) {
+			ms.maxDeletedId = id
+		}
+	}
+	count := int64(len(ms.events) - len(events))
+	ms.events = events
+	return count
+}
+
+type memoryIdempotencyKey struct {
+	IdempotencyKey
+	expiresAt time.Time
+}
+
+type memoryStreamService struct {
+	config          StreamConfig
+	mtx             sync.Mutex
+	streams         map[string]*memoryStream
+	devices         map[string]map[string]string
+	idempotencyKeys map[string]map[string]memoryIdempotencyKey
+	apiTokens       map[string]map[string]ApiToken
+	sessions        map[string]map[string]Session
+}
+
+var _ Store = (*memoryStreamService)(nil)
+
+func NewMemoryStreamService(config StreamConfig) Store {
+	return &memoryStreamService{
+		config:          config,
+		streams:         make(map[string]*memoryStream),
+		devices:         make(map[string]map[string]string),
+		idempotencyKeys: make(map[string]map[string]memoryIdempotencyKey),
+		apiTokens:       make(map[string]map[string]ApiToken),
+		sessions:        make(map[string]map[string]Session),
+	}
+}
+
+func (s *memoryStreamService) Add(ctx context.Context, stream string, event Event) (Event, error) {
+	added, err := s.AddBatch(ctx, stream, []Event{event})
+	if err != nil {
+		return event, err
+	}
+	return added[0], nil
+}
+
+func (s *memoryStreamService) AddBatch(ctx context.Context, stream string, events []Event) ([]Event, error) {
+	if len(events) == 0 {
+		return []Event{}, nil
+	}
+	devices, err := batchDevices(stream, events)
+	if err != nil {
+		return nil, err
+	}
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if len(devices) > 0 && devices[0].first && len(s.devices[stream]) > 0 {
+		return nil, errDeviceExists(stream)
+	}
+	for _, device := range devices {
+		s.getDevices(stream)[device.Id] = device.Description
+	}
+	ms := s.getStream(stream)
+	added := make([]Event, len(events))
+	for i, event := range events {
+		event.Timestamp = time.Now().Unix()
+		id := nextStreamId(ms.lastId, time.Now())
+		event.Id = id.String()
+		ms.lastId = id
+		ms.events = append(ms.events, event)
+		added[i] = event
+	}
+	s.trim(ms)
+	close(ms.updated)
+	ms.updated = make(chan struct{})
+	return added, nil
+}
+
+func (s *memoryStreamService) trim(ms *memoryStream) {
+	if s.config.MaxLen > 0 && int64(len(ms.events)) > s.config.MaxLen {
+		lastTrimmed, _ := parseStreamId(ms.events[int64(len(ms.events))-s.config.MaxLen-1].Id)
+		if ms.maxDeletedId.Less(lastTrimmed) {
+			ms.maxDeletedId = lastTrimmed
+		}
+		ms.events = append([]Event(nil), ms.events[int64(len(ms.events))-s.config.MaxLen:]...)
+	}
+}
+
+func (s *memoryStreamService) Read(ctx context.Context, stream, lastId string) ([]Event, error) {
+	if lastId == "" {
+		lastId = "0"
+	}
+	after, err := parseStreamId(lastId)
+	if err != nil {
+		return nil, err
+	}
+	return readBlocking(ctx, readBlockOf(ctx, s.config.ReadBlock), func() ([]Event, <-chan struct{}, error) {
+		events, updated := s.readAfter(stream, after)
+		return events, updated, nil
+	})
+}
+
+func (s *memoryStreamService) readAfter(stream string, after streamId) ([]Event, <-chan struct{}) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	ms := s.getStream(stream)
+	events := make([]Event, 0)
+	for _, event := range ms.events {
+		if s.config.ReadCount > 0 && int64(len(events)) >= s.config.ReadCount {
+			break
+		}
+		id, _ := parseStreamId(event.Id)
+		if after.Less(id) {
+			events = append(events, event)
+		}
+	}
+	return events, ms.updated
+}
+
+func (s *memoryStreamService) Get(ctx context.Context, stream, id string) (Event, error) {
+	parsed, err := parseStreamId(id)
+	if err != nil {
+		return Event{}, err
+	}
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	for _, event := range s.getStream(stream).events {
+		if eventId, _ := parseStreamId(event.Id); eventId == parsed {
+			return event, nil
+		}
+	}
+	return Event{}, ErrEventNotFound
+}
+
+func (s *memoryStreamService) Delete(ctx context.Context, stream string, ids ...string) (int64, error) {
+	return s.delete(stream, false, ids)
+}
+
+func (s *memoryStreamService) Expire(ctx context.Context, stream string, ids ...string) (int64, error) {
+	return s.delete(stream, true, ids)
+}
+
+func (s *memoryStreamService) delete(stream string, record bool, ids []string) (int64, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	remove := make(map[streamId]bool, len(ids))
+	for _, id := range ids {
+		parsed, err := parseStreamId(id)
+		if err != nil {
+			return 0, err
+		}
+		remove[parsed] = true
+	}
+	return s.getStream(stream).removeEvents(record, func(id streamId) bool {
+		return !remove[id]
+	}), nil
+}
+
+func (s *memoryStreamService) Reset(ctx context.Context, stream string) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if ms, ok := s.streams[stream]; ok {
+		close(ms.updated)
+		delete(s.streams, stream)
+	}
+	return nil
+}
+
+func (s *memoryStreamService) Len(ctx context.Context, stream string) (int64, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	return int64(len(s.getStream(stream).events)), nil
+}
+
+func (s *memoryStreamService) Range(ctx context.Context, stream, start, end string, count int64) ([]Event, error) {
+	startId, err := parseRangeStart(start)
+	if err != nil {
+		return nil, err
+	}
+	endId, err := parseRangeEnd(end)
+	if err != nil {
+		return nil, err
+	}
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	events := make([]Event, 0)
+	for _, event := range s.getStream(stream).events {
+		if count > 0 && int64(len(events)) >= count {
+			break
+		}
+		id, _ := parseStreamId(event.Id)
+		if !id.Less(startId) && !endId.Less(id) {
+			events = append(events, event)
+		}
+	}
+	return events, nil
+}
+
+func (s *memoryStreamService) RevRange(ctx context.Context, stream, end, start string, count int64) ([]Event, error) {
+	startId, err := parseRangeStart(start)
+	if err != nil {
+		return nil, err
+	}
+	endId, err := parseRangeEnd(end)
+	if err != nil {
+		return nil, err
+	}
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	stored := s.getStream(stream).events
+	events := make([]Event, 0)
+	for i := len(stored) - 1; i >= 0; i-- {
+		if count > 0 && int64(len(events)) >= count {
+			break
+		}
+		id, _ := parseStreamId(stored[i].Id)
+		if !id.Less(startId) && !endId.Less(id) {
+			events = append(events, stored[i])
+		}
+	}
+	return events, nil
+}
+
+func (s *memoryStreamService) Count(ctx context.Context, stream, start, end string) (int64, error) {
+	events, err := s.Range(ctx, stream, start, end, 0)
+	return int64(len(events)), err
+}
+
+func (s *memoryStreamService) Trim(ctx context.Context, stream, minId string) (int64, error) {
+	min, err := parseStreamId(minId)
+	if err != nil {
+		return 0, err
+	}
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	return s.getStream(stream).removeEvents(true, func(id streamId) bool {
+		return !id.Less(min)
+	}), nil
+}
+
+func (s *memoryStreamService) MaxDeletedId(ctx context.Context, stream string) (string, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	ms, ok := s.streams[stream]
+	if !ok || ms.maxDeletedId == (streamId{}) {
+		return "", nil
+	}
+	return ms.maxDeletedId.String(), nil
+}
+
+func (s *memoryStreamService) Import(ctx context.Context, stream string, events []Event) (int64, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	ms := s.getStream(stream)
+	var count int64
+	for _, event := range events {
+		id, err := parseStreamId(event.Id)
+		if err != nil {
+			return count, err
+		}
+		if !ms.lastId.Less(id) {
+			continue
+		}
+		event.Id = id.String()
+		ms.lastId = id
+		ms.events = append(ms.events, event)
+		count++
+	}
+	if count > 0 {
+		s.trim(ms)
+		close(ms.updated)
+		ms.updated = make(chan struct{})
+	}
+	return count, nil
+}
+
+func (s *memoryStreamService) Streams(ctx context.Context) ([]string, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	found := make(map[string]bool)
+	for stream, ms := range s.streams {
+		if len(ms.events) > 0 {
+			found[stream] = true
+		}
+	}
+	for stream, devices := range s.devices {
+		if len(devices) > 0 {
+			found[stream] = true
+		}
+	}
+	return sortedKeys(found), nil
+}
+
+func (s *memoryStreamService) ReserveIdempotencyKey(ctx context.Context, stream, key, requestHash string, ttl time.Duration) (IdempotencyKey, bool, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	now := time.Now()
+	keys, ok := s.idempotencyKeys[stream]
+	if !ok {
+		keys = make(map[string]memoryIdempotencyKey)
+		s.idempotencyKeys[stream] = keys
+	}
+	for k, v := range keys {
+		if !now.Before(v.expiresAt) {
+			delete(keys, k)
+		}
+	}
+	if existing, ok := keys[key]; ok {
+		return existing.IdempotencyKey, false, nil
+	}
+	record := IdempotencyKey{RequestHash: requestHash}
+	keys[key] = memoryIdempotencyKey{IdempotencyKey: record, expiresAt: now.Add(ttl)}
+	return record, true, nil
+}
+
+func (s *memoryStreamService) SetIdempotencyKey(ctx context.Context, stream, key string, record IdempotencyKey, ttl time.Duration) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if keys, ok := s.idempotencyKeys[stream]; ok {
+		keys[key] = memoryIdempotencyKey{IdempotencyKey: record, expiresAt: time.Now().Add(ttl)}
+	}
+	return nil
+}
+
+func (s *memoryStreamService) DeleteIdempotencyKey(ctx context.Context, stream, key string) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	delete(s.idempotencyKeys[stream], key)
+	return nil
+}
+
+func (s *memoryStreamService) AddApiToken(ctx context.Context, stream string, token ApiToken) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	tokens, ok := s.apiTokens[stream]
+	if !ok {
+		tokens = make(map[string]ApiToken)
+		s.apiTokens[stream] = tokens
+	}
+	tokens[token.Id] = token
+	return nil
+}
+
+func (s *memoryStreamService) GetApiTokens(ctx context.Context, stream string) ([]ApiToken, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	tokens := make([]ApiToken, 0, len(s.apiTokens[stream]))
+	for _, token := range s.apiTokens[stream] {
+		tokens = append(tokens, token)
+	}
+	sortApiTokens(tokens)
+	return tokens, nil
+}
+
+func (s *memoryStreamService) GetApiToken(ctx context.Context, hash string) (ApiToken, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	for _, tokens := range s.apiTokens {
+		for _, token := range tokens {
+			if token.Hash == hash {
+				return token, nil
+			}
+		}
+	}
+	return ApiToken{}, ErrApiTokenNotFound
+}
+
+func (s *memoryStreamService) DeleteApiToken(ctx context.Context, stream, id string) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if _, ok := s.apiTokens[stream][id]; !ok {
+		return ErrApiTokenNotFound
+	}
+	delete(s.apiTokens[stream], id)
+	return nil
+}
+
+func (s *memoryStreamService) SetSession(ctx context.Context, stream string, session Session) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	sessions, ok := s.sessions[stream]
+	if !ok {
+		sessions = make(map[string]Session)
+		s.sessions[stream] = sessions
+	}
+	now := time.Now().Unix()
+	for id, existing := range sessions {
+		if existing.ExpiresAt <= now {
+			delete(sessions, id)
+		}
+	}
+	sessions[session.Id] = session
+	return nil
+}
+
+func (s *memoryStreamService) UpdateSession(ctx context.Context, stream string, session Session) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if _, ok := s.sessions[stream][session.Id]; !ok {
+		return ErrSessionNotFound
+	}
+	s.sessions[stream][session.Id] = session
+	return nil
+}
+
+func (s *memoryStreamService) GetSession(ctx context.Context, stream, id string) (Session, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	session, ok := s.sessions[stream][id]
+	if !ok || session.ExpiresAt <= time.Now().Unix() {
+		return Session{}, ErrSessionNotFound
+	}
+	return session, nil
+}
+
+func (s *memoryStreamService) GetSessions(ctx context.Context, stream string) ([]Session, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	now := time.Now().Unix()
+	sessions := make([]Session, 0, len(s.sessions[stream]))
+	for _, session := range s.sessions[stream] {
+		if session.ExpiresAt > now {
+			sessions = append(sessions, session)
+		}
+	}
+	sortSessions(sessions)
+	return sessions, nil
+}
+
+func (s *memoryStreamService) DeleteSession(ctx context.Context, stream, id string) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if _, ok := s.sessions[stream][id]; !ok {
+		return ErrSessionNotFound
+	}
+	delete(s.sessions[stream], id)
+	return nil
+}
+
+func (s *memoryStreamService) DeleteSessions(ctx context.Context, stream string) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	delete(s.sessions, stream)
+	return nil
+}
+
+func (s *memoryStreamService) Close() error {
+	return nil
+}
+
+func (s *memoryStreamService) AddDevice(ctx context.Context, stream string, device Device) (Device, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	s.getDevices(stream)[device.Id] = device.Description
+	return device, nil
+}
+
+func (s *memoryStreamService) AddFirstDevice(ctx context.Context, stream string, device Device) (Device, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	devices := s.getDevices(stream)
+	if len(devices) > 0 {
+		return device, errDeviceExists(stream)
+	}
+	devices[device.Id] = device.Description
+	return device, nil
+}
+
+func (s *memoryStreamService) GetDevices(ctx context.Context, stream string) ([]Device, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	devices := make([]Device, 0, len(s.devices[stream]))
+	for key, value := range s.devices[stream] {
+		devices = append(devices, Device{Id: key, Description: value})
+	}
+	return devices, nil
+}
+
+func (s *memoryStreamService) getStream(stream string) *memoryStream {
+	ms, ok := s.streams[stream]
+	if !ok {
+		ms = &memoryStream{updated: make(chan struct{})}
+		s.streams[stream] = ms
+	}
+	return ms
+}
+
+func (s *memoryStreamService) getDevices(stream string) map[string]string {
+	devices, ok := s.devices[stream]
+	if !ok {
+		devices = make(map[string]string)
+		s.devices[stream] = devices
+	}
+	return devices
+}
@@ -0,0 +1,59 @@
+package mypaste
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryStreamService(t *testing.T) {
+
+	t.Run("ids should be monotonic", func(t *testing.T) {
+		svc := NewMemoryStreamService(StreamConfig{ReadBlock: -1})
+		var lastId streamId
+		for i := 0; i < 20; i++ {
+			event, err := svc.Add(context.Background(), "email", Event{Payload: "hello"})
+			require.NoError(t, err)
+			id, err := parseStreamId(event.Id)
+			require.NoError(t, err)
+			assert.True(t, lastId.Less(id), "%v should be greater than %v", id, lastId)
+			lastId = id
+		}
+	})
+
+	t.Run("should trim to max len", func(t *testing.T) {
+		svc := NewMemoryStreamService(StreamConfig{MaxLen: 3, ReadBlock: -1})
+		var added []Event
+		for i := 0; i < 5; i++ {
+			event, err := svc.Add(context.Background(), "email", Event{Payload: "hello"})
+			require.NoError(t, err)
+			added = append(added, event)
+		}
+		events, err := svc.Read(context.Background(), "email", "")
+		require.NoError(t, err)
+		assert.Equal(t, added[2:], events)
+	})
+
+	t.Run("should limit read count", func(t *testing.T) {
+		svc := NewMemoryStreamService(StreamConfig{ReadCount: 2, ReadBlock: -1})
+		for i := 0; i < 5; i++ {
+			_, err := svc.Add(context.Background(), "email", Event{Payload: "hello"})
+			require.NoError(t, err)
+		}
+		events, err := svc.Read(context.Background(), "email", "")
+		require.NoError(t, err)
+		assert.Equal(t, 2, len(events))
+	})
+
+	t.Run("read should return on context cancel", func(t *testing.T) {
+		svc := NewMemoryStreamService(StreamConfig{ReadBlock: time.Minute})
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Millisecond)
+		defer cancel()
+		_, err := svc.Read(ctx, "email", "")
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+	})
+
+}
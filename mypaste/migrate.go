@@ -0,0 +1,94 @@
+package mypaste
+
+import (
+	"context"
+	"fmt"
+	"io"
+)
+
+// Migrate copies the events and devices of every stream keeping event ids and timestamps.
+// Events already in the target are skipped, so a failed migration can be run again to resume.
+// The source should not receive new events while migrating, otherwise the final count check fails.
+// Archived events are copied and counted only if from is an archiving stream service.
+func Migrate(ctx context.Context, from, to StreamService, batchSize int64, out io.Writer) error {
+	streams, err := from.Streams(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list streams, %w", err)
+	}
+	for i, stream := range streams {
+		devices, events, err := migrateStream(ctx, from, to, stream, batchSize)
+		if err != nil {
+			return fmt.Errorf("failed to migrate stream %v, %w", stream, err)
+		}
+		fmt.Fprintf(out, "[%v/%v] %v: %v events, %v devices\n", i+1, len(streams), stream, events, devices)
+	}
+	var mismatched []string
+	for _, stream := range streams {
+		ok, err := verifyMigratedStream(ctx, from, to, stream)
+		if err != nil {
+			return fmt.Errorf("failed to verify stream %v, %w", stream, err)
+		}
+		if !ok {
+			mismatched = append(mismatched, stream)
+		}
+	}
+	if len(mismatched) > 0 {
+		return fmt.Errorf("counts do not match for streams: %v", mismatched)
+	}
+	fmt.Fprintf(out, "migrated and verified %v streams\n", len(streams))
+	return nil
+}
+
+func migrateStream(ctx context.Context, from, to StreamService, stream string, batchSize int64) (int, int64, error) {
+	devices, err := from.GetDevices(ctx, stream)
+	if err != nil {
+		return 0, 0, err
+	}
+	for _, device := range devices {
+		if _, err := to.AddDevice(ctx, stream, device); err != nil {
+			return 0, 0, err
+		}
+	}
+	var count int64
+	start := "-"
+	for {
+		events, err := from.Range(ctx, stream, start, "+", batchSize)
+		if err != nil {
+			return len(devices), count, err
+		}
+		if len(events) == 0 {
+			return len(devices), count, nil
+		}
+		imported, err := to.Import(ctx, stream, events)
+		count += imported
+		if err != nil {
+			return len(devices), count, err
+		}
+		lastId, err := parseStreamId(events[len(events)-1].Id)
+		if err != nil {
+			return len(devices), count, err
+		}
+		start = lastId.successor().String()
+	}
+}
+
+func verifyMigratedStream(ctx context.Context, from, to StreamService, stream string) (bool, error) {
+	// Count includes archived events unlike Len
+	fromLen, err := from.Count(ctx, stream, "-", "+")
+	if err != nil {
+		return false, err
+	}
+	toLen, err := to.Count(ctx, stream, "-", "+")
+	if err != nil {
+		return false, err
+	}
+	fromDevices, err := from.GetDevices(ctx, stream)
+	if err != nil {
+		return false, err
+	}
+	toDevices, err := to.GetDevices(ctx, stream)
+	if err != nil {
+		return false, err
+	}
+	return fromLen == toLen && len(fromDevices) == len(toDevices), nil
+}
@@ -0,0 +1,98 @@
+package mypaste
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMigrate(t *testing.T) {
+	ctx := context.Background()
+	cfg := StreamConfig{ReadCount: 100, ReadBlock: -1}
+	from := NewMemoryStreamService(cfg)
+	to := newTestSqliteStreamService(t, cfg)
+
+	var added []Event
+	for i := 0; i < 25; i++ {
+		event, err := from.Add(ctx, "email1", Event{Payload: "hello", Kind: "Text"})
+		require.NoError(t, err)
+		added = append(added, event)
+	}
+	_, err := from.AddDevice(ctx, "email1", Device{Id: "d1", Description: "device 1"})
+	require.NoError(t, err)
+	_, err = from.AddDevice(ctx, "email2", Device{Id: "d2", Description: "device 2"})
+	require.NoError(t, err)
+
+	// a crashed migration left some events in the target
+	_, err = to.Import(ctx, "email1", added[:10])
+	require.NoError(t, err)
+
+	var out bytes.Buffer
+	require.NoError(t, Migrate(ctx, from, to, 7, &out))
+	assert.Contains(t, out.String(), "[1/2] email1: 15 events, 1 devices")
+	assert.Contains(t, out.String(), "migrated and verified 2 streams")
+
+	events, err := to.Range(ctx, "email1", "-", "+", 0)
+	require.NoError(t, err)
+	assert.Equal(t, added, events)
+	devices, err := to.GetDevices(ctx, "email2")
+	require.NoError(t, err)
+	assert.Equal(t, []Device{{Id: "d2", Description: "device 2"}}, devices)
+
+	// running again copies nothing
+	out.Reset()
+	require.NoError(t, Migrate(ctx, from, to, 7, &out))
+	assert.Contains(t, out.String(), "[1/2] email1: 0 events, 1 devices")
+}
+
+func TestMigrateVerifyCounts(t *testing.T) {
+	ctx := context.Background()
+	cfg := StreamConfig{ReadCount: 100, ReadBlock: -1}
+	from := NewMemoryStreamService(cfg)
+	to := NewMemoryStreamService(cfg)
+
+	_, err := from.Add(ctx, "email", Event{Payload: "hello"})
+	require.NoError(t, err)
+	time.Sleep(2 * time.Millisecond)
+	// the target has a newer event, so the source event is skipped
+	_, err = to.Add(ctx, "email", Event{Payload: "newer"})
+	require.NoError(t, err)
+	_, err = to.Add(ctx, "email", Event{Payload: "newer"})
+	require.NoError(t, err)
+
+	err = Migrate(ctx, from, to, 10, &bytes.Buffer{})
+	assert.ErrorContains(t, err, "counts do not match for streams: [email]")
+}
+
+func TestMigrateArchivedEvents(t *testing.T) {
+	ctx := context.Background()
+	cfg := StreamConfig{ReadCount: 100, ReadBlock: -1}
+	live := NewMemoryStreamService(cfg)
+	archive := NewFileArchiveStore(t.TempDir())
+	archiving := NewArchivingStreamService(live, archive, ArchiveConfig{MaxLen: 2, ReadCount: 100})
+	var added []Event
+	for i := 0; i < 5; i++ {
+		event, err := archiving.Add(ctx, "email", Event{Payload: "hello"})
+		require.NoError(t, err)
+		added = append(added, event)
+	}
+
+	// the migrate command opens the source archive without max len
+	from := NewArchivingStreamService(live, archive, ArchiveConfig{ReadCount: 100})
+	to := NewMemoryStreamService(cfg)
+	require.NoError(t, Migrate(ctx, from, to, 2, &bytes.Buffer{}))
+	events, err := to.Range(ctx, "email", "-", "+", 0)
+	require.NoError(t, err)
+	assert.Equal(t, added, events)
+
+	// a target missing the archived events is not verified
+	to = NewMemoryStreamService(cfg)
+	_, err = to.Import(ctx, "email", added[3:])
+	require.NoError(t, err)
+	err = Migrate(ctx, from, to, 2, &bytes.Buffer{})
+	assert.ErrorContains(t, err, "counts do not match for streams: [email]")
+}
@@ -1,21 +1,28 @@
 package mypaste
 
 import (
+	"context"
 	"embed"
 	"encoding/hex"
 	"fmt"
 	"html/template"
 	"io"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/labstack/echo/v4"
 	echomw "github.com/labstack/echo/v4/middleware"
-	"github.com/redis/go-redis/v9"
+	"github.com/labstack/gommon/bytes"
 	"golang.org/x/crypto/acme/autocert"
 	"golang.org/x/crypto/sha3"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
 )
 
 type config struct {
@@ -24,60 +31,128 @@ type config struct {
 	WebappBundleDir  string
 	LoginCallbackUri string
 	ServeAddr        string
+	GrpcAddr         string
+	GrpcInsecure     string
 	EnableAutoTLS    string
 	TlsCacheDir      string
 	TlsDomain        string
+	StoreUrl         string
 	RedisUrl         string
+	StreamMaxLen     string
+	ArchiveUrl       string
 	ReqBodyLimit     string
+	TrustedProxies   string
+
+	RetentionMaxAge          string
+	RetentionSensitiveMaxAge string
+	RetentionUserPolicies    string
+
+	OidcIssuerUrl             string
+	OidcClientId              string
+	OidcClientSecret          string
+	OidcRedirectUri           string
+	OidcProviderName          string
+	OidcEmailVerifiedOptional string
+
+	GitHubClientId     string
+	GitHubClientSecret string
+	GitHubRedirectUri  string
 }
 
 func Start() {
 
 	cfg := loadConfig()
-	loginCallbackEndpoint := parseLoginCallbackEndpoint(cfg.LoginCallbackUri)
 
-	redisOpts, err := redis.ParseURL(cfg.RedisUrl)
-	if err != nil {
-		panic(fmt.Errorf("failed to parse redis url, %v, %w", cfg.RedisUrl, err))
-	}
-	redisClient := redis.NewClient(redisOpts)
-	streamService := NewRedisStreamService(redisClient, RedisStreamConfig{
-		MaxLen:    100,
-		ReadCount: 100,
-		ReadBlock: 5 * time.Minute,
-	})
+	retentionConfig := newRetentionConfig(cfg)
+	store, streamService := newStreamService(cfg, retentionConfig)
+	go NewRetentionJanitor(streamService, retentionConfig, time.Minute).Run(context.Background())
 
 	e := echo.New()
+	if cfg.EnableAutoTLS == "1" {
+		e.AutoTLSManager.Cache = autocert.DirCache(cfg.TlsCacheDir)
+		e.AutoTLSManager.HostPolicy = autocert.HostWhitelist(cfg.TlsDomain)
+	}
+	if cfg.GrpcAddr != "" {
+		creds := grpc.Creds(newGrpcCredentials(cfg, &e.AutoTLSManager))
+		go serveGrpc(cfg.GrpcAddr, NewGrpcServer(streamService, store, store, cfg.JwtSignKey, parseReqBodyLimit(cfg.ReqBodyLimit), time.Minute, creds))
+	}
 	e.Use(echomw.Recover())
 	e.Use(echomw.Logger())
-	e.Use(echomw.Gzip())
+	e.Use(echomw.GzipWithConfig(echomw.GzipConfig{
+		Skipper: func(c echo.Context) bool {
+			return c.IsWebSocket()
+		},
+	}))
 	e.Use(NewWebappServerMiddleware(cfg.WebappBundleDir))
 	e.Renderer = NewRenderer()
 	e.HideBanner = true
+	e.IPExtractor = newIPExtractor(cfg.TrustedProxies)
 
-	e.GET("/login", LoginPageHandler(cfg.GoogleClientId, cfg.LoginCallbackUri))
-	e.POST(loginCallbackEndpoint, LoginCallbackHandler(NewIdTokenValidator(cfg.GoogleClientId), cfg.JwtSignKey))
+	var loginProviders []LoginProvider
+	if cfg.GoogleClientId != "" {
+		loginCallbackEndpoint := parseLoginCallbackEndpoint(cfg.LoginCallbackUri)
+		e.POST(loginCallbackEndpoint, LoginCallbackHandler(NewIdTokenValidator(cfg.GoogleClientId), store, cfg.JwtSignKey))
+	}
+	if cfg.OidcIssuerUrl != "" {
+		provider := newOIDCProvider(cfg)
+		e.GET("/login/oidc", OIDCLoginHandler(provider, cfg.JwtSignKey))
+		e.GET(parseLoginCallbackEndpoint(cfg.OidcRedirectUri), OIDCCallbackHandler(provider, store, cfg.JwtSignKey))
+		name := cfg.OidcProviderName
+		if name == "" {
+			name = "OpenID Connect"
+		}
+		loginProviders = append(loginProviders, LoginProvider{Name: name, LoginUrl: "/login/oidc"})
+	}
+	if cfg.GitHubClientId != "" {
+		provider := NewGitHubProvider(GitHubConfig{
+			ClientId:     cfg.GitHubClientId,
+			ClientSecret: cfg.GitHubClientSecret,
+			RedirectUri:  cfg.GitHubRedirectUri,
+		})
+		e.GET("/login/github", GitHubLoginHandler(provider, cfg.JwtSignKey))
+		e.GET(parseLoginCallbackEndpoint(cfg.GitHubRedirectUri), GitHubCallbackHandler(provider, store, cfg.JwtSignKey))
+		loginProviders = append(loginProviders, LoginProvider{Name: "GitHub", LoginUrl: "/login/github"})
+	}
+	e.GET("/login", LoginPageHandler(cfg.GoogleClientId, cfg.LoginCallbackUri, loginProviders...))
 
-	authmw := NewAuthMiddleware(cfg.JwtSignKey)
+	authmw := NewAuthMiddleware(cfg.JwtSignKey, store, store)
 	api := e.Group("/api", authmw)
+	readEvents := RequireScopes(ScopeEventsRead)
+	writeEvents := RequireScopes(ScopeEventsWrite)
 
 	{
-		g := api.Group("/auth")
-		g.POST("/authenticate", AuthenticateHandler(cfg.JwtSignKey))
-		g.POST("/logout", LogoutHandler())
+		g := api.Group("/auth", RejectApiTokens)
+		g.POST("/authenticate", AuthenticateHandler(store, cfg.JwtSignKey))
+		g.POST("/logout", LogoutHandler(store))
+		g.POST("/logout-everywhere", LogoutEverywhereHandler(store))
+		g.GET("/sessions", GetSessionsHandler(store))
+		g.DELETE("/sessions/:id", DeleteSessionHandler(store))
+		g.POST("/tokens", CreateApiTokenHandler(store))
+		g.GET("/tokens", GetApiTokensHandler(store))
+		g.DELETE("/tokens/:id", DeleteApiTokenHandler(store))
 	}
 
 	{
 		g := api.Group("/event")
-		g.POST("", AddEventHandler(streamService))
-		g.GET("", ReadEventsHandler(streamService))
-		g.DELETE("", DeleteEventsHandler(streamService))
-		g.DELETE("/reset", ResetStreamHandler(streamService))
+		g.POST("", AddEventHandler(streamService, store), writeEvents)
+		g.POST("/batch", AddEventsHandler(streamService), writeEvents)
+		g.GET("", ReadEventsHandler(streamService), readEvents)
+		g.GET("/stream", StreamEventsHandler(streamService, store, store, 15*time.Second), readEvents)
+		g.GET("/:id", GetEventHandler(streamService), readEvents)
+		g.DELETE("", DeleteEventsHandler(streamService), writeEvents)
+		g.DELETE("/reset", ResetStreamHandler(streamService), writeEvents)
 	}
 
+	api.GET("/ws", WebSocketHandler(streamService, store, store, parseReqBodyLimit(cfg.ReqBodyLimit), time.Minute), RequireScopes(ScopeEventsRead, ScopeEventsWrite))
+
 	{
 		g := api.Group("/device")
-		g.GET("", GetDevicesHandler(streamService))
+		g.GET("", GetDevicesHandler(streamService), RequireScopes(ScopeDevicesRead))
+	}
+
+	{
+		g := api.Group("/retention")
+		g.GET("", GetRetentionPolicyHandler(retentionConfig), readEvents)
 	}
 
 	api.Any("/*", ApiNotFoundHandler)
@@ -87,12 +162,32 @@ func Start() {
 		e.Logger.Fatal(e.Start(cfg.ServeAddr))
 		return
 	}
-	e.AutoTLSManager.Cache = autocert.DirCache(cfg.TlsCacheDir)
-	e.AutoTLSManager.HostPolicy = autocert.HostWhitelist(cfg.TlsDomain)
 	e.Pre(echomw.HTTPSRedirect())
 	e.Logger.Fatal(e.StartAutoTLS(cfg.ServeAddr))
 }
 
+// newGrpcCredentials serves grpc with the certificates of the http server,
+// without auto tls grpc is served in plain text only if GRPC_INSECURE=1, eg. behind a tls terminating proxy.
+func newGrpcCredentials(cfg config, tlsManager *autocert.Manager) credentials.TransportCredentials {
+	if cfg.EnableAutoTLS == "1" {
+		return credentials.NewTLS(tlsManager.TLSConfig())
+	}
+	if cfg.GrpcInsecure == "1" {
+		return insecure.NewCredentials()
+	}
+	panic(fmt.Errorf("GRPC_ADDR requires ENABLE_AUTO_TLS=1, or GRPC_INSECURE=1 to serve grpc without tls"))
+}
+
+func serveGrpc(addr string, server *grpc.Server) {
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		panic(fmt.Errorf("failed to listen grpc addr, %w", err))
+	}
+	if err := server.Serve(lis); err != nil {
+		panic(fmt.Errorf("failed to serve grpc, %w", err))
+	}
+}
+
 func loadConfig() config {
 	return config{
 		GoogleClientId:   GetEnvVerbose("GOOGLE_CLIENT_ID", false),
@@ -100,12 +195,166 @@ func loadConfig() config {
 		WebappBundleDir:  GetEnvVerbose("WEBAPP_BUNDLE_DIR", false),
 		LoginCallbackUri: GetEnvVerbose("LOGIN_CALLBACK_URI", false),
 		ServeAddr:        GetEnvVerbose("SERVE_ADDR", false),
+		GrpcAddr:         GetEnvVerbose("GRPC_ADDR", false),
+		GrpcInsecure:     GetEnvVerbose("GRPC_INSECURE", false),
 		EnableAutoTLS:    GetEnvVerbose("ENABLE_AUTO_TLS", false),
 		TlsCacheDir:      GetEnvVerbose("TLS_CACHE_DIR", false),
 		TlsDomain:        GetEnvVerbose("TLS_DOMAIN", false),
+		StoreUrl:         GetEnvVerbose("STORE_URL", true),
 		RedisUrl:         GetEnvVerbose("REDIS_URL", true),
+		StreamMaxLen:     GetEnvVerbose("STREAM_MAX_LEN", false),
+		ArchiveUrl:       GetEnvVerbose("ARCHIVE_URL", true),
 		ReqBodyLimit:     GetEnvVerbose("REQ_BODY_LIMIT", false),
+		TrustedProxies:   GetEnvVerbose("TRUSTED_PROXIES", false),
+
+		RetentionMaxAge:          GetEnvVerbose("RETENTION_MAX_AGE", false),
+		RetentionSensitiveMaxAge: GetEnvVerbose("RETENTION_SENSITIVE_MAX_AGE", false),
+		RetentionUserPolicies:    GetEnvVerbose("RETENTION_USER_POLICIES", false),
+
+		OidcIssuerUrl:             GetEnvVerbose("OIDC_ISSUER_URL", false),
+		OidcClientId:              GetEnvVerbose("OIDC_CLIENT_ID", false),
+		OidcClientSecret:          GetEnvVerbose("OIDC_CLIENT_SECRET", true),
+		OidcRedirectUri:           GetEnvVerbose("OIDC_REDIRECT_URI", false),
+		OidcProviderName:          GetEnvVerbose("OIDC_PROVIDER_NAME", false),
+		OidcEmailVerifiedOptional: GetEnvVerbose("OIDC_EMAIL_VERIFIED_OPTIONAL", false),
+
+		GitHubClientId:     GetEnvVerbose("GITHUB_CLIENT_ID", false),
+		GitHubClientSecret: GetEnvVerbose("GITHUB_CLIENT_SECRET", true),
+		GitHubRedirectUri:  GetEnvVerbose("GITHUB_REDIRECT_URI", false),
+	}
+}
+
+// newStreamService opens the store and returns it with the stream service serving its events.
+func newStreamService(cfg config, retentionConfig RetentionConfig) (Store, StreamService) {
+	storeUrl := cfg.storeUrl()
+	if storeUrl == "" {
+		fmt.Println("STORE_URL and REDIS_URL are not set, using in-memory stream service")
+	}
+	streamConfig := StreamConfig{
+		MaxLen:    parseStreamMaxLen(cfg.StreamMaxLen, storeUrl),
+		ReadCount: 100,
+		ReadBlock: 5 * time.Minute,
+	}
+	maxLen := streamConfig.MaxLen
+	if cfg.ArchiveUrl != "" || retentionConfig.hasUserMaxLen() {
+		// the archiving stream service trims the stream after archiving,
+		// otherwise the retention janitor trims each stream to the max len of its user
+		streamConfig.MaxLen = 0
+	}
+	store, err := OpenStreamService(storeUrl, streamConfig)
+	if err != nil {
+		panic(fmt.Errorf("failed to open stream service, %w", err))
+	}
+	var streamService StreamService = store
+	if cfg.ArchiveUrl != "" {
+		archive, err := OpenArchiveStore(cfg.ArchiveUrl)
+		if err != nil {
+			panic(fmt.Errorf("failed to open archive store, %w", err))
+		}
+		streamService = NewArchivingStreamService(streamService, archive, ArchiveConfig{
+			MaxLen:    maxLen,
+			BatchSize: 20,
+			ReadCount: streamConfig.ReadCount,
+		})
+	}
+	if !isRedisStoreUrl(storeUrl) {
+		// the other stores wait for new events in process without holding a connection
+		return store, streamService
+	}
+	// waiting requests share one blocking read per stream
+	return store, NewStreamHub(streamService, streamConfig.ReadBlock)
+}
+
+func newOIDCProvider(cfg config) *OIDCProvider {
+	provider, err := NewOIDCProvider(context.Background(), OIDCConfig{
+		IssuerUrl:             cfg.OidcIssuerUrl,
+		ClientId:              cfg.OidcClientId,
+		ClientSecret:          cfg.OidcClientSecret,
+		RedirectUri:           cfg.OidcRedirectUri,
+		EmailVerifiedOptional: cfg.OidcEmailVerifiedOptional == "1",
+	})
+	if err != nil {
+		panic(fmt.Errorf("failed to create oidc provider, %w", err))
+	}
+	return provider
+}
+
+func newRetentionConfig(cfg config) RetentionConfig {
+	maxLen := parseStreamMaxLen(cfg.StreamMaxLen, cfg.storeUrl())
+	if cfg.ArchiveUrl != "" {
+		// events over max len are archived instead of removed
+		maxLen = 0
+	}
+	retentionConfig, err := ParseRetentionConfig(maxLen, cfg.RetentionMaxAge, cfg.RetentionSensitiveMaxAge, cfg.RetentionUserPolicies)
+	if err != nil {
+		panic(fmt.Errorf("failed to parse retention config, %w", err))
+	}
+	return retentionConfig
+}
+
+func (cfg config) storeUrl() string {
+	if cfg.StoreUrl != "" {
+		return cfg.StoreUrl
+	}
+	return cfg.RedisUrl
+}
+
+// parseStreamMaxLen defaults to 100 events for redis and memory,
+// the sql backends keep the full history unless a max len or retention is configured.
+func parseStreamMaxLen(value, storeUrl string) int64 {
+	if value == "" {
+		if isSqlStoreUrl(storeUrl) {
+			return 0
+		}
+		return 100
+	}
+	maxLen, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		panic(fmt.Errorf("failed to parse stream max len, %v, %w", value, err))
+	}
+	return maxLen
+}
+
+// parseReqBodyLimit parses REQ_BODY_LIMIT for the transports not going through the body limit middleware.
+func parseReqBodyLimit(value string) int {
+	limit, err := bytes.Parse(value)
+	if err != nil {
+		panic(fmt.Errorf("failed to parse req body limit, %v, %w", value, err))
+	}
+	return int(limit)
+}
+
+// newIPExtractor uses the remote address unless TRUSTED_PROXIES lists the proxy ranges allowed to set X-Forwarded-For.
+func newIPExtractor(trustedProxies string) echo.IPExtractor {
+	if trustedProxies == "" {
+		return echo.ExtractIPDirect()
+	}
+	options := []echo.TrustOption{echo.TrustLoopback(false), echo.TrustLinkLocal(false), echo.TrustPrivateNet(false)}
+	for _, value := range strings.Split(trustedProxies, ",") {
+		_, ipRange, err := net.ParseCIDR(strings.TrimSpace(value))
+		if err != nil {
+			panic(fmt.Errorf("failed to parse trusted proxies, %v, %w", value, err))
+		}
+		options = append(options, echo.TrustIPRange(ipRange))
+	}
+	return echo.ExtractIPFromXFFHeader(options...)
+}
+
+func isRedisStoreUrl(storeUrl string) bool {
+	u, err := url.Parse(storeUrl)
+	return err == nil && strings.HasPrefix(u.Scheme, "redis")
+}
+
+func isSqlStoreUrl(storeUrl string) bool {
+	u, err := url.Parse(storeUrl)
+	if err != nil {
+		return false
+	}
+	switch u.Scheme {
+	case "sqlite", "postgres", "postgresql":
+		return true
 	}
+	return false
 }
 
 func parseLoginCallbackEndpoint(loginCallbackUri string) string {
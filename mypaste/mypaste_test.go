@@ -0,0 +1,55 @@
+package mypaste
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+func TestParseStreamMaxLen(t *testing.T) {
+	assert.Equal(t, int64(100), parseStreamMaxLen("", ""))
+	assert.Equal(t, int64(100), parseStreamMaxLen("", "redis://localhost:6379"))
+	assert.Equal(t, int64(0), parseStreamMaxLen("", "sqlite:///var/lib/mypaste.db"), "should keep sql history")
+	assert.Equal(t, int64(0), parseStreamMaxLen("", "postgres://localhost:5432/mypaste"))
+	assert.Equal(t, int64(500), parseStreamMaxLen("500", "sqlite:///var/lib/mypaste.db"))
+}
+
+func TestNewIPExtractor(t *testing.T) {
+	newRequest := func(remoteAddr string) *http.Request {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = remoteAddr
+		req.Header.Set(echo.HeaderXRealIP, "10.0.0.9")
+		req.Header.Set(echo.HeaderXForwardedFor, "10.0.0.9, 10.0.0.2")
+		return req
+	}
+
+	extractIP := newIPExtractor("")
+	assert.Equal(t, "10.0.0.1", extractIP(newRequest("10.0.0.1:1234")), "should ignore spoofed headers without trusted proxies")
+
+	extractIP = newIPExtractor("192.168.0.0/24, 127.0.0.1/32")
+	assert.Equal(t, "10.0.0.2", extractIP(newRequest("192.168.0.5:1234")), "should take the client ip from the trusted proxy")
+	assert.Equal(t, "10.0.0.1", extractIP(newRequest("10.0.0.1:1234")), "should ignore headers from untrusted addresses")
+
+	assert.Panics(t, func() { newIPExtractor("10.0.0.1") })
+}
+
+func TestNewGrpcCredentials(t *testing.T) {
+	creds := newGrpcCredentials(config{EnableAutoTLS: "1"}, &autocert.Manager{})
+	assert.Equal(t, "tls", creds.Info().SecurityProtocol)
+
+	creds = newGrpcCredentials(config{GrpcInsecure: "1"}, &autocert.Manager{})
+	assert.Equal(t, "insecure", creds.Info().SecurityProtocol)
+
+	assert.Panics(t, func() { newGrpcCredentials(config{}, &autocert.Manager{}) }, "should not serve plain text unless insecure is set")
+}
+
+func TestIsRedisStoreUrl(t *testing.T) {
+	assert.True(t, isRedisStoreUrl("redis://localhost:6379"))
+	assert.True(t, isRedisStoreUrl("rediss+cluster://localhost:6379"))
+	assert.False(t, isRedisStoreUrl(""))
+	assert.False(t, isRedisStoreUrl("sqlite:///var/lib/mypaste.db"))
+}
@@ -0,0 +1,326 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.3.0
+// - protoc             (unknown)
+// source: mypaste.proto
+
+package mypastepb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+const (
+	MyPaste_AddEvent_FullMethodName     = "/mypaste.MyPaste/AddEvent"
+	MyPaste_ListEvents_FullMethodName   = "/mypaste.MyPaste/ListEvents"
+	MyPaste_DeleteEvents_FullMethodName = "/mypaste.MyPaste/DeleteEvents"
+	MyPaste_ResetStream_FullMethodName  = "/mypaste.MyPaste/ResetStream"
+	MyPaste_ListDevices_FullMethodName  = "/mypaste.MyPaste/ListDevices"
+	MyPaste_Subscribe_FullMethodName    = "/mypaste.MyPaste/Subscribe"
+)
+
+// MyPasteClient is the client API for MyPaste service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type MyPasteClient interface {
+	AddEvent(ctx context.Context, in *AddEventRequest, opts ...grpc.CallOption) (*Event, error)
+	// ListEvents returns a page of the events after last_id without waiting for new events.
+	ListEvents(ctx context.Context, in *ListEventsRequest, opts ...grpc.CallOption) (*ListEventsResponse, error)
+	DeleteEvents(ctx context.Context, in *DeleteEventsRequest, opts ...grpc.CallOption) (*DeleteEventsResponse, error)
+	ResetStream(ctx context.Context, in *ResetStreamRequest, opts ...grpc.CallOption) (*ResetStreamResponse, error)
+	ListDevices(ctx context.Context, in *ListDevicesRequest, opts ...grpc.CallOption) (*ListDevicesResponse, error)
+	// Subscribe sends the events after last_id, then every new event until cancelled.
+	Subscribe(ctx context.Context, in *SubscribeRequest, opts ...grpc.CallOption) (MyPaste_SubscribeClient, error)
+}
+
+type myPasteClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewMyPasteClient(cc grpc.ClientConnInterface) MyPasteClient {
+	return &myPasteClient{cc}
+}
+
+func (c *myPasteClient) AddEvent(ctx context.Context, in *AddEventRequest, opts ...grpc.CallOption) (*Event, error) {
+	out := new(Event)
+	err := c.cc.Invoke(ctx, MyPaste_AddEvent_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *myPasteClient) ListEvents(ctx context.Context, in *ListEventsRequest, opts ...grpc.CallOption) (*ListEventsResponse, error) {
+	out := new(ListEventsResponse)
+	err := c.cc.Invoke(ctx, MyPaste_ListEvents_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *myPasteClient) DeleteEvents(ctx context.Context, in *DeleteEventsRequest, opts ...grpc.CallOption) (*DeleteEventsResponse, error) {
+	out := new(DeleteEventsResponse)
+	err := c.cc.Invoke(ctx, MyPaste_DeleteEvents_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *myPasteClient) ResetStream(ctx context.Context, in *ResetStreamRequest, opts ...grpc.CallOption) (*ResetStreamResponse, error) {
+	out := new(ResetStreamResponse)
+	err := c.cc.Invoke(ctx, MyPaste_ResetStream_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *myPasteClient) ListDevices(ctx context.Context, in *ListDevicesRequest, opts ...grpc.CallOption) (*ListDevicesResponse, error) {
+	out := new(ListDevicesResponse)
+	err := c.cc.Invoke(ctx, MyPaste_ListDevices_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *myPasteClient) Subscribe(ctx context.Context, in *SubscribeRequest, opts ...grpc.CallOption) (MyPaste_SubscribeClient, error) {
+	stream, err := c.cc.NewStream(ctx, &MyPaste_ServiceDesc.Streams[0], MyPaste_Subscribe_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &myPasteSubscribeClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type MyPaste_SubscribeClient interface {
+	Recv() (*Event, error)
+	grpc.ClientStream
+}
+
+type myPasteSubscribeClient struct {
+	grpc.ClientStream
+}
+
+func (x *myPasteSubscribeClient) Recv() (*Event, error) {
+	m := new(Event)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// MyPasteServer is the server API for MyPaste service.
+// All implementations must embed UnimplementedMyPasteServer
+// for forward compatibility
+type MyPasteServer interface {
+	AddEvent(context.Context, *AddEventRequest) (*Event, error)
+	// ListEvents returns a page of the events after last_id without waiting for new events.
+	ListEvents(context.Context, *ListEventsRequest) (*ListEventsResponse, error)
+	DeleteEvents(context.Context, *DeleteEventsRequest) (*DeleteEventsResponse, error)
+	ResetStream(context.Context, *ResetStreamRequest) (*ResetStreamResponse, error)
+	ListDevices(context.Context, *ListDevicesRequest) (*ListDevicesResponse, error)
+	// Subscribe sends the events after last_id, then every new event until cancelled.
+	Subscribe(*SubscribeRequest, MyPaste_SubscribeServer) error
+	mustEmbedUnimplementedMyPasteServer()
+}
+
+// UnimplementedMyPasteServer must be embedded to have forward compatible implementations.
+type UnimplementedMyPasteServer struct {
+}
+
+func (UnimplementedMyPasteServer) AddEvent(context.Context, *AddEventRequest) (*Event, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method AddEvent not implemented")
+}
+func (UnimplementedMyPasteServer) ListEvents(context.Context, *ListEventsRequest) (*ListEventsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListEvents not implemented")
+}
+func (UnimplementedMyPasteServer) DeleteEvents(context.Context, *DeleteEventsRequest) (*DeleteEventsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DeleteEvents not implemented")
+}
+func (UnimplementedMyPasteServer) ResetStream(context.Context, *ResetStreamRequest) (*ResetStreamResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ResetStream not implemented")
+}
+func (UnimplementedMyPasteServer) ListDevices(context.Context, *ListDevicesRequest) (*ListDevicesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListDevices not implemented")
+}
+func (UnimplementedMyPasteServer) Subscribe(*SubscribeRequest, MyPaste_SubscribeServer) error {
+	return status.Errorf(codes.Unimplemented, "method Subscribe not implemented")
+}
+func (UnimplementedMyPasteServer) mustEmbedUnimplementedMyPasteServer() {}
+
+// UnsafeMyPasteServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to MyPasteServer will
+// result in compilation errors.
+type UnsafeMyPasteServer interface {
+	mustEmbedUnimplementedMyPasteServer()
+}
+
+func RegisterMyPasteServer(s grpc.ServiceRegistrar, srv MyPasteServer) {
+	s.RegisterService(&MyPaste_ServiceDesc, srv)
+}
+
+func _MyPaste_AddEvent_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AddEventRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MyPasteServer).AddEvent(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: MyPaste_AddEvent_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MyPasteServer).AddEvent(ctx, req.(*AddEventRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MyPaste_ListEvents_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListEventsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MyPasteServer).ListEvents(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: MyPaste_ListEvents_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MyPasteServer).ListEvents(ctx, req.(*ListEventsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MyPaste_DeleteEvents_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeleteEventsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MyPasteServer).DeleteEvents(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: MyPaste_DeleteEvents_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MyPasteServer).DeleteEvents(ctx, req.(*DeleteEventsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MyPaste_ResetStream_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ResetStreamRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MyPasteServer).ResetStream(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: MyPaste_ResetStream_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MyPasteServer).ResetStream(ctx, req.(*ResetStreamRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MyPaste_ListDevices_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListDevicesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MyPasteServer).ListDevices(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: MyPaste_ListDevices_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MyPasteServer).ListDevices(ctx, req.(*ListDevicesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MyPaste_Subscribe_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(SubscribeRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(MyPasteServer).Subscribe(m, &myPasteSubscribeServer{stream})
+}
+
+type MyPaste_SubscribeServer interface {
+	Send(*Event) error
+	grpc.ServerStream
+}
+
+type myPasteSubscribeServer struct {
+	grpc.ServerStream
+}
+
+func (x *myPasteSubscribeServer) Send(m *Event) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+// MyPaste_ServiceDesc is the grpc.ServiceDesc for MyPaste service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var MyPaste_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "mypaste.MyPaste",
+	HandlerType: (*MyPasteServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "AddEvent",
+			Handler:    _MyPaste_AddEvent_Handler,
+		},
+		{
+			MethodName: "ListEvents",
+			Handler:    _MyPaste_ListEvents_Handler,
+		},
+		{
+			MethodName: "DeleteEvents",
+			Handler:    _MyPaste_DeleteEvents_Handler,
+		},
+		{
+			MethodName: "ResetStream",
+			Handler:    _MyPaste_ResetStream_Handler,
+		},
+		{
+			MethodName: "ListDevices",
+			Handler:    _MyPaste_ListDevices_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Subscribe",
+			Handler:       _MyPaste_Subscribe_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "mypaste.proto",
+}
@@ -0,0 +1,204 @@
+package mypaste
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/labstack/echo/v4"
+	"golang.org/x/oauth2"
+)
+
+const (
+	oauthFlowCookieName = "my_paste_oauth_flow"
+	oauthFlowTTL        = 10 * time.Minute
+)
+
+type OIDCConfig struct {
+	// IssuerUrl is used for discovery at IssuerUrl/.well-known/openid-configuration.
+	IssuerUrl    string
+	ClientId     string
+	ClientSecret string
+	RedirectUri  string
+	Scopes       []string
+	// EmailVerifiedOptional accepts id tokens without the email_verified claim,
+	// only for providers that never return unverified emails.
+	EmailVerifiedOptional bool
+}
+
+// OIDCProvider signs in with any OpenID Connect provider, eg. Keycloak, Dex or Authentik,
+// using the authorization code flow with PKCE.
+type OIDCProvider struct {
+	oauth2                oauth2.Config
+	verifier              *oidc.IDTokenVerifier
+	emailVerifiedOptional bool
+}
+
+func NewOIDCProvider(ctx context.Context, config OIDCConfig) (*OIDCProvider, error) {
+	provider, err := oidc.NewProvider(ctx, config.IssuerUrl)
+	if err != nil {
+		return nil, fmt.Errorf("failed to discover oidc provider, %w", err)
+	}
+	scopes := config.Scopes
+	if len(scopes) == 0 {
+		scopes = []string{oidc.ScopeOpenID, "email", "profile"}
+	}
+	return &OIDCProvider{
+		oauth2: oauth2.Config{
+			ClientID:     config.ClientId,
+			ClientSecret: config.ClientSecret,
+			RedirectURL:  config.RedirectUri,
+			Endpoint:     provider.Endpoint(),
+			Scopes:       scopes,
+		},
+		verifier:              provider.Verifier(&oidc.Config{ClientID: config.ClientId}),
+		emailVerifiedOptional: config.EmailVerifiedOptional,
+	}, nil
+}
+
+// oauthFlowClaims keeps the state of a login between the redirect to the provider and the callback
+// in a short lived signed cookie.
+type oauthFlowClaims struct {
+	State    string
+	Nonce    string
+	Verifier string
+	jwt.RegisteredClaims
+}
+
+func OIDCLoginHandler(provider *OIDCProvider, jwtSignKey string) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		flow, err := startOAuthFlow(c, jwtSignKey)
+		if err != nil {
+			return handleLoginError(c, err)
+		}
+		authUrl := provider.oauth2.AuthCodeURL(flow.State, oidc.Nonce(flow.Nonce), oauth2.S256ChallengeOption(flow.Verifier))
+		return c.Redirect(http.StatusFound, authUrl)
+	}
+}
+
+func OIDCCallbackHandler(provider *OIDCProvider, sessions SessionStore, jwtSignKey string) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		user, err := validateOIDCCallback(c, provider, jwtSignKey)
+		if err != nil {
+			return handleLoginError(c, err)
+		}
+		return loginSuccess(c, sessions, *user, jwtSignKey)
+	}
+}
+
+func validateOIDCCallback(c echo.Context, provider *OIDCProvider, jwtSignKey string) (*User, error) {
+	flow, err := finishOAuthFlow(c, jwtSignKey)
+	if err != nil {
+		return nil, err
+	}
+	ctx := c.Request().Context()
+	token, err := provider.oauth2.Exchange(ctx, c.QueryParam("code"), oauth2.VerifierOption(flow.Verifier))
+	if err != nil {
+		return nil, fmt.Errorf("failed to exchange code, %w", err)
+	}
+	rawIdToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return nil, fmt.Errorf("missing id_token in token response")
+	}
+	idToken, err := provider.verifier.Verify(ctx, rawIdToken)
+	if err != nil {
+		return nil, fmt.Errorf("failed to verify id_token, %w", err)
+	}
+	if idToken.Nonce != flow.Nonce {
+		return nil, fmt.Errorf("invalid id_token nonce")
+	}
+	var claims struct {
+		Name              string `json:"name"`
+		PreferredUsername string `json:"preferred_username"`
+		Email             string `json:"email"`
+		EmailVerified     *bool  `json:"email_verified"`
+	}
+	if err := idToken.Claims(&claims); err != nil {
+		return nil, fmt.Errorf("invalid id_token claims, %w", err)
+	}
+	if claims.Email == "" {
+		return nil, fmt.Errorf("missing id_token email claim")
+	}
+	// the email is the stream, it must belong to the user
+	if claims.EmailVerified == nil && !provider.emailVerifiedOptional {
+		return nil, fmt.Errorf("missing id_token email_verified claim")
+	}
+	if claims.EmailVerified != nil && !*claims.EmailVerified {
+		return nil, fmt.Errorf("email is not verified: %v", claims.Email)
+	}
+	name := claims.Name
+	if name == "" {
+		name = claims.PreferredUsername
+	}
+	if name == "" {
+		name = claims.Email
+	}
+	return &User{name, claims.Email}, nil
+}
+
+func startOAuthFlow(c echo.Context, jwtSignKey string) (*oauthFlowClaims, error) {
+	state, err := randomString()
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := randomString()
+	if err != nil {
+		return nil, err
+	}
+	flow := &oauthFlowClaims{
+		State:            state,
+		Nonce:            nonce,
+		Verifier:         oauth2.GenerateVerifier(),
+		RegisteredClaims: jwt.RegisteredClaims{ExpiresAt: jwt.NewNumericDate(time.Now().Add(oauthFlowTTL))},
+	}
+	signed, err := jwt.NewWithClaims(jwt.SigningMethodHS256, flow).SignedString([]byte(jwtSignKey))
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign oauth flow, %w", err)
+	}
+	c.SetCookie(&http.Cookie{
+		Name:     oauthFlowCookieName,
+		Value:    signed,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   true,
+		// the callback is a cross site redirect from the provider
+		SameSite: http.SameSiteLaxMode,
+		Expires:  time.Now().Add(oauthFlowTTL),
+	})
+	return flow, nil
+}
+
+func finishOAuthFlow(c echo.Context, jwtSignKey string) (*oauthFlowClaims, error) {
+	if errCode := c.QueryParam("error"); errCode != "" {
+		return nil, fmt.Errorf("provider returned error: %v, %v", errCode, c.QueryParam("error_description"))
+	}
+	cookie, err := c.Cookie(oauthFlowCookieName)
+	if err != nil {
+		return nil, err
+	}
+	c.SetCookie(&http.Cookie{Name: oauthFlowCookieName, Path: "/", Expires: time.Now().Add(-time.Hour)})
+	flow := new(oauthFlowClaims)
+	_, err = jwt.ParseWithClaims(cookie.Value, flow, func(token *jwt.Token) (interface{}, error) {
+		return []byte(jwtSignKey), nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
+	if err != nil {
+		return nil, fmt.Errorf("invalid oauth flow cookie, %w", err)
+	}
+	if state := c.QueryParam("state"); state == "" || state != flow.State {
+		return nil, fmt.Errorf("invalid oauth state: %v", state)
+	}
+	return flow, nil
+}
+
+func randomString() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
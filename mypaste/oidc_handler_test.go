@@ -0,0 +1,275 @@
+package mypaste
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOIDCLogin(t *testing.T) {
+	const jwtSignKey = "secret"
+	svc := NewMemoryStreamService(StreamConfig{})
+
+	t.Run("ok", func(t *testing.T) {
+		idp := newFakeOIDCProvider(t, map[string]any{"name": "name", "email": "email", "email_verified": true})
+		provider := newTestOIDCProvider(t, idp.server.URL)
+
+		rec := oidcLoginFlowT(t, provider, svc, jwtSignKey, nil)
+
+		assert.Equal(t, http.StatusSeeOther, rec.Code)
+		assert.Equal(t, "/", rec.Header().Get("Location"))
+		assertResponseTokenCookie(t, rec.Result(), svc, jwtSignKey)
+		token, err := jwt.ParseWithClaims(getResponseTokenCookie(rec.Result()).Value, new(TokenClaims),
+			func(token *jwt.Token) (interface{}, error) { return []byte(jwtSignKey), nil })
+		require.NoError(t, err)
+		assert.Equal(t, User{"name", "email"}, token.Claims.(*TokenClaims).User)
+	})
+
+	t.Run("fail if email not verified", func(t *testing.T) {
+		idp := newFakeOIDCProvider(t, map[string]any{"name": "name", "email": "email", "email_verified": false})
+		provider := newTestOIDCProvider(t, idp.server.URL)
+
+		rec := oidcLoginFlowT(t, provider, svc, jwtSignKey, nil)
+
+		assertLoginFailedT(t, rec)
+	})
+
+	t.Run("fail if email verified is missing", func(t *testing.T) {
+		idp := newFakeOIDCProvider(t, map[string]any{"name": "name", "email": "email"})
+		provider := newTestOIDCProvider(t, idp.server.URL)
+
+		rec := oidcLoginFlowT(t, provider, svc, jwtSignKey, nil)
+
+		assertLoginFailedT(t, rec)
+	})
+
+	t.Run("ok if email verified is missing and optional", func(t *testing.T) {
+		idp := newFakeOIDCProvider(t, map[string]any{"name": "name", "email": "email"})
+		provider := newTestOIDCProvider(t, idp.server.URL)
+		provider.emailVerifiedOptional = true
+
+		rec := oidcLoginFlowT(t, provider, svc, jwtSignKey, nil)
+
+		assert.Equal(t, http.StatusSeeOther, rec.Code)
+		assertResponseTokenCookie(t, rec.Result(), svc, jwtSignKey)
+	})
+
+	t.Run("fail if invalid state", func(t *testing.T) {
+		idp := newFakeOIDCProvider(t, map[string]any{"name": "name", "email": "email", "email_verified": true})
+		provider := newTestOIDCProvider(t, idp.server.URL)
+
+		rec := oidcLoginFlowT(t, provider, svc, jwtSignKey, func(query url.Values) {
+			query.Set("state", "other")
+		})
+
+		assertLoginFailedT(t, rec)
+	})
+
+	t.Run("fail if invalid code verifier", func(t *testing.T) {
+		idp := newFakeOIDCProvider(t, map[string]any{"name": "name", "email": "email", "email_verified": true})
+		provider := newTestOIDCProvider(t, idp.server.URL)
+		idp.challenge = func(challenge string) string { return challenge + "x" }
+
+		rec := oidcLoginFlowT(t, provider, svc, jwtSignKey, nil)
+
+		assertLoginFailedT(t, rec)
+	})
+
+	t.Run("fail if signed by unknown key", func(t *testing.T) {
+		idp := newFakeOIDCProvider(t, map[string]any{"name": "name", "email": "email", "email_verified": true})
+		provider := newTestOIDCProvider(t, idp.server.URL)
+		otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+		require.NoError(t, err)
+		idp.signKey = otherKey
+
+		rec := oidcLoginFlowT(t, provider, svc, jwtSignKey, nil)
+
+		assertLoginFailedT(t, rec)
+	})
+}
+
+func newTestOIDCProvider(t *testing.T, issuerUrl string) *OIDCProvider {
+	provider, err := NewOIDCProvider(context.Background(), OIDCConfig{
+		IssuerUrl:    issuerUrl,
+		ClientId:     "client",
+		ClientSecret: "client-secret",
+		RedirectUri:  "https://mypaste.test/login/oidc/callback",
+	})
+	require.NoError(t, err)
+	return provider
+}
+
+// oidcLoginFlowT follows the redirects of a browser from the login handler through the provider
+// to the callback handler, modifyCallback changes the callback query before it is sent.
+func oidcLoginFlowT(t *testing.T, provider *OIDCProvider, svc Store, jwtSignKey string, modifyCallback func(url.Values)) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/login/oidc", nil)
+	rec := httptest.NewRecorder()
+	err := OIDCLoginHandler(provider, jwtSignKey)(echo.New().NewContext(req, rec))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusFound, rec.Code)
+
+	client := &http.Client{CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	resp, err := client.Get(rec.Header().Get("Location"))
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusFound, resp.StatusCode)
+	callbackUrl, err := url.Parse(resp.Header.Get("Location"))
+	require.NoError(t, err)
+	query := callbackUrl.Query()
+	if modifyCallback != nil {
+		modifyCallback(query)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, callbackUrl.Path+"?"+query.Encode(), nil)
+	for _, cookie := range rec.Result().Cookies() {
+		req.AddCookie(cookie)
+	}
+	rec = httptest.NewRecorder()
+	err = OIDCCallbackHandler(provider, svc, jwtSignKey)(echo.New().NewContext(req, rec))
+	require.NoError(t, err)
+	return rec
+}
+
+func assertLoginFailedT(t *testing.T, rec *httptest.ResponseRecorder) {
+	assert.Equal(t, http.StatusSeeOther, rec.Code)
+	assert.Equal(t, "/login-failed", rec.Header().Get("Location"))
+	assert.Nil(t, getResponseTokenCookie(rec.Result()), "should not return token cookie")
+}
+
+// fakeOIDCProvider is a local stand-in identity provider serving discovery, authorization,
+// token and jwks endpoints, it signs id tokens for the given claims.
+type fakeOIDCProvider struct {
+	server    *httptest.Server
+	claims    map[string]any
+	key       *rsa.PrivateKey
+	signKey   *rsa.PrivateKey
+	challenge func(string) string
+
+	mtx   sync.Mutex
+	codes map[string]fakeOIDCCode
+}
+
+type fakeOIDCCode struct {
+	challenge string
+	nonce     string
+}
+
+func newFakeOIDCProvider(t *testing.T, claims map[string]any) *fakeOIDCProvider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	p := &fakeOIDCProvider{
+		claims:    claims,
+		key:       key,
+		signKey:   key,
+		challenge: func(challenge string) string { return challenge },
+		codes:     make(map[string]fakeOIDCCode),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", p.discovery)
+	mux.HandleFunc("/authorize", p.authorize)
+	mux.HandleFunc("/token", p.token)
+	mux.HandleFunc("/jwks", p.jwks)
+	p.server = httptest.NewServer(mux)
+	t.Cleanup(p.server.Close)
+	return p
+}
+
+func (p *fakeOIDCProvider) discovery(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(map[string]any{
+		"issuer":                                p.server.URL,
+		"authorization_endpoint":                p.server.URL + "/authorize",
+		"token_endpoint":                        p.server.URL + "/token",
+		"jwks_uri":                              p.server.URL + "/jwks",
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+	})
+}
+
+func (p *fakeOIDCProvider) authorize(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if query.Get("response_type") != "code" || query.Get("client_id") != "client" ||
+		query.Get("code_challenge_method") != "S256" {
+		http.Error(w, "invalid authorization request", http.StatusBadRequest)
+		return
+	}
+	code, _ := randomString()
+	p.mtx.Lock()
+	p.codes[code] = fakeOIDCCode{challenge: p.challenge(query.Get("code_challenge")), nonce: query.Get("nonce")}
+	p.mtx.Unlock()
+
+	redirect, _ := url.Parse(query.Get("redirect_uri"))
+	redirect.RawQuery = url.Values{"code": {code}, "state": {query.Get("state")}}.Encode()
+	http.Redirect(w, r, redirect.String(), http.StatusFound)
+}
+
+func (p *fakeOIDCProvider) token(w http.ResponseWriter, r *http.Request) {
+	clientId, clientSecret, _ := r.BasicAuth()
+	if clientId == "" {
+		clientId, clientSecret = r.PostFormValue("client_id"), r.PostFormValue("client_secret")
+	}
+	if clientId != "client" || clientSecret != "client-secret" {
+		http.Error(w, `{"error":"invalid_client"}`, http.StatusUnauthorized)
+		return
+	}
+	p.mtx.Lock()
+	code, ok := p.codes[r.PostFormValue("code")]
+	delete(p.codes, r.PostFormValue("code"))
+	p.mtx.Unlock()
+	verifierHash := sha256.Sum256([]byte(r.PostFormValue("code_verifier")))
+	if !ok || base64.RawURLEncoding.EncodeToString(verifierHash[:]) != code.challenge {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error":"invalid_grant"}`))
+		return
+	}
+	claims := jwt.MapClaims{
+		"iss":   p.server.URL,
+		"sub":   "subject",
+		"aud":   "client",
+		"exp":   time.Now().Add(time.Hour).Unix(),
+		"iat":   time.Now().Unix(),
+		"nonce": code.nonce,
+	}
+	for k, v := range p.claims {
+		claims[k] = v
+	}
+	idToken := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	idToken.Header["kid"] = "key"
+	signed, _ := idToken.SignedString(p.signKey)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"access_token": "access-token",
+		"token_type":   "Bearer",
+		"expires_in":   3600,
+		"id_token":     signed,
+	})
+}
+
+func (p *fakeOIDCProvider) jwks(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(map[string]any{
+		"keys": []map[string]any{{
+			"kty": "RSA",
+			"kid": "key",
+			"use": "sig",
+			"alg": "RS256",
+			"n":   base64.RawURLEncoding.EncodeToString(p.key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(p.key.E)).Bytes()),
+		}},
+	})
+}
@@ -0,0 +1,778 @@
+package mypaste
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const postgresEventsChannel = "mypaste_events"
+
+// postgresMigrations are applied in order at startup, append new migrations to the end.
+var postgresMigrations = []string{
+	`CREATE TABLE mypaste_streams (
+		stream   TEXT PRIMARY KEY,
+		last_ms  BIGINT NOT NULL,
+		last_seq BIGINT NOT NULL
+	);
+	CREATE TABLE mypaste_events (
+		stream TEXT NOT NULL,
+		ms     BIGINT NOT NULL,
+		seq    BIGINT NOT NULL,
+		json   TEXT NOT NULL,
+		PRIMARY KEY (stream, ms, seq)
+	);
+	CREATE TABLE mypaste_devices (
+		stream      TEXT NOT NULL,
+		id          TEXT NOT NULL,
+		description TEXT NOT NULL,
+		PRIMARY KEY (stream, id)
+	);`,
+	`ALTER TABLE mypaste_events ADD COLUMN version INTEGER NOT NULL DEFAULT 1;`,
+	`CREATE TABLE mypaste_idempotency_keys (
+		stream     TEXT NOT NULL,
+		key        TEXT NOT NULL,
+		event_id   TEXT NOT NULL,
+		expires_at TIMESTAMPTZ NOT NULL,
+		PRIMARY KEY (stream, key)
+	);`,
+	`CREATE TABLE mypaste_api_tokens (
+		hash       TEXT PRIMARY KEY,
+		stream     TEXT NOT NULL,
+		id         TEXT NOT NULL,
+		json       TEXT NOT NULL,
+		created_at BIGINT NOT NULL,
+		UNIQUE (stream, id)
+	);`,
+	`CREATE TABLE mypaste_sessions (
+		stream     TEXT NOT NULL,
+		id         TEXT NOT NULL,
+		json       TEXT NOT NULL,
+		expires_at BIGINT NOT NULL,
+		PRIMARY KEY (stream, id)
+	);`,
+	`ALTER TABLE mypaste_streams ADD COLUMN deleted_ms BIGINT NOT NULL DEFAULT 0,
+		ADD COLUMN deleted_seq BIGINT NOT NULL DEFAULT 0;`,
+	`ALTER TABLE mypaste_idempotency_keys ADD COLUMN request_hash TEXT NOT NULL DEFAULT '';`,
+}
+
+type postgresStreamService struct {
+	pool     *pgxpool.Pool
+	config   StreamConfig
+	notifier *streamNotifier
+	cancel   context.CancelFunc
+	stopped  chan struct{}
+}
+
+var _ Store = (*postgresStreamService)(nil)
+
+// NewPostgresStreamService applies pending schema migrations and keeps listening
+// for new events on a dedicated connection until ctx is done or Close is called, Close also closes pool.
+func NewPostgresStreamService(ctx context.Context, pool *pgxpool.Pool, config StreamConfig) (Store, error) {
+	if err := migratePostgres(ctx, pool); err != nil {
+		return nil, fmt.Errorf("failed to migrate postgres schema, %w", err)
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	s := &postgresStreamService{
+		pool:     pool,
+		config:   config,
+		notifier: newStreamNotifier(),
+		cancel:   cancel,
+		stopped:  make(chan struct{}),
+	}
+	go func() {
+		defer close(s.stopped)
+		s.listen(ctx)
+	}()
+	return s, nil
+}
+
+func (s *postgresStreamService) Close() error {
+	s.cancel()
+	<-s.stopped
+	s.pool.Close()
+	return nil
+}
+
+func migratePostgres(ctx context.Context, pool *pgxpool.Pool) error {
+	return pgx.BeginFunc(ctx, pool, func(tx pgx.Tx) error {
+		if _, err := tx.Exec(ctx, `SELECT pg_advisory_xact_lock(hashtext('mypaste_schema_migrations'))`); err != nil {
+			return err
+		}
+		_, err := tx.Exec(ctx, `CREATE TABLE IF NOT EXISTS mypaste_schema_migrations (
+			version    INTEGER PRIMARY KEY,
+			applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+		)`)
+		if err != nil {
+			return err
+		}
+		var version int
+		err = tx.QueryRow(ctx, `SELECT COALESCE(MAX(version), 0) FROM mypaste_schema_migrations`).Scan(&version)
+		if err != nil {
+			return err
+		}
+		for ; version < len(postgresMigrations); version++ {
+			if _, err := tx.Exec(ctx, postgresMigrations[version]); err != nil {
+				return fmt.Errorf("migration %v, %w", version+1, err)
+			}
+			_, err := tx.Exec(ctx, `INSERT INTO mypaste_schema_migrations (version) VALUES ($1)`, version+1)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *postgresStreamService) listen(ctx context.Context) {
+	for ctx.Err() == nil {
+		err := s.waitForNotifications(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		fmt.Printf("postgres listener error: %v\n", err)
+		// notifications may have been missed while disconnected
+		s.notifier.notifyAll()
+		select {
+		case <-ctx.Done():
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+func (s *postgresStreamService) waitForNotifications(ctx context.Context) error {
+	conn, err := s.pool.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Release()
+
+	if _, err := conn.Exec(ctx, "LISTEN "+postgresEventsChannel); err != nil {
+		return err
+	}
+	defer conn.Exec(context.Background(), "UNLISTEN "+postgresEventsChannel)
+	for {
+		notification, err := conn.Conn().WaitForNotification(ctx)
+		if err != nil {
+			return err
+		}
+		s.notifier.notify(notification.Payload)
+	}
+}
+
+func (s *postgresStreamService) Add(ctx context.Context, stream string, event Event) (Event, error) {
+	added, err := s.AddBatch(ctx, stream, []Event{event})
+	if err != nil {
+		return event, err
+	}
+	return added[0], nil
+}
+
+func (s *postgresStreamService) AddBatch(ctx context.Context, stream string, events []Event) ([]Event, error) {
+	if len(events) == 0 {
+		return []Event{}, nil
+	}
+	devices, err := batchDevices(stream, events)
+	if err != nil {
+		return nil, err
+	}
+	added := make([]Event, len(events))
+	err = pgx.BeginFunc(ctx, s.pool, func(tx pgx.Tx) error {
+		for _, device := range devices {
+			if err := s.addDevice(ctx, tx, stream, device.Device, device.first); err != nil {
+				return err
+			}
+		}
+		var lastId streamId
+		err := tx.QueryRow(ctx, `INSERT INTO mypaste_streams (stream, last_ms, last_seq) VALUES ($1, 0, 0)
+			ON CONFLICT (stream) DO UPDATE SET stream = excluded.stream
+			RETURNING last_ms, last_seq`, stream).Scan(&lastId.Ms, &lastId.Seq)
+		if err != nil {
+			return err
+		}
+		for i, event := range events {
+			event.Timestamp = time.Now().Unix()
+			lastId = nextStreamId(lastId, time.Now())
+			event.Id = lastId.String()
+			_, err = tx.Exec(ctx, `INSERT INTO mypaste_events (stream, ms, seq, version, json) VALUES ($1, $2, $3, $4, $5)`,
+				stream, lastId.Ms, lastId.Seq, eventEncodingVersion, encodeEvent(event))
+			if err != nil {
+				return err
+			}
+			added[i] = event
+		}
+		_, err = tx.Exec(ctx, `UPDATE mypaste_streams SET last_ms = $2, last_seq = $3 WHERE stream = $1`,
+			stream, lastId.Ms, lastId.Seq)
+		if err != nil {
+			return err
+		}
+		if err := s.trim(ctx, tx, stream); err != nil {
+			return err
+		}
+		_, err = tx.Exec(ctx, `SELECT pg_notify($1, $2)`, postgresEventsChannel, stream)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return added, nil
+}
+
+func (s *postgresStreamService) trim(ctx context.Context, tx pgx.Tx, stream string) error {
+	if s.config.MaxLen <= 0 {
+		return nil
+	}
+	_, err := s.deleteEvents(ctx, tx, stream, true, `(ms, seq) < (
+			SELECT ms, seq FROM mypaste_events WHERE stream = $1
+			ORDER BY ms DESC, seq DESC OFFSET $2 - 1 LIMIT 1
+		)`, s.config.MaxLen)
+	return err
+}
+
+// deleteEvents deletes the events of the stream matching where, whose parameters start at $2,
+// the greatest deleted id is recorded if record is set.
+func (s *postgresStreamService) deleteEvents(ctx context.Context, tx pgx.Tx, stream string, record bool, where string, args ...interface{}) (int64, error) {
+	args = append([]interface{}{stream}, args...)
+	if !record {
+		tag, err := tx.Exec(ctx, `DELETE FROM mypaste_events WHERE stream = $1 AND `+where, args...)
+		return tag.RowsAffected(), err
+	}
+	var count int64
+	err := tx.QueryRow(ctx, `WITH deleted AS (
+			DELETE FROM mypaste_events WHERE stream = $1 AND `+where+` RETURNING ms, seq
+		), last AS (
+			SELECT ms, seq FROM deleted ORDER BY ms DESC, seq DESC LIMIT 1
+		), updated AS (
+			UPDATE mypaste_streams SET deleted_ms = last.ms, deleted_seq = last.seq FROM last
+			WHERE stream = $1 AND (deleted_ms, deleted_seq) < (last.ms, last.seq)
+		)
+		SELECT COUNT(*) FROM deleted`, args...).Scan(&count)
+	return count, err
+}
+
+func (s *postgresStreamService) Read(ctx context.Context, stream, lastId string) ([]Event, error) {
+	if lastId == "" {
+		lastId = "0"
+	}
+	after, err := parseStreamId(lastId)
+	if err != nil {
+		return nil, err
+	}
+	return readBlocking(ctx, readBlockOf(ctx, s.config.ReadBlock), func() ([]Event, <-chan struct{}, error) {
+		updated := s.notifier.wait(stream)
+		var events []Event
+		events, after, err = s.readAfter(ctx, stream, after)
+		return events, updated, err
+	})
+}
+
+// readAfter returns the events after the given id and the id to read after next time,
+// pages having only corrupt events are skipped so they cannot hold back the events after them.
+func (s *postgresStreamService) readAfter(ctx context.Context, stream string, after streamId) ([]Event, streamId, error) {
+	var limit any
+	if s.config.ReadCount > 0 {
+		limit = s.config.ReadCount
+	}
+	for {
+		rows, err := s.pool.Query(ctx, `SELECT ms, seq, version, json FROM mypaste_events
+			WHERE stream = $1 AND (ms, seq) > ($2, $3)
+			ORDER BY ms, seq LIMIT $4`, stream, after.Ms, after.Seq, limit)
+		if err != nil {
+			return nil, after, err
+		}
+		events, lastId, err := s.scanPage(stream, rows)
+		if err != nil || len(events) > 0 || lastId == (streamId{}) {
+			return events, after, err
+		}
+		after = lastId
+	}
+}
+
+func (s *postgresStreamService) scanEvents(stream string, rows pgx.Rows) ([]Event, error) {
+	events, _, err := s.scanPage(stream, rows)
+	return events, err
+}
+
+// scanPage also returns the id of the last row scanned, including corrupt events.
+func (s *postgresStreamService) scanPage(stream string, rows pgx.Rows) ([]Event, streamId, error) {
+	defer rows.Close()
+
+	events := make([]Event, 0)
+	var id streamId
+	for rows.Next() {
+		var version int
+		var jsonValue string
+		if err := rows.Scan(&id.Ms, &id.Seq, &version, &jsonValue); err != nil {
+			return nil, id, err
+		}
+		if event, ok := decodeStoredEvent(stream, id.String(), version, jsonValue); ok {
+			events = append(events, event)
+		}
+	}
+	return events, id, rows.Err()
+}
+
+func (s *postgresStreamService) Get(ctx context.Context, stream, id string) (Event, error) {
+	parsed, err := parseStreamId(id)
+	if err != nil {
+		return Event{}, err
+	}
+	rows, err := s.pool.Query(ctx, `SELECT ms, seq, version, json FROM mypaste_events
+		WHERE stream = $1 AND ms = $2 AND seq = $3`, stream, parsed.Ms, parsed.Seq)
+	if err != nil {
+		return Event{}, err
+	}
+	events, err := s.scanEvents(stream, rows)
+	if err != nil {
+		return Event{}, err
+	}
+	if len(events) == 0 {
+		return Event{}, ErrEventNotFound
+	}
+	return events[0], nil
+}
+
+func (s *postgresStreamService) Delete(ctx context.Context, stream string, ids ...string) (int64, error) {
+	return s.delete(ctx, stream, false, ids)
+}
+
+func (s *postgresStreamService) Expire(ctx context.Context, stream string, ids ...string) (int64, error) {
+	return s.delete(ctx, stream, true, ids)
+}
+
+func (s *postgresStreamService) delete(ctx context.Context, stream string, record bool, ids []string) (int64, error) {
+	var count int64
+	err := pgx.BeginFunc(ctx, s.pool, func(tx pgx.Tx) error {
+		for _, id := range ids {
+			parsed, err := parseStreamId(id)
+			if err != nil {
+				return err
+			}
+			deleted, err := s.deleteEvents(ctx, tx, stream, record, `ms = $2 AND seq = $3`, parsed.Ms, parsed.Seq)
+			if err != nil {
+				return err
+			}
+			count += deleted
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+func (s *postgresStreamService) Reset(ctx context.Context, stream string) error {
+	return pgx.BeginFunc(ctx, s.pool, func(tx pgx.Tx) error {
+		if _, err := tx.Exec(ctx, `DELETE FROM mypaste_events WHERE stream = $1`, stream); err != nil {
+			return err
+		}
+		_, err := tx.Exec(ctx, `DELETE FROM mypaste_streams WHERE stream = $1`, stream)
+		return err
+	})
+}
+
+func (s *postgresStreamService) Len(ctx context.Context, stream string) (int64, error) {
+	var count int64
+	err := s.pool.QueryRow(ctx, `SELECT COUNT(*) FROM mypaste_events WHERE stream = $1`, stream).Scan(&count)
+	return count, err
+}
+
+func (s *postgresStreamService) Range(ctx context.Context, stream, start, end string, count int64) ([]Event, error) {
+	startId, err := parseRangeStart(start)
+	if err != nil {
+		return nil, err
+	}
+	endId, err := parseRangeEnd(end)
+	if err != nil {
+		return nil, err
+	}
+	var limit any
+	if count > 0 {
+		limit = count
+	}
+	rows, err := s.pool.Query(ctx, `SELECT ms, seq, version, json FROM mypaste_events
+		WHERE stream = $1 AND (ms, seq) >= ($2, $3) AND (ms, seq) <= ($4, $5)
+		ORDER BY ms, seq LIMIT $6`, stream, startId.Ms, startId.Seq, endId.Ms, endId.Seq, limit)
+	if err != nil {
+		return nil, err
+	}
+	return s.scanEvents(stream, rows)
+}
+
+func (s *postgresStreamService) RevRange(ctx context.Context, stream, end, start string, count int64) ([]Event, error) {
+	startId, err := parseRangeStart(start)
+	if err != nil {
+		return nil, err
+	}
+	endId, err := parseRangeEnd(end)
+	if err != nil {
+		return nil, err
+	}
+	var limit any
+	if count > 0 {
+		limit = count
+	}
+	rows, err := s.pool.Query(ctx, `SELECT ms, seq, version, json FROM mypaste_events
+		WHERE stream = $1 AND (ms, seq) >= ($2, $3) AND (ms, seq) <= ($4, $5)
+		ORDER BY ms DESC, seq DESC LIMIT $6`, stream, startId.Ms, startId.Seq, endId.Ms, endId.Seq, limit)
+	if err != nil {
+		return nil, err
+	}
+	return s.scanEvents(stream, rows)
+}
+
+func (s *postgresStreamService) Count(ctx context.Context, stream, start, end string) (int64, error) {
+	startId, err := parseRangeStart(start)
+	if err != nil {
+		return 0, err
+	}
+	endId, err := parseRangeEnd(end)
+	if err != nil {
+		return 0, err
+	}
+	var count int64
+	err = s.pool.QueryRow(ctx, `SELECT COUNT(*) FROM mypaste_events
+		WHERE stream = $1 AND (ms, seq) >= ($2, $3) AND (ms, seq) <= ($4, $5)`,
+		stream, startId.Ms, startId.Seq, endId.Ms, endId.Seq).Scan(&count)
+	return count, err
+}
+
+func (s *postgresStreamService) Trim(ctx context.Context, stream, minId string) (int64, error) {
+	min, err := parseStreamId(minId)
+	if err != nil {
+		return 0, err
+	}
+	var count int64
+	err = pgx.BeginFunc(ctx, s.pool, func(tx pgx.Tx) error {
+		count, err = s.deleteEvents(ctx, tx, stream, true, `(ms, seq) < ($2, $3)`, min.Ms, min.Seq)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+func (s *postgresStreamService) MaxDeletedId(ctx context.Context, stream string) (string, error) {
+	var id streamId
+	err := s.pool.QueryRow(ctx, `SELECT deleted_ms, deleted_seq FROM mypaste_streams WHERE stream = $1`, stream).
+		Scan(&id.Ms, &id.Seq)
+	if err == pgx.ErrNoRows {
+		return "", nil
+	}
+	if err != nil || id == (streamId{}) {
+		return "", err
+	}
+	return id.String(), nil
+}
+
+func (s *postgresStreamService) Import(ctx context.Context, stream string, events []Event) (int64, error) {
+	var count int64
+	err := pgx.BeginFunc(ctx, s.pool, func(tx pgx.Tx) error {
+		count = 0
+		var lastId streamId
+		err := tx.QueryRow(ctx, `INSERT INTO mypaste_streams (stream, last_ms, last_seq) VALUES ($1, 0, 0)
+			ON CONFLICT (stream) DO UPDATE SET stream = excluded.stream
+			RETURNING last_ms, last_seq`, stream).Scan(&lastId.Ms, &lastId.Seq)
+		if err != nil {
+			return err
+		}
+		for _, event := range events {
+			id, err := parseStreamId(event.Id)
+			if err != nil {
+				return err
+			}
+			if !lastId.Less(id) {
+				continue
+			}
+			event.Id = id.String()
+			_, err = tx.Exec(ctx, `INSERT INTO mypaste_events (stream, ms, seq, version, json) VALUES ($1, $2, $3, $4, $5)`,
+				stream, id.Ms, id.Seq, eventEncodingVersion, encodeEvent(event))
+			if err != nil {
+				return err
+			}
+			lastId = id
+			count++
+		}
+		if count == 0 {
+			return nil
+		}
+		_, err = tx.Exec(ctx, `UPDATE mypaste_streams SET last_ms = $2, last_seq = $3 WHERE stream = $1`,
+			stream, lastId.Ms, lastId.Seq)
+		if err != nil {
+			return err
+		}
+		if err := s.trim(ctx, tx, stream); err != nil {
+			return err
+		}
+		_, err = tx.Exec(ctx, `SELECT pg_notify($1, $2)`, postgresEventsChannel, stream)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+func (s *postgresStreamService) Streams(ctx context.Context) ([]string, error) {
+	rows, err := s.pool.Query(ctx, `SELECT stream FROM mypaste_events UNION SELECT stream FROM mypaste_devices ORDER BY stream`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	streams := make([]string, 0)
+	for rows.Next() {
+		var stream string
+		if err := rows.Scan(&stream); err != nil {
+			return nil, err
+		}
+		streams = append(streams, stream)
+	}
+	return streams, rows.Err()
+}
+
+func (s *postgresStreamService) ReserveIdempotencyKey(ctx context.Context, stream, key, requestHash string, ttl time.Duration) (IdempotencyKey, bool, error) {
+	record := IdempotencyKey{RequestHash: requestHash}
+	var reserved bool
+	err := pgx.BeginFunc(ctx, s.pool, func(tx pgx.Tx) error {
+		now := time.Now()
+		_, err := tx.Exec(ctx, `DELETE FROM mypaste_idempotency_keys WHERE stream = $1 AND expires_at <= $2`, stream, now)
+		if err != nil {
+			return err
+		}
+		tag, err := tx.Exec(ctx, `INSERT INTO mypaste_idempotency_keys (stream, key, request_hash, event_id, expires_at)
+			VALUES ($1, $2, $3, '', $4) ON CONFLICT (stream, key) DO NOTHING`, stream, key, requestHash, now.Add(ttl))
+		if err != nil {
+			return err
+		}
+		if tag.RowsAffected() > 0 {
+			reserved = true
+			return nil
+		}
+		err = tx.QueryRow(ctx, `SELECT request_hash, event_id FROM mypaste_idempotency_keys WHERE stream = $1 AND key = $2`,
+			stream, key).Scan(&record.RequestHash, &record.EventId)
+		if err == pgx.ErrNoRows {
+			// deleted by a concurrent transaction, the caller retries later
+			record = IdempotencyKey{}
+			return nil
+		}
+		return err
+	})
+	return record, reserved, err
+}
+
+func (s *postgresStreamService) SetIdempotencyKey(ctx context.Context, stream, key string, record IdempotencyKey, ttl time.Duration) error {
+	_, err := s.pool.Exec(ctx, `UPDATE mypaste_idempotency_keys SET request_hash = $3, event_id = $4, expires_at = $5
+		WHERE stream = $1 AND key = $2`, stream, key, record.RequestHash, record.EventId, time.Now().Add(ttl))
+	return err
+}
+
+func (s *postgresStreamService) DeleteIdempotencyKey(ctx context.Context, stream, key string) error {
+	_, err := s.pool.Exec(ctx, `DELETE FROM mypaste_idempotency_keys WHERE stream = $1 AND key = $2`, stream, key)
+	return err
+}
+
+func (s *postgresStreamService) AddApiToken(ctx context.Context, stream string, token ApiToken) error {
+	value, err := json.Marshal(token)
+	if err != nil {
+		return err
+	}
+	_, err = s.pool.Exec(ctx, `INSERT INTO mypaste_api_tokens (hash, stream, id, json, created_at) VALUES ($1, $2, $3, $4, $5)`,
+		token.Hash, stream, token.Id, string(value), token.CreatedAt)
+	return err
+}
+
+func (s *postgresStreamService) GetApiTokens(ctx context.Context, stream string) ([]ApiToken, error) {
+	rows, err := s.pool.Query(ctx, `SELECT hash, json FROM mypaste_api_tokens WHERE stream = $1 ORDER BY created_at, id`, stream)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := make([]ApiToken, 0)
+	for rows.Next() {
+		var hash, value string
+		if err := rows.Scan(&hash, &value); err != nil {
+			return nil, err
+		}
+		token, err := decodeApiToken(hash, value)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, token)
+	}
+	return tokens, rows.Err()
+}
+
+func (s *postgresStreamService) GetApiToken(ctx context.Context, hash string) (ApiToken, error) {
+	var value string
+	err := s.pool.QueryRow(ctx, `SELECT json FROM mypaste_api_tokens WHERE hash = $1`, hash).Scan(&value)
+	if err == pgx.ErrNoRows {
+		return ApiToken{}, ErrApiTokenNotFound
+	}
+	if err != nil {
+		return ApiToken{}, err
+	}
+	return decodeApiToken(hash, value)
+}
+
+func (s *postgresStreamService) DeleteApiToken(ctx context.Context, stream, id string) error {
+	tag, err := s.pool.Exec(ctx, `DELETE FROM mypaste_api_tokens WHERE stream = $1 AND id = $2`, stream, id)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrApiTokenNotFound
+	}
+	return nil
+}
+
+func (s *postgresStreamService) SetSession(ctx context.Context, stream string, session Session) error {
+	value, err := json.Marshal(session)
+	if err != nil {
+		return err
+	}
+	return pgx.BeginFunc(ctx, s.pool, func(tx pgx.Tx) error {
+		_, err := tx.Exec(ctx, `DELETE FROM mypaste_sessions WHERE stream = $1 AND expires_at <= $2`, stream, time.Now().Unix())
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(ctx, `INSERT INTO mypaste_sessions (stream, id, json, expires_at) VALUES ($1, $2, $3, $4)
+			ON CONFLICT (stream, id) DO UPDATE SET json = excluded.json, expires_at = excluded.expires_at`,
+			stream, session.Id, string(value), session.ExpiresAt)
+		return err
+	})
+}
+
+func (s *postgresStreamService) UpdateSession(ctx context.Context, stream string, session Session) error {
+	value, err := json.Marshal(session)
+	if err != nil {
+		return err
+	}
+	tag, err := s.pool.Exec(ctx, `UPDATE mypaste_sessions SET json = $3, expires_at = $4 WHERE stream = $1 AND id = $2`,
+		stream, session.Id, string(value), session.ExpiresAt)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrSessionNotFound
+	}
+	return nil
+}
+
+func (s *postgresStreamService) GetSession(ctx context.Context, stream, id string) (Session, error) {
+	var value string
+	err := s.pool.QueryRow(ctx, `SELECT json FROM mypaste_sessions WHERE stream = $1 AND id = $2 AND expires_at > $3`,
+		stream, id, time.Now().Unix()).Scan(&value)
+	if err == pgx.ErrNoRows {
+		return Session{}, ErrSessionNotFound
+	}
+	if err != nil {
+		return Session{}, err
+	}
+	return decodeSession(value)
+}
+
+func (s *postgresStreamService) GetSessions(ctx context.Context, stream string) ([]Session, error) {
+	rows, err := s.pool.Query(ctx, `SELECT json FROM mypaste_sessions WHERE stream = $1 AND expires_at > $2`,
+		stream, time.Now().Unix())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := make([]Session, 0)
+	for rows.Next() {
+		var value string
+		if err := rows.Scan(&value); err != nil {
+			return nil, err
+		}
+		session, err := decodeSession(value)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, session)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	sortSessions(sessions)
+	return sessions, nil
+}
+
+func (s *postgresStreamService) DeleteSession(ctx context.Context, stream, id string) error {
+	tag, err := s.pool.Exec(ctx, `DELETE FROM mypaste_sessions WHERE stream = $1 AND id = $2`, stream, id)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrSessionNotFound
+	}
+	return nil
+}
+
+func (s *postgresStreamService) DeleteSessions(ctx context.Context, stream string) error {
+	_, err := s.pool.Exec(ctx, `DELETE FROM mypaste_sessions WHERE stream = $1`, stream)
+	return err
+}
+
+func (s *postgresStreamService) AddDevice(ctx context.Context, stream string, device Device) (Device, error) {
+	err := pgx.BeginFunc(ctx, s.pool, func(tx pgx.Tx) error {
+		return s.addDevice(ctx, tx, stream, device, false)
+	})
+	return device, err
+}
+
+func (s *postgresStreamService) AddFirstDevice(ctx context.Context, stream string, device Device) (Device, error) {
+	err := pgx.BeginFunc(ctx, s.pool, func(tx pgx.Tx) error {
+		return s.addDevice(ctx, tx, stream, device, true)
+	})
+	return device, err
+}
+
+// addDevice adds or updates device, a first device fails if the stream already has a device.
+func (s *postgresStreamService) addDevice(ctx context.Context, tx pgx.Tx, stream string, device Device, first bool) error {
+	if first {
+		// serialize first device registration per stream, the devices table has no row to lock yet
+		_, err := tx.Exec(ctx, `SELECT pg_advisory_xact_lock(hashtext('mypaste_devices:' || $1::text))`, stream)
+		if err != nil {
+			return err
+		}
+		var count int
+		err = tx.QueryRow(ctx, `SELECT COUNT(*) FROM mypaste_devices WHERE stream = $1`, stream).Scan(&count)
+		if err != nil {
+			return err
+		}
+		if count > 0 {
+			return errDeviceExists(stream)
+		}
+	}
+	_, err := tx.Exec(ctx, `INSERT INTO mypaste_devices (stream, id, description) VALUES ($1, $2, $3)
+		ON CONFLICT (stream, id) DO UPDATE SET description = excluded.description`,
+		stream, device.Id, device.Description)
+	return err
+}
+
+func (s *postgresStreamService) GetDevices(ctx context.Context, stream string) ([]Device, error) {
+	rows, err := s.pool.Query(ctx, `SELECT id, description FROM mypaste_devices WHERE stream = $1`, stream)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	devices := make([]Device, 0)
+	for rows.Next() {
+		var device Device
+		if err := rows.Scan(&device.Id, &device.Description); err != nil {
+			return nil, err
+		}
+		devices = append(devices, device)
+	}
+	return devices, rows.Err()
+}
@@ -0,0 +1,293 @@
+package mypaste
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+type RetentionPolicy struct {
+	// MaxLen is the number of newest events kept, zero keeps all.
+	MaxLen int64
+	// MaxAge removes events older than it, zero keeps all.
+	MaxAge time.Duration
+	// SensitiveMaxAge removes sensitive events older than it, zero keeps all.
+	SensitiveMaxAge time.Duration
+}
+
+// RetentionConfig is the retention policy of the deployment with overrides for some users.
+type RetentionConfig struct {
+	Default RetentionPolicy
+	Users   map[string]RetentionOverride
+}
+
+// RetentionOverride replaces the fields of the default policy which are set, zero removes a limit.
+type RetentionOverride struct {
+	MaxLen          *int64
+	MaxAge          *time.Duration
+	SensitiveMaxAge *time.Duration
+}
+
+// Policy returns the effective policy of a user.
+func (c RetentionConfig) Policy(user string) RetentionPolicy {
+	policy := c.Default
+	override, ok := c.Users[user]
+	if !ok {
+		return policy
+	}
+	if override.MaxLen != nil {
+		policy.MaxLen = *override.MaxLen
+	}
+	if override.MaxAge != nil {
+		policy.MaxAge = *override.MaxAge
+	}
+	if override.SensitiveMaxAge != nil {
+		policy.SensitiveMaxAge = *override.SensitiveMaxAge
+	}
+	return policy
+}
+
+// hasUserMaxLen reports whether a user has a max len of its own,
+// the stream service cannot trim per user, so the janitor trims every stream to its max len instead.
+func (c RetentionConfig) hasUserMaxLen() bool {
+	for _, override := range c.Users {
+		if override.MaxLen != nil {
+			return true
+		}
+	}
+	return false
+}
+
+func (c RetentionConfig) needsJanitor() bool {
+	if c.Default.MaxAge > 0 || c.Default.SensitiveMaxAge > 0 || c.hasUserMaxLen() {
+		return true
+	}
+	for user := range c.Users {
+		if policy := c.Policy(user); policy.MaxAge > 0 || policy.SensitiveMaxAge > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseRetentionConfig parses the ages of the default policy and the user overrides in json,
+// eg. {"alice@example.com":{"MaxLen":1000,"MaxAge":"0","SensitiveMaxAge":"10m"}}.
+// Fields missing from a user override keep the default, zero removes the limit for the user.
+func ParseRetentionConfig(maxLen int64, maxAge, sensitiveMaxAge, userPolicies string) (RetentionConfig, error) {
+	var err error
+	config := RetentionConfig{Default: RetentionPolicy{MaxLen: maxLen}}
+	if config.Default.MaxAge, err = parseRetentionAge(maxAge); err != nil {
+		return config, err
+	}
+	if config.Default.SensitiveMaxAge, err = parseRetentionAge(sensitiveMaxAge); err != nil {
+		return config, err
+	}
+	if userPolicies == "" {
+		return config, nil
+	}
+	var users map[string]struct {
+		MaxLen                  *int64
+		MaxAge, SensitiveMaxAge *string
+	}
+	if err := json.Unmarshal([]byte(userPolicies), &users); err != nil {
+		return config, fmt.Errorf("failed to parse user retention policies, %w", err)
+	}
+	config.Users = make(map[string]RetentionOverride, len(users))
+	for user, policy := range users {
+		override := RetentionOverride{MaxLen: policy.MaxLen}
+		if override.MaxAge, err = parseRetentionAgeOverride(policy.MaxAge); err != nil {
+			return config, err
+		}
+		if override.SensitiveMaxAge, err = parseRetentionAgeOverride(policy.SensitiveMaxAge); err != nil {
+			return config, err
+		}
+		config.Users[user] = override
+	}
+	return config, nil
+}
+
+func parseRetentionAgeOverride(value *string) (*time.Duration, error) {
+	if value == nil {
+		return nil, nil
+	}
+	age, err := parseRetentionAge(*value)
+	return &age, err
+}
+
+// parseRetentionAge parses a duration which may also be given in days, eg. 30d.
+func parseRetentionAge(value string) (time.Duration, error) {
+	if value == "" {
+		return 0, nil
+	}
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		n, err := strconv.ParseInt(days, 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid retention age: %v", value)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	age, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid retention age: %v", value)
+	}
+	return age, nil
+}
+
+// RetentionJanitor removes the events older than the retention policy of each stream.
+type RetentionJanitor struct {
+	streamService StreamService
+	config        RetentionConfig
+	interval      time.Duration
+	// sensitiveSwept is the cutoff of the last sensitive sweep of each stream,
+	// the next sweep only scans the events added from it.
+	sensitiveSwept map[string]time.Time
+	// fullSweptAt is when sensitiveSwept was last cleared, so events restored with old ids are swept too.
+	fullSweptAt time.Time
+}
+
+const sensitiveFullSweepInterval = 24 * time.Hour
+
+func NewRetentionJanitor(streamService StreamService, config RetentionConfig, interval time.Duration) *RetentionJanitor {
+	return &RetentionJanitor{
+		streamService:  streamService,
+		config:         config,
+		interval:       interval,
+		sensitiveSwept: make(map[string]time.Time),
+	}
+}
+
+// Run enforces the policies every interval until ctx is done.
+func (j *RetentionJanitor) Run(ctx context.Context) {
+	if !j.config.needsJanitor() {
+		return
+	}
+	ticker := time.NewTicker(j.interval)
+	defer ticker.Stop()
+	for {
+		if err := j.RunOnce(ctx, time.Now()); err != nil {
+			fmt.Println("retention janitor error:", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (j *RetentionJanitor) RunOnce(ctx context.Context, now time.Time) error {
+	streams, err := j.streamService.Streams(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list streams, %w", err)
+	}
+	if now.Sub(j.fullSweptAt) >= sensitiveFullSweepInterval {
+		j.sensitiveSwept = make(map[string]time.Time)
+		j.fullSweptAt = now
+	}
+	for _, stream := range streams {
+		count, err := j.enforce(ctx, stream, j.config.Policy(stream), now)
+		if err != nil {
+			return fmt.Errorf("failed to enforce retention of stream %v, %w", stream, err)
+		}
+		if count > 0 {
+			fmt.Printf("retention removed %v events from stream %v\n", count, stream)
+		}
+	}
+	return nil
+}
+
+func (j *RetentionJanitor) enforce(ctx context.Context, stream string, policy RetentionPolicy, now time.Time) (int64, error) {
+	var count int64
+	if policy.MaxLen > 0 {
+		trimmed, err := j.trimMaxLen(ctx, stream, policy.MaxLen)
+		if err != nil {
+			return count, err
+		}
+		count += trimmed
+	}
+	if policy.MaxAge > 0 {
+		minId := streamId{Ms: uint64(now.Add(-policy.MaxAge).UnixMilli())}
+		trimmed, err := j.streamService.Trim(ctx, stream, minId.String())
+		if err != nil {
+			return count, err
+		}
+		count += trimmed
+	}
+	if policy.SensitiveMaxAge > 0 && (policy.MaxAge <= 0 || policy.SensitiveMaxAge < policy.MaxAge) {
+		cutoff := now.Add(-policy.SensitiveMaxAge)
+		deleted, err := j.deleteSensitive(ctx, stream, j.sensitiveSwept[stream], cutoff)
+		count += deleted
+		if err != nil {
+			return count, err
+		}
+		j.sensitiveSwept[stream] = cutoff
+	}
+	return count, nil
+}
+
+// trimMaxLen removes the events before the newest maxLen events.
+func (j *RetentionJanitor) trimMaxLen(ctx context.Context, stream string, maxLen int64) (int64, error) {
+	kept, err := j.streamService.RevRange(ctx, stream, "+", "-", maxLen)
+	if err != nil || int64(len(kept)) < maxLen {
+		return 0, err
+	}
+	return j.streamService.Trim(ctx, stream, kept[len(kept)-1].Id)
+}
+
+// deleteSensitive deletes the sensitive events added from since and before the given time,
+// a zero since scans from the first event.
+func (j *RetentionJanitor) deleteSensitive(ctx context.Context, stream string, since, before time.Time) (int64, error) {
+	end := strconv.FormatInt(before.UnixMilli()-1, 10)
+	start := "-"
+	if !since.IsZero() {
+		start = strconv.FormatInt(since.UnixMilli(), 10)
+	}
+	var count int64
+	for {
+		events, err := j.streamService.Range(ctx, stream, start, end, 500)
+		if err != nil || len(events) == 0 {
+			return count, err
+		}
+		ids := make([]string, 0)
+		for _, event := range events {
+			if event.IsSensitive {
+				ids = append(ids, event.Id)
+			}
+		}
+		if len(ids) > 0 {
+			deleted, err := j.streamService.Expire(ctx, stream, ids...)
+			if err != nil {
+				return count, err
+			}
+			count += deleted
+		}
+		lastId, err := parseStreamId(events[len(events)-1].Id)
+		if err != nil {
+			return count, err
+		}
+		start = lastId.successor().String()
+	}
+}
+
+func GetRetentionPolicyHandler(config RetentionConfig) echo.HandlerFunc {
+	type response struct {
+		MaxLen                 int64
+		MaxAgeSeconds          int64
+		SensitiveMaxAgeSeconds int64
+	}
+	return func(c echo.Context) error {
+		user := GetAuthorizedUser(c)
+		policy := config.Policy(user.Email)
+		return c.JSON(http.StatusOK, response{
+			MaxLen:                 policy.MaxLen,
+			MaxAgeSeconds:          int64(policy.MaxAge / time.Second),
+			SensitiveMaxAgeSeconds: int64(policy.SensitiveMaxAge / time.Second),
+		})
+	}
+}
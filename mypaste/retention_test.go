@@ -0,0 +1,248 @@
+package mypaste
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRetentionConfig(t *testing.T) {
+	config, err := ParseRetentionConfig(100, "30d", "1h", `{
+		"alice@example.com": {"SensitiveMaxAge": "10m"},
+		"bob@example.com": {"MaxAge": "2d", "MaxLen": 1000},
+		"dave@example.com": {"MaxAge": "0", "SensitiveMaxAge": "", "MaxLen": 0}
+	}`)
+	require.NoError(t, err)
+	assert.Equal(t, RetentionPolicy{MaxLen: 100, MaxAge: 30 * 24 * time.Hour, SensitiveMaxAge: time.Hour}, config.Policy("carol@example.com"))
+	assert.Equal(t, RetentionPolicy{MaxLen: 100, MaxAge: 30 * 24 * time.Hour, SensitiveMaxAge: 10 * time.Minute}, config.Policy("alice@example.com"))
+	assert.Equal(t, RetentionPolicy{MaxLen: 1000, MaxAge: 48 * time.Hour, SensitiveMaxAge: time.Hour}, config.Policy("bob@example.com"))
+	assert.Equal(t, RetentionPolicy{}, config.Policy("dave@example.com"), "should remove the limits set to zero")
+	assert.True(t, config.hasUserMaxLen())
+
+	config, err = ParseRetentionConfig(100, "", "", "")
+	require.NoError(t, err)
+	assert.False(t, config.needsJanitor())
+	assert.False(t, config.hasUserMaxLen())
+
+	config, err = ParseRetentionConfig(100, "", "", `{"alice@example.com":{"MaxAge":"1h"}}`)
+	require.NoError(t, err)
+	assert.True(t, config.needsJanitor(), "should run for the age of a user")
+
+	_, err = ParseRetentionConfig(100, "30days", "", "")
+	assert.Error(t, err)
+	_, err = ParseRetentionConfig(100, "", "", `{"alice@example.com":{"MaxAge":"x"}}`)
+	assert.Error(t, err)
+	_, err = ParseRetentionConfig(100, "", "", `not json`)
+	assert.Error(t, err)
+}
+
+func TestRetentionJanitor(t *testing.T) {
+	for name, newTestStreamService := range testStreamServices {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			svc := newTestStreamService(t, StreamConfig{ReadCount: 100, ReadBlock: -1})
+			now := time.Now()
+			at := func(age time.Duration, seq uint64) string {
+				return streamId{Ms: uint64(now.Add(-age).UnixMilli()), Seq: seq}.String()
+			}
+			events := []Event{
+				{Id: at(3*time.Hour, 0), Payload: "old"},
+				{Id: at(2*time.Hour, 0), Payload: "old sensitive", IsSensitive: true},
+				{Id: at(30*time.Minute, 0), Payload: "sensitive", IsSensitive: true},
+				{Id: at(30*time.Minute, 1), Payload: "recent"},
+				{Id: at(time.Minute, 0), Payload: "new sensitive", IsSensitive: true},
+			}
+			_, err := svc.Import(ctx, "alice", events)
+			require.NoError(t, err)
+			_, err = svc.Import(ctx, "bob", events)
+			require.NoError(t, err)
+
+			janitor := NewRetentionJanitor(svc, RetentionConfig{
+				Default: RetentionPolicy{MaxAge: 150 * time.Minute},
+				Users:   map[string]RetentionOverride{"alice": {SensitiveMaxAge: durationPtr(10 * time.Minute)}},
+			}, time.Minute)
+			require.NoError(t, janitor.RunOnce(ctx, now))
+
+			remaining, err := svc.Range(ctx, "alice", "-", "+", 0)
+			require.NoError(t, err)
+			assert.Equal(t, []Event{events[3], events[4]}, remaining)
+			remaining, err = svc.Range(ctx, "bob", "-", "+", 0)
+			require.NoError(t, err)
+			assert.Equal(t, events[1:], remaining)
+		})
+	}
+}
+
+func TestRetentionJanitorUserMaxLen(t *testing.T) {
+	for name, newTestStreamService := range testStreamServices {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			// the store does not trim when users have their own max len
+			svc := newTestStreamService(t, StreamConfig{ReadCount: 100, ReadBlock: -1})
+			now := time.Now()
+			var events []Event
+			for i := 0; i < 5; i++ {
+				events = append(events, Event{Id: streamId{Ms: uint64(now.Add(time.Duration(i-5) * time.Hour).UnixMilli())}.String(), Payload: "hello"})
+			}
+			for _, stream := range []string{"alice", "bob", "carol"} {
+				_, err := svc.Import(ctx, stream, events)
+				require.NoError(t, err)
+			}
+
+			maxLen, noMaxAge := int64(4), time.Duration(0)
+			janitor := NewRetentionJanitor(svc, RetentionConfig{
+				Default: RetentionPolicy{MaxLen: 1, MaxAge: 150 * time.Minute},
+				Users: map[string]RetentionOverride{
+					"alice": {MaxLen: &maxLen},
+					"bob":   {MaxLen: &maxLen, MaxAge: &noMaxAge},
+				},
+			}, time.Minute)
+			require.NoError(t, janitor.RunOnce(ctx, now))
+
+			remaining, err := svc.Range(ctx, "alice", "-", "+", 0)
+			require.NoError(t, err)
+			assert.Equal(t, events[3:], remaining, "should keep the default max age")
+			remaining, err = svc.Range(ctx, "bob", "-", "+", 0)
+			require.NoError(t, err)
+			assert.Equal(t, events[1:], remaining, "should keep the max len of the user without max age")
+			remaining, err = svc.Range(ctx, "carol", "-", "+", 0)
+			require.NoError(t, err)
+			assert.Equal(t, events[4:], remaining, "should trim to the default max len")
+		})
+	}
+}
+
+func TestRetentionJanitorSweepsSensitiveSinceLastRun(t *testing.T) {
+	ctx := context.Background()
+	svc := &rangeRecordingStreamService{StreamService: NewMemoryStreamService(StreamConfig{ReadCount: 100, ReadBlock: -1})}
+	now := time.Now()
+	at := func(t time.Time) string {
+		return streamId{Ms: uint64(t.UnixMilli())}.String()
+	}
+	_, err := svc.Import(ctx, "email", []Event{
+		{Id: at(now.Add(-time.Hour)), Payload: "old sensitive", IsSensitive: true},
+		{Id: at(now.Add(-5 * time.Minute)), Payload: "sensitive", IsSensitive: true},
+	})
+	require.NoError(t, err)
+
+	janitor := NewRetentionJanitor(svc, RetentionConfig{Default: RetentionPolicy{SensitiveMaxAge: 10 * time.Minute}}, time.Minute)
+	require.NoError(t, janitor.RunOnce(ctx, now))
+	require.NotEmpty(t, svc.starts)
+	assert.Equal(t, "-", svc.starts[0])
+	length, err := svc.Len(ctx, "email")
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), length)
+	maxDeletedId, err := svc.MaxDeletedId(ctx, "email")
+	require.NoError(t, err)
+	assert.Equal(t, at(now.Add(-time.Hour)), maxDeletedId, "should record the expired events as truncated history")
+
+	svc.starts = nil
+	require.NoError(t, janitor.RunOnce(ctx, now.Add(10*time.Minute)))
+	require.NotEmpty(t, svc.starts)
+	assert.Equal(t, strconv.FormatInt(now.Add(-10*time.Minute).UnixMilli(), 10), svc.starts[0],
+		"should scan only from the cutoff of the last sweep")
+
+	// restored with an id older than the last cutoff
+	require.NoError(t, svc.Reset(ctx, "email"))
+	_, err = svc.Import(ctx, "email", []Event{{Id: at(now.Add(-time.Hour)), Payload: "imported", IsSensitive: true}})
+	require.NoError(t, err)
+	svc.starts = nil
+	require.NoError(t, janitor.RunOnce(ctx, now.Add(sensitiveFullSweepInterval)))
+	require.NotEmpty(t, svc.starts)
+	assert.Equal(t, "-", svc.starts[0], "should scan all events once a day")
+	length, err = svc.Len(ctx, "email")
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), length)
+	length, err = svc.Len(ctx, "email")
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), length)
+}
+
+func durationPtr(d time.Duration) *time.Duration {
+	return &d
+}
+
+// rangeRecordingStreamService records the start of every Range.
+type rangeRecordingStreamService struct {
+	StreamService
+	starts []string
+}
+
+func (s *rangeRecordingStreamService) Range(ctx context.Context, stream, start, end string, count int64) ([]Event, error) {
+	s.starts = append(s.starts, start)
+	return s.StreamService.Range(ctx, stream, start, end, count)
+}
+
+func TestRetentionJanitorArchive(t *testing.T) {
+	ctx := context.Background()
+	inner := NewMemoryStreamService(StreamConfig{ReadCount: 100, ReadBlock: -1})
+	svc := NewArchivingStreamService(inner, NewFileArchiveStore(t.TempDir()), ArchiveConfig{MaxLen: 2, BatchSize: 1, ReadCount: 100})
+	now := time.Now()
+	var events []Event
+	for i := 0; i < 6; i++ {
+		events = append(events, Event{
+			Id:          streamId{Ms: uint64(now.Add(time.Duration(i-6) * time.Hour).UnixMilli())}.String(),
+			Payload:     "hello",
+			IsSensitive: i%2 == 0,
+		})
+	}
+	_, err := svc.Import(ctx, "email", events)
+	require.NoError(t, err)
+	length, err := inner.Len(ctx, "email")
+	require.NoError(t, err)
+	require.Equal(t, int64(2), length)
+
+	janitor := NewRetentionJanitor(svc, RetentionConfig{
+		Default: RetentionPolicy{MaxAge: 270 * time.Minute, SensitiveMaxAge: 150 * time.Minute},
+	}, time.Minute)
+	require.NoError(t, janitor.RunOnce(ctx, now))
+
+	remaining, err := svc.Range(ctx, "email", "-", "+", 0)
+	require.NoError(t, err)
+	assert.Equal(t, []Event{events[3], events[4], events[5]}, remaining)
+}
+
+func TestRetentionJanitorRun(t *testing.T) {
+	svc := NewMemoryStreamService(StreamConfig{ReadCount: 100, ReadBlock: -1})
+	_, err := svc.Import(context.Background(), "email", []Event{{Id: "1-0", Payload: "old"}})
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		NewRetentionJanitor(svc, RetentionConfig{Default: RetentionPolicy{MaxAge: time.Hour}}, time.Millisecond).Run(ctx)
+		close(done)
+	}()
+	assert.Eventually(t, func() bool {
+		length, _ := svc.Len(context.Background(), "email")
+		return length == 0
+	}, time.Second, time.Millisecond)
+	cancel()
+	<-done
+}
+
+func TestGetRetentionPolicyHandler(t *testing.T) {
+	config := RetentionConfig{
+		Default: RetentionPolicy{MaxLen: 100, MaxAge: 24 * time.Hour},
+		Users:   map[string]RetentionOverride{"alice@example.com": {SensitiveMaxAge: durationPtr(time.Minute)}},
+	}
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	rec := httptest.NewRecorder()
+	c := echo.New().NewContext(req, rec)
+	c.Set("user", generateToken(User{"name", "alice@example.com"}, ""))
+
+	err := GetRetentionPolicyHandler(config)(c)
+
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rec.Result().StatusCode)
+	var policy map[string]int64
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&policy))
+	assert.Equal(t, map[string]int64{"MaxLen": 100, "MaxAgeSeconds": 86400, "SensitiveMaxAgeSeconds": 60}, policy)
+}
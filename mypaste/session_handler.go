@@ -0,0 +1,67 @@
+package mypaste
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// sessionTouchInterval limits how often the last use of a session is written.
+const sessionTouchInterval = time.Minute
+
+// SessionInfo marks the session of the request among the sessions of the user.
+type SessionInfo struct {
+	Session
+	Current bool
+}
+
+func GetSessionsHandler(sessions SessionStore) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		claims := getTokenClaims(c)
+		sessions, err := sessions.GetSessions(c.Request().Context(), claims.Email)
+		if err != nil {
+			return c.String(http.StatusInternalServerError, err.Error())
+		}
+		infos := make([]SessionInfo, 0, len(sessions))
+		for _, session := range sessions {
+			infos = append(infos, SessionInfo{session, session.Id == claims.ID})
+		}
+		return c.JSON(http.StatusOK, infos)
+	}
+}
+
+// DeleteSessionHandler revokes a session of the user, the token cookie is cleared if it is the session of the request.
+func DeleteSessionHandler(sessions SessionStore) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		claims := getTokenClaims(c)
+		id := c.Param("id")
+		err := sessions.DeleteSession(c.Request().Context(), claims.Email, id)
+		if errors.Is(err, ErrSessionNotFound) {
+			return c.String(http.StatusNotFound, err.Error())
+		}
+		if err != nil {
+			return c.String(http.StatusInternalServerError, err.Error())
+		}
+		c.Logger().Infof("session revoked, email: %v, id: %v", claims.Email, id)
+		if id == claims.ID {
+			c.SetCookie(expiredTokenCookie())
+		}
+		return c.NoContent(http.StatusOK)
+	}
+}
+
+// touchSession records the last use and client of session, skipping the write while nothing changed recently.
+func touchSession(ctx context.Context, sessions SessionStore, stream string, session Session, ip, userAgent string) error {
+	now := time.Now()
+	if session.Ip == ip && session.UserAgent == userAgent &&
+		now.Sub(time.Unix(session.LastUsedAt, 0)) < sessionTouchInterval {
+		return nil
+	}
+	session.LastUsedAt = now.Unix()
+	session.Ip = ip
+	session.UserAgent = userAgent
+	return sessions.UpdateSession(ctx, stream, session)
+}
@@ -0,0 +1,97 @@
+package mypaste
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSessionsHandler(t *testing.T) {
+	const jwtSignKey = "secret"
+
+	t.Run("list sessions", func(t *testing.T) {
+		svc := NewMemoryStreamService(StreamConfig{})
+		c, rec := sessionContextT(t, svc, jwtSignKey, "email")
+		other, _ := sessionContextT(t, svc, jwtSignKey, "email")
+		sessionContextT(t, svc, jwtSignKey, "email2")
+
+		require.NoError(t, GetSessionsHandler(svc)(c))
+
+		require.Equal(t, http.StatusOK, rec.Code)
+		var sessions []SessionInfo
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &sessions))
+		require.Len(t, sessions, 2)
+		current := map[string]bool{}
+		for _, session := range sessions {
+			current[session.Id] = session.Current
+			assert.NotZero(t, session.CreatedAt)
+			assert.NotZero(t, session.LastUsedAt)
+		}
+		assert.Equal(t, map[string]bool{getTokenClaims(c).ID: true, getTokenClaims(other).ID: false}, current)
+	})
+
+	t.Run("record last use", func(t *testing.T) {
+		svc := NewMemoryStreamService(StreamConfig{})
+		token, err := startSession(context.Background(), svc, User{"name", "email"}, "10.0.0.1", "browser", jwtSignKey)
+		require.NoError(t, err)
+
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set(echo.HeaderAuthorization, "Bearer "+token)
+		req.RemoteAddr = "10.0.0.2:1234"
+		req.Header.Set(echo.HeaderXRealIP, "10.0.0.9")
+		req.Header.Set("User-Agent", "script")
+		rec := httptest.NewRecorder()
+		e := echo.New()
+		e.IPExtractor = newIPExtractor("")
+		okHandler := func(c echo.Context) error { return c.NoContent(http.StatusOK) }
+		require.NoError(t, NewAuthMiddleware(jwtSignKey, svc, svc)(okHandler)(e.NewContext(req, rec)))
+		require.Equal(t, http.StatusOK, rec.Code)
+
+		sessions, err := svc.GetSessions(context.Background(), "email")
+		require.NoError(t, err)
+		require.Len(t, sessions, 1)
+		assert.Equal(t, "10.0.0.2", sessions[0].Ip)
+		assert.Equal(t, "script", sessions[0].UserAgent)
+		assert.InDelta(t, time.Now().Unix(), sessions[0].LastUsedAt, 5)
+	})
+
+	t.Run("revoke session", func(t *testing.T) {
+		svc := NewMemoryStreamService(StreamConfig{})
+		c, _ := sessionContextT(t, svc, jwtSignKey, "email")
+		other, _ := sessionContextT(t, svc, jwtSignKey, "email")
+		otherUser, _ := sessionContextT(t, svc, jwtSignKey, "email2")
+
+		rec := deleteSessionT(t, svc, c, getTokenClaims(otherUser).ID)
+		assert.Equal(t, http.StatusNotFound, rec.Code, "should not revoke session of other user")
+
+		rec = deleteSessionT(t, svc, c, getTokenClaims(other).ID)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Nil(t, getResponseTokenCookie(rec.Result()), "should keep token cookie")
+		_, err := svc.GetSession(context.Background(), "email", getTokenClaims(other).ID)
+		assert.ErrorIs(t, err, ErrSessionNotFound)
+
+		rec = deleteSessionT(t, svc, c, getTokenClaims(c).ID)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		tokenCookie := getResponseTokenCookie(rec.Result())
+		require.NotNil(t, tokenCookie, "should clear token cookie of current session")
+		assert.Less(t, tokenCookie.Expires, time.Now())
+	})
+}
+
+func deleteSessionT(t *testing.T, svc Store, authorized echo.Context, id string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodDelete, "/", nil)
+	rec := httptest.NewRecorder()
+	c := echo.New().NewContext(req, rec)
+	c.Set("user", authorized.Get("user"))
+	c.SetParamNames("id")
+	c.SetParamValues(id)
+	require.NoError(t, DeleteSessionHandler(svc)(c))
+	return rec
+}
@@ -0,0 +1,752 @@
+package mypaste
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// sqliteMigrations are applied in order when the service is created, append new migrations to the end.
+// The first migration keeps IF NOT EXISTS to adopt databases created before migrations were versioned.
+var sqliteMigrations = []string{
+	`CREATE TABLE IF NOT EXISTS streams (
+		stream   TEXT PRIMARY KEY,
+		last_ms  INTEGER NOT NULL,
+		last_seq INTEGER NOT NULL
+	);
+	CREATE TABLE IF NOT EXISTS events (
+		stream TEXT NOT NULL,
+		ms     INTEGER NOT NULL,
+		seq    INTEGER NOT NULL,
+		json   TEXT NOT NULL,
+		PRIMARY KEY (stream, ms, seq)
+	);
+	CREATE TABLE IF NOT EXISTS devices (
+		stream      TEXT NOT NULL,
+		id          TEXT NOT NULL,
+		description TEXT NOT NULL,
+		PRIMARY KEY (stream, id)
+	);`,
+	`ALTER TABLE events ADD COLUMN version INTEGER NOT NULL DEFAULT 1;`,
+	`CREATE TABLE idempotency_keys (
+		stream     TEXT NOT NULL,
+		key        TEXT NOT NULL,
+		event_id   TEXT NOT NULL,
+		expires_at INTEGER NOT NULL,
+		PRIMARY KEY (stream, key)
+	);`,
+	`CREATE TABLE api_tokens (
+		hash       TEXT PRIMARY KEY,
+		stream     TEXT NOT NULL,
+		id         TEXT NOT NULL,
+		json       TEXT NOT NULL,
+		created_at INTEGER NOT NULL,
+		UNIQUE (stream, id)
+	);`,
+	`CREATE TABLE sessions (
+		stream     TEXT NOT NULL,
+		id         TEXT NOT NULL,
+		json       TEXT NOT NULL,
+		expires_at INTEGER NOT NULL,
+		PRIMARY KEY (stream, id)
+	);`,
+	`ALTER TABLE streams ADD COLUMN deleted_ms INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE streams ADD COLUMN deleted_seq INTEGER NOT NULL DEFAULT 0;`,
+	`ALTER TABLE idempotency_keys ADD COLUMN request_hash TEXT NOT NULL DEFAULT '';`,
+}
+
+type sqliteStreamService struct {
+	db       *sql.DB
+	config   StreamConfig
+	notifier *streamNotifier
+}
+
+var _ Store = (*sqliteStreamService)(nil)
+
+func OpenSqliteDB(path string) (*sql.DB, error) {
+	// escape the path so ? or # in it are not taken as the start of the options
+	dsn := url.URL{
+		Scheme:   "file",
+		Opaque:   (&url.URL{Path: path}).EscapedPath(),
+		RawQuery: "_journal_mode=WAL&_busy_timeout=5000&_txlock=immediate",
+	}
+	db, err := sql.Open("sqlite3", dsn.String())
+	if err != nil {
+		return nil, err
+	}
+	// a single connection serializes writers, blocked reads wait on the notifier instead
+	db.SetMaxOpenConns(1)
+	return db, nil
+}
+
+func NewSqliteStreamService(db *sql.DB, config StreamConfig) (Store, error) {
+	if err := migrateSqlite(db); err != nil {
+		return nil, fmt.Errorf("failed to migrate sqlite schema, %w", err)
+	}
+	return &sqliteStreamService{
+		db:       db,
+		config:   config,
+		notifier: newStreamNotifier(),
+	}, nil
+}
+
+func migrateSqlite(db *sql.DB) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	_, err = tx.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version    INTEGER PRIMARY KEY,
+		applied_at INTEGER NOT NULL
+	)`)
+	if err != nil {
+		return err
+	}
+	var version int
+	if err := tx.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&version); err != nil {
+		return err
+	}
+	for ; version < len(sqliteMigrations); version++ {
+		if _, err := tx.Exec(sqliteMigrations[version]); err != nil {
+			return fmt.Errorf("migration %v, %w", version+1, err)
+		}
+		_, err := tx.Exec(`INSERT INTO schema_migrations (version, applied_at) VALUES (?, ?)`, version+1, time.Now().Unix())
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *sqliteStreamService) Add(ctx context.Context, stream string, event Event) (Event, error) {
+	added, err := s.AddBatch(ctx, stream, []Event{event})
+	if err != nil {
+		return event, err
+	}
+	return added[0], nil
+}
+
+func (s *sqliteStreamService) AddBatch(ctx context.Context, stream string, events []Event) ([]Event, error) {
+	if len(events) == 0 {
+		return []Event{}, nil
+	}
+	devices, err := batchDevices(stream, events)
+	if err != nil {
+		return nil, err
+	}
+	added := make([]Event, len(events))
+	err = s.withTx(ctx, func(tx *sql.Tx) error {
+		for _, device := range devices {
+			if err := s.addDevice(ctx, tx, stream, device.Device, device.first); err != nil {
+				return err
+			}
+		}
+		var lastId streamId
+		err := tx.QueryRowContext(ctx, `SELECT last_ms, last_seq FROM streams WHERE stream = ?`, stream).
+			Scan(&lastId.Ms, &lastId.Seq)
+		if err != nil && err != sql.ErrNoRows {
+			return err
+		}
+		for i, event := range events {
+			event.Timestamp = time.Now().Unix()
+			lastId = nextStreamId(lastId, time.Now())
+			event.Id = lastId.String()
+			_, err = tx.ExecContext(ctx, `INSERT INTO events (stream, ms, seq, version, json) VALUES (?, ?, ?, ?, ?)`,
+				stream, lastId.Ms, lastId.Seq, eventEncodingVersion, encodeEvent(event))
+			if err != nil {
+				return err
+			}
+			added[i] = event
+		}
+		_, err = tx.ExecContext(ctx, `INSERT INTO streams (stream, last_ms, last_seq) VALUES (?, ?, ?)
+			ON CONFLICT (stream) DO UPDATE SET last_ms = excluded.last_ms, last_seq = excluded.last_seq`,
+			stream, lastId.Ms, lastId.Seq)
+		if err != nil {
+			return err
+		}
+		return s.trim(ctx, tx, stream)
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.notifier.notify(stream)
+	return added, nil
+}
+
+func (s *sqliteStreamService) trim(ctx context.Context, tx *sql.Tx, stream string) error {
+	if s.config.MaxLen <= 0 {
+		return nil
+	}
+	_, err := s.deleteEvents(ctx, tx, stream, true, `rowid NOT IN (
+			SELECT rowid FROM events WHERE stream = ? ORDER BY ms DESC, seq DESC LIMIT ?
+		)`, stream, s.config.MaxLen)
+	return err
+}
+
+// deleteEvents deletes the events of the stream matching where, the greatest deleted id is recorded if record is set.
+func (s *sqliteStreamService) deleteEvents(ctx context.Context, tx *sql.Tx, stream string, record bool, where string, args ...interface{}) (int64, error) {
+	rows, err := tx.QueryContext(ctx, `DELETE FROM events WHERE stream = ? AND `+where+` RETURNING ms, seq`,
+		append([]interface{}{stream}, args...)...)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+	var count int64
+	var maxId streamId
+	for rows.Next() {
+		var id streamId
+		if err := rows.Scan(&id.Ms, &id.Seq); err != nil {
+			return count, err
+		}
+		if maxId.Less(id) {
+			maxId = id
+		}
+		count++
+	}
+	if err := rows.Err(); err != nil || count == 0 || !record {
+		return count, err
+	}
+	rows.Close()
+	_, err = tx.ExecContext(ctx, `UPDATE streams SET deleted_ms = ?, deleted_seq = ?
+		WHERE stream = ? AND (deleted_ms < ? OR (deleted_ms = ? AND deleted_seq < ?))`,
+		maxId.Ms, maxId.Seq, stream, maxId.Ms, maxId.Ms, maxId.Seq)
+	return count, err
+}
+
+func (s *sqliteStreamService) Read(ctx context.Context, stream, lastId string) ([]Event, error) {
+	if lastId == "" {
+		lastId = "0"
+	}
+	after, err := parseStreamId(lastId)
+	if err != nil {
+		return nil, err
+	}
+	return readBlocking(ctx, readBlockOf(ctx, s.config.ReadBlock), func() ([]Event, <-chan struct{}, error) {
+		updated := s.notifier.wait(stream)
+		var events []Event
+		events, after, err = s.readAfter(ctx, stream, after)
+		return events, updated, err
+	})
+}
+
+// readAfter returns the events after the given id and the id to read after next time,
+// pages having only corrupt events are skipped so they cannot hold back the events after them.
+func (s *sqliteStreamService) readAfter(ctx context.Context, stream string, after streamId) ([]Event, streamId, error) {
+	limit := s.config.ReadCount
+	if limit <= 0 {
+		limit = -1
+	}
+	for {
+		rows, err := s.db.QueryContext(ctx, `SELECT ms, seq, version, json FROM events
+			WHERE stream = ? AND (ms > ? OR (ms = ? AND seq > ?))
+			ORDER BY ms, seq LIMIT ?`, stream, after.Ms, after.Ms, after.Seq, limit)
+		if err != nil {
+			return nil, after, err
+		}
+		events, lastId, err := s.scanPage(stream, rows)
+		if err != nil || len(events) > 0 || lastId == (streamId{}) {
+			return events, after, err
+		}
+		after = lastId
+	}
+}
+
+func (s *sqliteStreamService) scanEvents(stream string, rows *sql.Rows) ([]Event, error) {
+	events, _, err := s.scanPage(stream, rows)
+	return events, err
+}
+
+// scanPage also returns the id of the last row scanned, including corrupt events.
+func (s *sqliteStreamService) scanPage(stream string, rows *sql.Rows) ([]Event, streamId, error) {
+	defer rows.Close()
+
+	events := make([]Event, 0)
+	var id streamId
+	for rows.Next() {
+		var version int
+		var jsonValue string
+		if err := rows.Scan(&id.Ms, &id.Seq, &version, &jsonValue); err != nil {
+			return nil, id, err
+		}
+		if event, ok := decodeStoredEvent(stream, id.String(), version, jsonValue); ok {
+			events = append(events, event)
+		}
+	}
+	return events, id, rows.Err()
+}
+
+func (s *sqliteStreamService) Get(ctx context.Context, stream, id string) (Event, error) {
+	parsed, err := parseStreamId(id)
+	if err != nil {
+		return Event{}, err
+	}
+	rows, err := s.db.QueryContext(ctx, `SELECT ms, seq, version, json FROM events
+		WHERE stream = ? AND ms = ? AND seq = ?`, stream, parsed.Ms, parsed.Seq)
+	if err != nil {
+		return Event{}, err
+	}
+	events, err := s.scanEvents(stream, rows)
+	if err != nil {
+		return Event{}, err
+	}
+	if len(events) == 0 {
+		return Event{}, ErrEventNotFound
+	}
+	return events[0], nil
+}
+
+func (s *sqliteStreamService) Delete(ctx context.Context, stream string, ids ...string) (int64, error) {
+	return s.delete(ctx, stream, false, ids)
+}
+
+func (s *sqliteStreamService) Expire(ctx context.Context, stream string, ids ...string) (int64, error) {
+	return s.delete(ctx, stream, true, ids)
+}
+
+func (s *sqliteStreamService) delete(ctx context.Context, stream string, record bool, ids []string) (int64, error) {
+	var count int64
+	err := s.withTx(ctx, func(tx *sql.Tx) error {
+		for _, id := range ids {
+			parsed, err := parseStreamId(id)
+			if err != nil {
+				return err
+			}
+			deleted, err := s.deleteEvents(ctx, tx, stream, record, `ms = ? AND seq = ?`, parsed.Ms, parsed.Seq)
+			if err != nil {
+				return err
+			}
+			count += deleted
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+func (s *sqliteStreamService) Reset(ctx context.Context, stream string) error {
+	return s.withTx(ctx, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, `DELETE FROM events WHERE stream = ?`, stream); err != nil {
+			return err
+		}
+		_, err := tx.ExecContext(ctx, `DELETE FROM streams WHERE stream = ?`, stream)
+		return err
+	})
+}
+
+func (s *sqliteStreamService) Len(ctx context.Context, stream string) (int64, error) {
+	var count int64
+	err := s.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM events WHERE stream = ?`, stream).Scan(&count)
+	return count, err
+}
+
+func (s *sqliteStreamService) Range(ctx context.Context, stream, start, end string, count int64) ([]Event, error) {
+	startId, err := parseRangeStart(start)
+	if err != nil {
+		return nil, err
+	}
+	endId, err := parseRangeEnd(end)
+	if err != nil {
+		return nil, err
+	}
+	if count <= 0 {
+		count = -1
+	}
+	rows, err := s.db.QueryContext(ctx, `SELECT ms, seq, version, json FROM events
+		WHERE stream = ? AND (ms > ? OR (ms = ? AND seq >= ?)) AND (ms < ? OR (ms = ? AND seq <= ?))
+		ORDER BY ms, seq LIMIT ?`,
+		stream, startId.Ms, startId.Ms, startId.Seq, endId.Ms, endId.Ms, endId.Seq, count)
+	if err != nil {
+		return nil, err
+	}
+	return s.scanEvents(stream, rows)
+}
+
+func (s *sqliteStreamService) RevRange(ctx context.Context, stream, end, start string, count int64) ([]Event, error) {
+	startId, err := parseRangeStart(start)
+	if err != nil {
+		return nil, err
+	}
+	endId, err := parseRangeEnd(end)
+	if err != nil {
+		return nil, err
+	}
+	if count <= 0 {
+		count = -1
+	}
+	rows, err := s.db.QueryContext(ctx, `SELECT ms, seq, version, json FROM events
+		WHERE stream = ? AND (ms > ? OR (ms = ? AND seq >= ?)) AND (ms < ? OR (ms = ? AND seq <= ?))
+		ORDER BY ms DESC, seq DESC LIMIT ?`,
+		stream, startId.Ms, startId.Ms, startId.Seq, endId.Ms, endId.Ms, endId.Seq, count)
+	if err != nil {
+		return nil, err
+	}
+	return s.scanEvents(stream, rows)
+}
+
+func (s *sqliteStreamService) Count(ctx context.Context, stream, start, end string) (int64, error) {
+	startId, err := parseRangeStart(start)
+	if err != nil {
+		return 0, err
+	}
+	endId, err := parseRangeEnd(end)
+	if err != nil {
+		return 0, err
+	}
+	var count int64
+	err = s.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM events
+		WHERE stream = ? AND (ms > ? OR (ms = ? AND seq >= ?)) AND (ms < ? OR (ms = ? AND seq <= ?))`,
+		stream, startId.Ms, startId.Ms, startId.Seq, endId.Ms, endId.Ms, endId.Seq).Scan(&count)
+	return count, err
+}
+
+func (s *sqliteStreamService) Trim(ctx context.Context, stream, minId string) (int64, error) {
+	min, err := parseStreamId(minId)
+	if err != nil {
+		return 0, err
+	}
+	var count int64
+	err = s.withTx(ctx, func(tx *sql.Tx) error {
+		count, err = s.deleteEvents(ctx, tx, stream, true, `(ms < ? OR (ms = ? AND seq < ?))`, min.Ms, min.Ms, min.Seq)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+func (s *sqliteStreamService) MaxDeletedId(ctx context.Context, stream string) (string, error) {
+	var id streamId
+	err := s.db.QueryRowContext(ctx, `SELECT deleted_ms, deleted_seq FROM streams WHERE stream = ?`, stream).
+		Scan(&id.Ms, &id.Seq)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	if err != nil || id == (streamId{}) {
+		return "", err
+	}
+	return id.String(), nil
+}
+
+func (s *sqliteStreamService) Import(ctx context.Context, stream string, events []Event) (int64, error) {
+	var count int64
+	err := s.withTx(ctx, func(tx *sql.Tx) error {
+		count = 0
+		var lastId streamId
+		err := tx.QueryRowContext(ctx, `SELECT last_ms, last_seq FROM streams WHERE stream = ?`, stream).
+			Scan(&lastId.Ms, &lastId.Seq)
+		if err != nil && err != sql.ErrNoRows {
+			return err
+		}
+		for _, event := range events {
+			id, err := parseStreamId(event.Id)
+			if err != nil {
+				return err
+			}
+			if !lastId.Less(id) {
+				continue
+			}
+			event.Id = id.String()
+			_, err = tx.ExecContext(ctx, `INSERT INTO events (stream, ms, seq, version, json) VALUES (?, ?, ?, ?, ?)`,
+				stream, id.Ms, id.Seq, eventEncodingVersion, encodeEvent(event))
+			if err != nil {
+				return err
+			}
+			lastId = id
+			count++
+		}
+		if count == 0 {
+			return nil
+		}
+		_, err = tx.ExecContext(ctx, `INSERT INTO streams (stream, last_ms, last_seq) VALUES (?, ?, ?)
+			ON CONFLICT (stream) DO UPDATE SET last_ms = excluded.last_ms, last_seq = excluded.last_seq`,
+			stream, lastId.Ms, lastId.Seq)
+		if err != nil {
+			return err
+		}
+		return s.trim(ctx, tx, stream)
+	})
+	if err != nil {
+		return 0, err
+	}
+	if count > 0 {
+		s.notifier.notify(stream)
+	}
+	return count, nil
+}
+
+func (s *sqliteStreamService) Streams(ctx context.Context) ([]string, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT DISTINCT stream FROM events UNION SELECT DISTINCT stream FROM devices ORDER BY stream`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	streams := make([]string, 0)
+	for rows.Next() {
+		var stream string
+		if err := rows.Scan(&stream); err != nil {
+			return nil, err
+		}
+		streams = append(streams, stream)
+	}
+	return streams, rows.Err()
+}
+
+func (s *sqliteStreamService) ReserveIdempotencyKey(ctx context.Context, stream, key, requestHash string, ttl time.Duration) (IdempotencyKey, bool, error) {
+	record := IdempotencyKey{RequestHash: requestHash}
+	var reserved bool
+	err := s.withTx(ctx, func(tx *sql.Tx) error {
+		now := time.Now()
+		_, err := tx.ExecContext(ctx, `DELETE FROM idempotency_keys WHERE stream = ? AND expires_at <= ?`,
+			stream, now.UnixMilli())
+		if err != nil {
+			return err
+		}
+		res, err := tx.ExecContext(ctx, `INSERT INTO idempotency_keys (stream, key, request_hash, event_id, expires_at)
+			VALUES (?, ?, ?, '', ?) ON CONFLICT (stream, key) DO NOTHING`, stream, key, requestHash, now.Add(ttl).UnixMilli())
+		if err != nil {
+			return err
+		}
+		count, err := res.RowsAffected()
+		if err != nil || count > 0 {
+			reserved = count > 0
+			return err
+		}
+		return tx.QueryRowContext(ctx, `SELECT request_hash, event_id FROM idempotency_keys WHERE stream = ? AND key = ?`,
+			stream, key).Scan(&record.RequestHash, &record.EventId)
+	})
+	return record, reserved, err
+}
+
+func (s *sqliteStreamService) SetIdempotencyKey(ctx context.Context, stream, key string, record IdempotencyKey, ttl time.Duration) error {
+	_, err := s.db.ExecContext(ctx, `UPDATE idempotency_keys SET request_hash = ?, event_id = ?, expires_at = ?
+		WHERE stream = ? AND key = ?`, record.RequestHash, record.EventId, time.Now().Add(ttl).UnixMilli(), stream, key)
+	return err
+}
+
+func (s *sqliteStreamService) DeleteIdempotencyKey(ctx context.Context, stream, key string) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM idempotency_keys WHERE stream = ? AND key = ?`, stream, key)
+	return err
+}
+
+func (s *sqliteStreamService) AddApiToken(ctx context.Context, stream string, token ApiToken) error {
+	value, err := json.Marshal(token)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.ExecContext(ctx, `INSERT INTO api_tokens (hash, stream, id, json, created_at) VALUES (?, ?, ?, ?, ?)`,
+		token.Hash, stream, token.Id, string(value), token.CreatedAt)
+	return err
+}
+
+func (s *sqliteStreamService) GetApiTokens(ctx context.Context, stream string) ([]ApiToken, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT hash, json FROM api_tokens WHERE stream = ? ORDER BY created_at, id`, stream)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := make([]ApiToken, 0)
+	for rows.Next() {
+		var hash, value string
+		if err := rows.Scan(&hash, &value); err != nil {
+			return nil, err
+		}
+		token, err := decodeApiToken(hash, value)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, token)
+	}
+	return tokens, rows.Err()
+}
+
+func (s *sqliteStreamService) GetApiToken(ctx context.Context, hash string) (ApiToken, error) {
+	var value string
+	err := s.db.QueryRowContext(ctx, `SELECT json FROM api_tokens WHERE hash = ?`, hash).Scan(&value)
+	if err == sql.ErrNoRows {
+		return ApiToken{}, ErrApiTokenNotFound
+	}
+	if err != nil {
+		return ApiToken{}, err
+	}
+	return decodeApiToken(hash, value)
+}
+
+func (s *sqliteStreamService) DeleteApiToken(ctx context.Context, stream, id string) error {
+	res, err := s.db.ExecContext(ctx, `DELETE FROM api_tokens WHERE stream = ? AND id = ?`, stream, id)
+	if err != nil {
+		return err
+	}
+	count, err := res.RowsAffected()
+	if err == nil && count == 0 {
+		return ErrApiTokenNotFound
+	}
+	return err
+}
+
+func (s *sqliteStreamService) SetSession(ctx context.Context, stream string, session Session) error {
+	value, err := json.Marshal(session)
+	if err != nil {
+		return err
+	}
+	return s.withTx(ctx, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, `DELETE FROM sessions WHERE stream = ? AND expires_at <= ?`, stream, time.Now().Unix())
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, `INSERT INTO sessions (stream, id, json, expires_at) VALUES (?, ?, ?, ?)
+			ON CONFLICT (stream, id) DO UPDATE SET json = excluded.json, expires_at = excluded.expires_at`,
+			stream, session.Id, string(value), session.ExpiresAt)
+		return err
+	})
+}
+
+func (s *sqliteStreamService) UpdateSession(ctx context.Context, stream string, session Session) error {
+	value, err := json.Marshal(session)
+	if err != nil {
+		return err
+	}
+	res, err := s.db.ExecContext(ctx, `UPDATE sessions SET json = ?, expires_at = ? WHERE stream = ? AND id = ?`,
+		string(value), session.ExpiresAt, stream, session.Id)
+	if err != nil {
+		return err
+	}
+	count, err := res.RowsAffected()
+	if err == nil && count == 0 {
+		return ErrSessionNotFound
+	}
+	return err
+}
+
+func (s *sqliteStreamService) GetSession(ctx context.Context, stream, id string) (Session, error) {
+	var value string
+	err := s.db.QueryRowContext(ctx, `SELECT json FROM sessions WHERE stream = ? AND id = ? AND expires_at > ?`,
+		stream, id, time.Now().Unix()).Scan(&value)
+	if err == sql.ErrNoRows {
+		return Session{}, ErrSessionNotFound
+	}
+	if err != nil {
+		return Session{}, err
+	}
+	return decodeSession(value)
+}
+
+func (s *sqliteStreamService) GetSessions(ctx context.Context, stream string) ([]Session, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT json FROM sessions WHERE stream = ? AND expires_at > ?`,
+		stream, time.Now().Unix())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := make([]Session, 0)
+	for rows.Next() {
+		var value string
+		if err := rows.Scan(&value); err != nil {
+			return nil, err
+		}
+		session, err := decodeSession(value)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, session)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	sortSessions(sessions)
+	return sessions, nil
+}
+
+func (s *sqliteStreamService) DeleteSession(ctx context.Context, stream, id string) error {
+	res, err := s.db.ExecContext(ctx, `DELETE FROM sessions WHERE stream = ? AND id = ?`, stream, id)
+	if err != nil {
+		return err
+	}
+	count, err := res.RowsAffected()
+	if err == nil && count == 0 {
+		return ErrSessionNotFound
+	}
+	return err
+}
+
+func (s *sqliteStreamService) DeleteSessions(ctx context.Context, stream string) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM sessions WHERE stream = ?`, stream)
+	return err
+}
+
+func (s *sqliteStreamService) Close() error {
+	return s.db.Close()
+}
+
+func (s *sqliteStreamService) AddDevice(ctx context.Context, stream string, device Device) (Device, error) {
+	err := s.withTx(ctx, func(tx *sql.Tx) error {
+		return s.addDevice(ctx, tx, stream, device, false)
+	})
+	return device, err
+}
+
+func (s *sqliteStreamService) AddFirstDevice(ctx context.Context, stream string, device Device) (Device, error) {
+	err := s.withTx(ctx, func(tx *sql.Tx) error {
+		return s.addDevice(ctx, tx, stream, device, true)
+	})
+	return device, err
+}
+
+// addDevice adds or updates device, a first device fails if the stream already has a device.
+func (s *sqliteStreamService) addDevice(ctx context.Context, tx *sql.Tx, stream string, device Device, first bool) error {
+	if first {
+		var count int
+		err := tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM devices WHERE stream = ?`, stream).Scan(&count)
+		if err != nil {
+			return err
+		}
+		if count > 0 {
+			return errDeviceExists(stream)
+		}
+	}
+	_, err := tx.ExecContext(ctx, `INSERT INTO devices (stream, id, description) VALUES (?, ?, ?)
+		ON CONFLICT (stream, id) DO UPDATE SET description = excluded.description`,
+		stream, device.Id, device.Description)
+	return err
+}
+
+func (s *sqliteStreamService) GetDevices(ctx context.Context, stream string) ([]Device, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT id, description FROM devices WHERE stream = ?`, stream)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	devices := make([]Device, 0)
+	for rows.Next() {
+		var device Device
+		if err := rows.Scan(&device.Id, &device.Description); err != nil {
+			return nil, err
+		}
+		devices = append(devices, device)
+	}
+	return devices, rows.Err()
+}
+
+func (s *sqliteStreamService) withTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
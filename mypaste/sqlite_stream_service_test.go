@@ -0,0 +1,119 @@
+package mypaste
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSqliteStreamService(t *testing.T) {
+
+	t.Run("should keep history after reopen", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "mypaste.db")
+		cfg := StreamConfig{ReadBlock: -1}
+
+		db, err := OpenSqliteDB(path)
+		require.NoError(t, err)
+		svc, err := NewSqliteStreamService(db, cfg)
+		require.NoError(t, err)
+		e1, err := svc.Add(context.Background(), "email", Event{Payload: "hello"})
+		require.NoError(t, err)
+		_, err = svc.AddDevice(context.Background(), "email", Device{Id: "d1", Description: "device 1"})
+		require.NoError(t, err)
+		require.NoError(t, db.Close())
+
+		db, err = OpenSqliteDB(path)
+		require.NoError(t, err)
+		defer db.Close()
+		svc, err = NewSqliteStreamService(db, cfg)
+		require.NoError(t, err)
+		events, err := svc.Read(context.Background(), "email", "")
+		require.NoError(t, err)
+		assert.Equal(t, []Event{e1}, events)
+		devices, err := svc.GetDevices(context.Background(), "email")
+		require.NoError(t, err)
+		assert.Equal(t, []Device{{Id: "d1", Description: "device 1"}}, devices)
+
+		e2, err := svc.Add(context.Background(), "email", Event{Payload: "hello"})
+		require.NoError(t, err)
+		assert.NotEqual(t, e1.Id, e2.Id)
+	})
+
+	t.Run("should not trim without max len", func(t *testing.T) {
+		db, err := OpenSqliteDB(filepath.Join(t.TempDir(), "mypaste.db"))
+		require.NoError(t, err)
+		defer db.Close()
+		svc, err := NewSqliteStreamService(db, StreamConfig{ReadBlock: -1})
+		require.NoError(t, err)
+		for i := 0; i < 150; i++ {
+			_, err := svc.Add(context.Background(), "email", Event{Payload: "hello"})
+			require.NoError(t, err)
+		}
+		events, err := svc.Read(context.Background(), "email", "")
+		require.NoError(t, err)
+		assert.Equal(t, 150, len(events))
+	})
+
+	t.Run("should trim to max len", func(t *testing.T) {
+		db, err := OpenSqliteDB(filepath.Join(t.TempDir(), "mypaste.db"))
+		require.NoError(t, err)
+		defer db.Close()
+		svc, err := NewSqliteStreamService(db, StreamConfig{MaxLen: 3, ReadBlock: -1})
+		require.NoError(t, err)
+		var added []Event
+		for i := 0; i < 5; i++ {
+			event, err := svc.Add(context.Background(), "email", Event{Payload: "hello"})
+			require.NoError(t, err)
+			added = append(added, event)
+		}
+		events, err := svc.Read(context.Background(), "email", "")
+		require.NoError(t, err)
+		assert.Equal(t, added[2:], events)
+	})
+
+	t.Run("should apply pending migrations", func(t *testing.T) {
+		db, err := OpenSqliteDB(filepath.Join(t.TempDir(), "mypaste.db"))
+		require.NoError(t, err)
+		defer db.Close()
+		_, err = db.Exec(sqliteMigrations[0] + `
+			CREATE TABLE schema_migrations (version INTEGER PRIMARY KEY, applied_at INTEGER NOT NULL);
+			INSERT INTO schema_migrations (version, applied_at) VALUES (1, 0);
+			INSERT INTO streams (stream, last_ms, last_seq) VALUES ('email', 1, 0);
+			INSERT INTO events (stream, ms, seq, json) VALUES ('email', 1, 0, '{"Id":"1-0","Payload":"hello"}')`)
+		require.NoError(t, err)
+
+		svc, err := NewSqliteStreamService(db, StreamConfig{ReadBlock: -1})
+		require.NoError(t, err)
+		var version int
+		require.NoError(t, db.QueryRow(`SELECT MAX(version) FROM schema_migrations`).Scan(&version))
+		assert.Equal(t, len(sqliteMigrations), version)
+		events, err := svc.Read(context.Background(), "email", "")
+		require.NoError(t, err)
+		assert.Equal(t, []Event{{Id: "1-0", Payload: "hello"}}, events)
+
+		_, err = svc.Trim(context.Background(), "email", "2-0")
+		require.NoError(t, err)
+		maxDeletedId, err := svc.MaxDeletedId(context.Background(), "email")
+		require.NoError(t, err)
+		assert.Equal(t, "1-0", maxDeletedId)
+
+		_, err = NewSqliteStreamService(db, StreamConfig{ReadBlock: -1})
+		assert.NoError(t, err, "should not apply migrations again")
+	})
+
+	t.Run("should open a path with url characters", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "my?paste#1%.db")
+		db, err := OpenSqliteDB(path)
+		require.NoError(t, err)
+		defer db.Close()
+		svc, err := NewSqliteStreamService(db, StreamConfig{ReadBlock: -1})
+		require.NoError(t, err)
+		_, err = svc.Add(context.Background(), "email", Event{Payload: "hello"})
+		require.NoError(t, err)
+		assert.FileExists(t, path)
+	})
+
+}
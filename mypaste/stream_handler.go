@@ -2,29 +2,30 @@ package mypaste
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/labstack/echo/v4"
 )
 
-func AddEventHandler(streamService StreamService) echo.HandlerFunc {
+func AddEventHandler(streamService StreamService, idempotency IdempotencyStore) echo.HandlerFunc {
 	return func(c echo.Context) error {
 		user := GetAuthorizedUser(c)
 		var event Event
 		if err := c.Bind(&event); err != nil {
 			return c.String(http.StatusBadRequest, err.Error())
 		}
-		ctx := c.Request().Context()
-		stream := user.Email
-		if strings.Contains(event.Kind, "Device") {
-			if err := handleDeviceEvent(ctx, streamService, stream, event); err != nil {
-				return c.String(http.StatusInternalServerError, err.Error())
-			}
+		if key := c.Request().Header.Get("Idempotency-Key"); key != "" {
+			return addIdempotentEvent(c, streamService, idempotency, user.Email, key, event)
 		}
-		event, err := streamService.Add(ctx, stream, event)
+		event, err := addEvent(c.Request().Context(), streamService, user.Email, event)
 		if err != nil {
 			return c.String(http.StatusInternalServerError, err.Error())
 		}
@@ -32,19 +33,322 @@ func AddEventHandler(streamService StreamService) echo.HandlerFunc {
 	}
 }
 
+const (
+	// idempotencyKeyWindow is how long a retried request with the same Idempotency-Key gets the original event.
+	idempotencyKeyWindow = 24 * time.Hour
+	// idempotencyKeyPendingTTL releases the key of a request that never finished, eg. when the server stopped.
+	idempotencyKeyPendingTTL = time.Minute
+	maxIdempotencyKeyLength  = 255
+)
+
+func addIdempotentEvent(c echo.Context, streamService StreamService, idempotency IdempotencyStore, stream, key string, event Event) error {
+	ctx := c.Request().Context()
+	if len(key) > maxIdempotencyKeyLength {
+		return c.String(http.StatusBadRequest, "idempotency key is too long")
+	}
+	requestHash := idempotencyRequestHash(event)
+	record, reserved, err := idempotency.ReserveIdempotencyKey(ctx, stream, key, requestHash, idempotencyKeyPendingTTL)
+	if err != nil {
+		return c.String(http.StatusInternalServerError, err.Error())
+	}
+	if !reserved {
+		if record.RequestHash != "" && record.RequestHash != requestHash {
+			return c.String(http.StatusUnprocessableEntity, "idempotency key was used with a different request")
+		}
+		if record.EventId == "" {
+			return c.String(http.StatusConflict, "a request with the same idempotency key is in progress")
+		}
+		original, err := streamService.Get(ctx, stream, record.EventId)
+		if errors.Is(err, ErrEventNotFound) {
+			return c.String(http.StatusConflict, "the event of the idempotency key no longer exists")
+		}
+		if err != nil {
+			return c.String(http.StatusInternalServerError, err.Error())
+		}
+		c.Response().Header().Set("Idempotent-Replayed", "true")
+		return c.JSON(http.StatusOK, original)
+	}
+	event, err = addEvent(ctx, streamService, stream, event)
+	if err != nil {
+		if err := idempotency.DeleteIdempotencyKey(ctx, stream, key); err != nil {
+			fmt.Printf("failed to release idempotency key, %v\n", err)
+		}
+		return c.String(http.StatusInternalServerError, err.Error())
+	}
+	record = IdempotencyKey{RequestHash: requestHash, EventId: event.Id}
+	if err := idempotency.SetIdempotencyKey(ctx, stream, key, record, idempotencyKeyWindow); err != nil {
+		// the event is added, a retry within the pending ttl gets a conflict instead of a duplicate
+		fmt.Printf("failed to set idempotency key, %v\n", err)
+	}
+	return c.JSON(http.StatusOK, event)
+}
+
+// idempotencyRequestHash identifies the event of a request, so a reused idempotency key with another event is rejected.
+func idempotencyRequestHash(event Event) string {
+	sum := sha256.Sum256([]byte(encodeEvent(event)))
+	return hex.EncodeToString(sum[:])
+}
+
+// addEvent adds a device event with its device through AddBatch, so both are written together.
+func addEvent(ctx context.Context, streamService StreamService, stream string, event Event) (Event, error) {
+	if !strings.Contains(event.Kind, "Device") {
+		return streamService.Add(ctx, stream, event)
+	}
+	added, err := streamService.AddBatch(ctx, stream, []Event{event})
+	if err != nil {
+		return event, err
+	}
+	return added[0], nil
+}
+
+// AddEventsHandler adds an array of events atomically and returns them with the assigned ids in order.
+func AddEventsHandler(streamService StreamService) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		user := GetAuthorizedUser(c)
+		var events []Event
+		if err := c.Bind(&events); err != nil {
+			return c.String(http.StatusBadRequest, err.Error())
+		}
+		if len(events) == 0 {
+			return c.String(http.StatusBadRequest, "no events")
+		}
+		events, err := streamService.AddBatch(c.Request().Context(), user.Email, events)
+		if err != nil {
+			return c.String(http.StatusInternalServerError, err.Error())
+		}
+		return c.JSON(http.StatusOK, events)
+	}
+}
+
 func ReadEventsHandler(streamService StreamService) echo.HandlerFunc {
 	return func(c echo.Context) error {
 		user := GetAuthorizedUser(c)
+		if c.QueryParams().Has("before") {
+			return readEventsBefore(c, streamService, user.Email)
+		}
+		if c.QueryParams().Has("since") || c.QueryParams().Has("until") || c.QueryParams().Has("after") {
+			return readEventsBetween(c, streamService, user.Email)
+		}
 		lastId := c.QueryParam("lastId")
 		events, err := streamService.Read(c.Request().Context(), user.Email, lastId)
 		if err != nil {
 			return c.String(http.StatusInternalServerError, err.Error())
 		}
+		oldestId, truncated, err := historyTruncated(c.Request().Context(), streamService, user.Email, lastId)
+		if err != nil {
+			return c.String(http.StatusInternalServerError, err.Error())
+		}
+		if truncated {
+			c.Response().Header().Set("X-History-Truncated", "true")
+			c.Response().Header().Set("X-Oldest-Event-Id", oldestId)
+		}
 		c.Response().Header().Set("Cache-Control", "no-store")
 		return c.JSON(http.StatusOK, events)
 	}
 }
 
+// historyTruncated reports whether an event after lastId was deleted or trimmed,
+// and returns the id of the oldest retained event when it was.
+func historyTruncated(ctx context.Context, streamService StreamService, stream, lastId string) (string, bool, error) {
+	if lastId == "" || lastId == "0" {
+		return "", false, nil
+	}
+	after, err := parseStreamId(lastId)
+	if err != nil {
+		return "", false, err
+	}
+	maxDeletedId, err := streamService.MaxDeletedId(ctx, stream)
+	if err != nil || maxDeletedId == "" {
+		return "", false, err
+	}
+	maxDeleted, err := parseStreamId(maxDeletedId)
+	if err != nil || !after.Less(maxDeleted) {
+		return "", false, err
+	}
+	oldest, err := streamService.Range(ctx, stream, "-", "+", 1)
+	if err != nil || len(oldest) == 0 {
+		return "", true, err
+	}
+	return oldest[0].Id, true, nil
+}
+
+// GetEventHandler returns a single event with an ETag so devices can revalidate with If-None-Match.
+func GetEventHandler(streamService StreamService) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		user := GetAuthorizedUser(c)
+		if _, err := parseStreamId(c.Param("id")); err != nil {
+			return c.String(http.StatusBadRequest, err.Error())
+		}
+		event, err := streamService.Get(c.Request().Context(), user.Email, c.Param("id"))
+		if errors.Is(err, ErrEventNotFound) {
+			return c.String(http.StatusNotFound, err.Error())
+		}
+		if err != nil {
+			return c.String(http.StatusInternalServerError, err.Error())
+		}
+		body, err := json.Marshal(event)
+		if err != nil {
+			return c.String(http.StatusInternalServerError, err.Error())
+		}
+		etag := eventETag(body)
+		c.Response().Header().Set("ETag", etag)
+		c.Response().Header().Set("Cache-Control", "no-cache")
+		if etagMatches(c.Request().Header.Get("If-None-Match"), etag) {
+			return c.NoContent(http.StatusNotModified)
+		}
+		return c.JSONBlob(http.StatusOK, body)
+	}
+}
+
+func eventETag(body []byte) string {
+	sum := sha256.Sum256(body)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+func etagMatches(ifNoneMatch, etag string) bool {
+	for _, value := range strings.Split(ifNoneMatch, ",") {
+		value = strings.TrimPrefix(strings.TrimSpace(value), "W/")
+		if value == etag || value == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+const (
+	defaultPageLimit = 20
+	maxPageLimit     = 100
+)
+
+// EventsPage holds events newest first, Cursor is the before value of the next older page
+// and is empty when there are no older events.
+type EventsPage struct {
+	Events []Event
+	Cursor string
+}
+
+func readEventsBefore(c echo.Context, streamService StreamService, stream string) error {
+	end := "+"
+	if before := c.QueryParam("before"); before != "" {
+		beforeId, err := parseStreamId(before)
+		if err != nil {
+			return c.String(http.StatusBadRequest, err.Error())
+		}
+		if beforeId == (streamId{}) {
+			return c.JSON(http.StatusOK, EventsPage{Events: []Event{}})
+		}
+		end = beforeId.predecessor().String()
+	}
+	limit := int64(defaultPageLimit)
+	if value := c.QueryParam("limit"); value != "" {
+		parsed, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || parsed <= 0 {
+			return c.String(http.StatusBadRequest, fmt.Sprintf("invalid limit: %v", value))
+		}
+		limit = parsed
+		if limit > maxPageLimit {
+			limit = maxPageLimit
+		}
+	}
+	// one more event tells whether there is an older page
+	events, err := streamService.RevRange(c.Request().Context(), stream, end, "-", limit+1)
+	if err != nil {
+		return c.String(http.StatusInternalServerError, err.Error())
+	}
+	page := EventsPage{Events: events}
+	if int64(len(events)) > limit {
+		page.Events = events[:limit]
+		page.Cursor = page.Events[limit-1].Id
+	}
+	c.Response().Header().Set("Cache-Control", "no-store")
+	return c.JSON(http.StatusOK, page)
+}
+
+const maxTimeRangeLimit = 1000
+
+// EventsCount is the response of a time range query with count=true.
+type EventsCount struct {
+	Count int64
+}
+
+// readEventsBetween returns the events added from since until before until, both are unix seconds or RFC 3339 times.
+// With count=true it returns only the number of events in the period.
+// When more events are left than the limit, the X-Cursor header is the after value of the next page.
+func readEventsBetween(c echo.Context, streamService StreamService, stream string) error {
+	start, end := "-", "+"
+	if since := c.QueryParam("since"); since != "" {
+		t, err := parseTimeParam(since)
+		if err != nil {
+			return c.String(http.StatusBadRequest, err.Error())
+		}
+		start = streamId{Ms: uint64(t.UnixMilli())}.String()
+	}
+	if after := c.QueryParam("after"); after != "" {
+		afterId, err := parseStreamId(after)
+		if err != nil {
+			return c.String(http.StatusBadRequest, err.Error())
+		}
+		startId, _ := parseRangeStart(start)
+		if !afterId.Less(startId) {
+			start = afterId.successor().String()
+		}
+	}
+	if until := c.QueryParam("until"); until != "" {
+		t, err := parseTimeParam(until)
+		if err != nil {
+			return c.String(http.StatusBadRequest, err.Error())
+		}
+		if t.UnixMilli() <= 0 {
+			return c.String(http.StatusBadRequest, fmt.Sprintf("invalid until: %v", until))
+		}
+		// an id without sequence ends after every event of the millisecond
+		end = strconv.FormatInt(t.UnixMilli()-1, 10)
+	}
+	ctx := c.Request().Context()
+	c.Response().Header().Set("Cache-Control", "no-store")
+	if c.QueryParam("count") == "true" {
+		count, err := streamService.Count(ctx, stream, start, end)
+		if err != nil {
+			return c.String(http.StatusInternalServerError, err.Error())
+		}
+		return c.JSON(http.StatusOK, EventsCount{Count: count})
+	}
+	limit := int64(maxTimeRangeLimit)
+	if value := c.QueryParam("limit"); value != "" {
+		parsed, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || parsed <= 0 {
+			return c.String(http.StatusBadRequest, fmt.Sprintf("invalid limit: %v", value))
+		}
+		if parsed < limit {
+			limit = parsed
+		}
+	}
+	// one more event tells whether there is a next page
+	events, err := streamService.Range(ctx, stream, start, end, limit+1)
+	if err != nil {
+		return c.String(http.StatusInternalServerError, err.Error())
+	}
+	if int64(len(events)) > limit {
+		events = events[:limit]
+		c.Response().Header().Set("X-Cursor", events[limit-1].Id)
+	}
+	return c.JSON(http.StatusOK, events)
+}
+
+func parseTimeParam(value string) (time.Time, error) {
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		if seconds < 0 {
+			return time.Time{}, fmt.Errorf("invalid time: %v", value)
+		}
+		return time.Unix(seconds, 0), nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil || t.UnixMilli() < 0 {
+		return time.Time{}, fmt.Errorf("invalid time: %v", value)
+	}
+	return t, nil
+}
+
 func DeleteEventsHandler(streamService StreamService) echo.HandlerFunc {
 	type query struct {
 		Ids []string `query:"id"`
@@ -84,18 +388,3 @@ func GetDevicesHandler(streamService StreamService) echo.HandlerFunc {
 		return c.JSON(http.StatusOK, devices)
 	}
 }
-
-func handleDeviceEvent(ctx context.Context, streamService StreamService, stream string, event Event) error {
-	var device Device
-	err := json.Unmarshal([]byte(event.Payload), &device)
-	if err != nil {
-		return err
-	}
-	switch event.Kind {
-	case "DeviceAdded":
-		_, err = streamService.AddDevice(ctx, stream, device)
-	case "FirstDevice":
-		_, err = streamService.AddFirstDevice(ctx, stream, device)
-	}
-	return err
-}
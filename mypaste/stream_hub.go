@@ -0,0 +1,128 @@
+package mypaste
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	// hubReaderBlock bounds each blocking read of a shared reader, as a blocking redis read
+	// is not interrupted by cancelling its context and holds a pool connection until it returns.
+	hubReaderBlock = 5 * time.Second
+	// hubReaderIdle keeps a shared reader without waiters, so waiters coming back reuse it.
+	hubReaderIdle = 10 * time.Second
+)
+
+// streamHub runs a single blocking read per stream having waiting readers
+// and wakes them up when the stream has new events, instead of a blocking read per reader.
+type streamHub struct {
+	StreamService
+	readBlock   time.Duration
+	readerBlock time.Duration
+	readerIdle  time.Duration
+	notifier    *streamNotifier
+
+	mtx     sync.Mutex
+	readers map[string]*hubReader
+}
+
+type hubReader struct {
+	waiters int
+	cancel  context.CancelFunc
+	// idle stops the reader after hubReaderIdle without waiters
+	idle *time.Timer
+}
+
+var _ StreamService = (*streamHub)(nil)
+
+// NewStreamHub wraps streamService, readBlock is the time a Read of the hub waits for new events.
+func NewStreamHub(streamService StreamService, readBlock time.Duration) StreamService {
+	return &streamHub{
+		StreamService: streamService,
+		readBlock:     readBlock,
+		readerBlock:   hubReaderBlock,
+		readerIdle:    hubReaderIdle,
+		notifier:      newStreamNotifier(),
+		readers:       make(map[string]*hubReader),
+	}
+}
+
+func (h *streamHub) Read(ctx context.Context, stream, lastId string) ([]Event, error) {
+	events, err := h.StreamService.Read(withReadBlock(ctx, -1), stream, lastId)
+	if err != nil || len(events) > 0 || h.readBlock < 0 {
+		return events, err
+	}
+	h.join(stream, lastId)
+	defer h.leave(stream)
+	return readBlocking(ctx, h.readBlock, func() ([]Event, <-chan struct{}, error) {
+		updated := h.notifier.wait(stream)
+		events, err := h.StreamService.Read(withReadBlock(ctx, -1), stream, lastId)
+		return events, updated, err
+	})
+}
+
+// join starts the shared reader of the stream for the first waiter, or reuses an idle one.
+func (h *streamHub) join(stream, lastId string) {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+
+	reader, ok := h.readers[stream]
+	if !ok {
+		ctx, cancel := context.WithCancel(context.Background())
+		reader = &hubReader{cancel: cancel}
+		h.readers[stream] = reader
+		go h.read(ctx, stream, lastId)
+	}
+	if reader.idle != nil {
+		reader.idle.Stop()
+		reader.idle = nil
+	}
+	reader.waiters++
+}
+
+// leave stops the shared reader of the stream when no waiter joined within readerIdle after the last one left.
+func (h *streamHub) leave(stream string) {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+
+	reader := h.readers[stream]
+	reader.waiters--
+	if reader.waiters > 0 {
+		return
+	}
+	reader.idle = time.AfterFunc(h.readerIdle, func() {
+		h.mtx.Lock()
+		defer h.mtx.Unlock()
+		if h.readers[stream] == reader && reader.waiters == 0 {
+			reader.cancel()
+			delete(h.readers, stream)
+		}
+	})
+}
+
+// read starts after the lastId of the first waiter, which had no events after it,
+// so every event read is new to all waiters. Waiters joining later read their own
+// events after a notification, so the reader only has to see every new event.
+func (h *streamHub) read(ctx context.Context, stream, lastId string) {
+	for {
+		events, err := h.StreamService.Read(withReadBlock(ctx, h.readerBlock), stream, lastId)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			fmt.Printf("failed to read stream: %v, %v\n", stream, err)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(time.Second):
+			}
+			continue
+		}
+		if len(events) > 0 {
+			lastId = events[len(events)-1].Id
+			h.notifier.notify(stream)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package mypaste
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// countingStreamService counts the blocking reads of the wrapped StreamService.
+type countingStreamService struct {
+	StreamService
+	blockingReads atomic.Int64
+}
+
+func (s *countingStreamService) Read(ctx context.Context, stream, lastId string) ([]Event, error) {
+	if readBlockOf(ctx, 0) >= 0 {
+		s.blockingReads.Add(1)
+	}
+	return s.StreamService.Read(ctx, stream, lastId)
+}
+
+func TestStreamHubSharesRead(t *testing.T) {
+	inner := &countingStreamService{StreamService: NewMemoryStreamService(StreamConfig{ReadCount: 10, ReadBlock: time.Minute})}
+	hub := NewStreamHub(inner, time.Minute).(*streamHub)
+	ctx := context.Background()
+	last, err := hub.Add(ctx, "email", Event{Payload: "hello 1"})
+	require.NoError(t, err)
+
+	var wg sync.WaitGroup
+	results := make([][]Event, 10)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			events, err := hub.Read(ctx, "email", last.Id)
+			assert.NoError(t, err)
+			results[i] = events
+		}(i)
+	}
+	require.Eventually(t, func() bool { return hubWaitingT(hub, "email") == len(results) }, time.Second, time.Millisecond)
+	require.Eventually(t, func() bool { return inner.blockingReads.Load() == 1 }, time.Second, time.Millisecond)
+	time.Sleep(10 * time.Millisecond)
+	assert.Equal(t, int64(1), inner.blockingReads.Load(), "waiters should share one blocking read")
+
+	event, err := hub.Add(ctx, "email", Event{Payload: "hello 2"})
+	require.NoError(t, err)
+	wg.Wait()
+	for _, events := range results {
+		assert.Equal(t, []Event{event}, events)
+	}
+	assert.Equal(t, 0, hubWaitingT(hub, "email"), "reader should stop after the last waiter")
+}
+
+func TestStreamHubCleanupOnCancel(t *testing.T) {
+	inner := NewMemoryStreamService(StreamConfig{ReadCount: 10, ReadBlock: time.Minute})
+	hub := NewStreamHub(inner, time.Minute).(*streamHub)
+	ctx, cancel := context.WithCancel(context.Background())
+	errCh := make(chan error)
+	for i := 0; i < 3; i++ {
+		go func() {
+			_, err := hub.Read(ctx, "email", "")
+			errCh <- err
+		}()
+	}
+	require.Eventually(t, func() bool { return hubWaitingT(hub, "email") == 3 }, time.Second, time.Millisecond)
+	cancel()
+	for i := 0; i < 3; i++ {
+		assert.ErrorIs(t, <-errCh, context.Canceled)
+	}
+	assert.Equal(t, 0, hubWaitingT(hub, "email"))
+}
+
+func TestStreamHubReadsAvailableEventsWithoutWaiting(t *testing.T) {
+	inner := &countingStreamService{StreamService: NewMemoryStreamService(StreamConfig{ReadCount: 10, ReadBlock: time.Minute})}
+	hub := NewStreamHub(inner, time.Minute)
+	event, err := hub.Add(context.Background(), "email", Event{Payload: "hello"})
+	require.NoError(t, err)
+	events, err := hub.Read(context.Background(), "email", "")
+	require.NoError(t, err)
+	assert.Equal(t, []Event{event}, events)
+	assert.Equal(t, int64(0), inner.blockingReads.Load())
+}
+
+func TestStreamHubRedisReaderCleanup(t *testing.T) {
+	rclient := redis.NewClient(&redis.Options{Addr: miniredis.RunT(t).Addr(), ContextTimeoutEnabled: true})
+	hub := NewStreamHub(NewRedisStreamService(rclient, StreamConfig{ReadCount: 10, ReadBlock: time.Minute}), time.Minute).(*streamHub)
+	hub.readerBlock = 50 * time.Millisecond
+	hub.readerIdle = 100 * time.Millisecond
+
+	// waiters coming and going reuse the idle reader
+	for i := 0; i < 5; i++ {
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		go func() {
+			hub.Read(ctx, "email", "")
+			close(done)
+		}()
+		require.Eventually(t, func() bool { return hubWaitingT(hub, "email") == 1 }, time.Second, time.Millisecond)
+		cancel()
+		<-done
+	}
+	assert.LessOrEqual(t, rclient.PoolStats().TotalConns, uint32(2), "should not hold a connection per cancelled reader")
+
+	// the idle reader stops and its blocking read returns the connection
+	require.Eventually(t, func() bool {
+		hub.mtx.Lock()
+		defer hub.mtx.Unlock()
+		return len(hub.readers) == 0
+	}, time.Second, time.Millisecond)
+	require.Eventually(t, func() bool {
+		stats := rclient.PoolStats()
+		return stats.IdleConns == stats.TotalConns
+	}, time.Second, time.Millisecond)
+	assert.LessOrEqual(t, rclient.PoolStats().TotalConns, uint32(2))
+}
+
+func hubWaitingT(h *streamHub, stream string) int {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+
+	if reader, ok := h.readers[stream]; ok {
+		return reader.waiters
+	}
+	return 0
+}
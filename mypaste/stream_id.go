@@ -0,0 +1,95 @@
+package mypaste
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// streamId mirrors the redis stream entry id format "<milliseconds>-<sequence>"
+// so that every StreamService hands out ids the webapp can compare the same way.
+type streamId struct {
+	Ms  uint64
+	Seq uint64
+}
+
+func parseStreamId(id string) (streamId, error) {
+	msPart, seqPart, hasSeq := strings.Cut(id, "-")
+	ms, err := strconv.ParseUint(msPart, 10, 64)
+	if err != nil {
+		return streamId{}, fmt.Errorf("invalid stream id: %v", id)
+	}
+	if !hasSeq {
+		return streamId{Ms: ms}, nil
+	}
+	seq, err := strconv.ParseUint(seqPart, 10, 64)
+	if err != nil {
+		return streamId{}, fmt.Errorf("invalid stream id: %v", id)
+	}
+	return streamId{Ms: ms, Seq: seq}, nil
+}
+
+// maxStreamId stays within int64 so it can be stored in sql integer columns.
+var maxStreamId = streamId{Ms: math.MaxInt64, Seq: math.MaxInt64}
+
+// parseRangeStart parses the start of an inclusive range like redis XRANGE, "-" is the smallest id.
+func parseRangeStart(id string) (streamId, error) {
+	if id == "-" {
+		return streamId{}, nil
+	}
+	return parseStreamId(id)
+}
+
+// parseRangeEnd parses the end of an inclusive range like redis XRANGE, "+" is the greatest id
+// and an id without sequence includes every sequence of that millisecond.
+func parseRangeEnd(id string) (streamId, error) {
+	if id == "+" {
+		return maxStreamId, nil
+	}
+	parsed, err := parseStreamId(id)
+	if err != nil {
+		return parsed, err
+	}
+	if !strings.Contains(id, "-") {
+		parsed.Seq = maxStreamId.Seq
+	}
+	return parsed, nil
+}
+
+func (id streamId) String() string {
+	return fmt.Sprintf("%d-%d", id.Ms, id.Seq)
+}
+
+func (id streamId) Less(other streamId) bool {
+	if id.Ms != other.Ms {
+		return id.Ms < other.Ms
+	}
+	return id.Seq < other.Seq
+}
+
+// successor returns the smallest id greater than id.
+func (id streamId) successor() streamId {
+	if id.Seq == maxStreamId.Seq {
+		return streamId{Ms: id.Ms + 1}
+	}
+	return streamId{Ms: id.Ms, Seq: id.Seq + 1}
+}
+
+// predecessor returns the greatest id less than id, the zero id has no predecessor.
+func (id streamId) predecessor() streamId {
+	if id.Seq > 0 {
+		return streamId{Ms: id.Ms, Seq: id.Seq - 1}
+	}
+	return streamId{Ms: id.Ms - 1, Seq: maxStreamId.Seq}
+}
+
+// nextStreamId returns the id for a new entry added at now after lastId.
+func nextStreamId(lastId streamId, now time.Time) streamId {
+	ms := uint64(now.UnixMilli())
+	if ms > lastId.Ms {
+		return streamId{Ms: ms}
+	}
+	return streamId{Ms: lastId.Ms, Seq: lastId.Seq + 1}
+}
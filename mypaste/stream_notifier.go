@@ -0,0 +1,87 @@
+package mypaste
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+type streamNotifier struct {
+	mtx     sync.Mutex
+	waiters map[string]chan struct{}
+}
+
+func newStreamNotifier() *streamNotifier {
+	return &streamNotifier{waiters: make(map[string]chan struct{})}
+}
+
+func (n *streamNotifier) wait(stream string) <-chan struct{} {
+	n.mtx.Lock()
+	defer n.mtx.Unlock()
+
+	ch, ok := n.waiters[stream]
+	if !ok {
+		ch = make(chan struct{})
+		n.waiters[stream] = ch
+	}
+	return ch
+}
+
+func (n *streamNotifier) notify(stream string) {
+	n.mtx.Lock()
+	defer n.mtx.Unlock()
+
+	if ch, ok := n.waiters[stream]; ok {
+		close(ch)
+		delete(n.waiters, stream)
+	}
+}
+
+func (n *streamNotifier) notifyAll() {
+	n.mtx.Lock()
+	defer n.mtx.Unlock()
+
+	for stream, ch := range n.waiters {
+		close(ch)
+		delete(n.waiters, stream)
+	}
+}
+
+// readBlocking calls read until it returns events, the block duration passes
+// or ctx is done. read returns a channel that is closed once the stream changes.
+// Like redis XREAD BLOCK, a zero block waits forever and a negative block does not wait.
+func readBlocking(ctx context.Context, block time.Duration, read func() ([]Event, <-chan struct{}, error)) ([]Event, error) {
+	var timeout <-chan time.Time
+	if block > 0 {
+		timer := time.NewTimer(block)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	for {
+		events, updated, err := read()
+		if err != nil || len(events) > 0 || block < 0 {
+			return events, err
+		}
+		select {
+		case <-updated:
+		case <-timeout:
+			return events, nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+type readBlockKey struct{}
+
+// withReadBlock overrides the ReadBlock of the StreamService config for a Read with the returned context.
+func withReadBlock(ctx context.Context, block time.Duration) context.Context {
+	return context.WithValue(ctx, readBlockKey{}, block)
+}
+
+func readBlockOf(ctx context.Context, block time.Duration) time.Duration {
+	if override, ok := ctx.Value(readBlockKey{}).(time.Duration); ok {
+		return override
+	}
+	return block
+}
@@ -3,36 +3,199 @@ package mypaste
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/redis/go-redis/v9"
 )
 
 type StreamService interface {
 	Add(ctx context.Context, stream string, event Event) (Event, error)
+	// AddBatch adds events atomically and returns them with the assigned ids in order,
+	// the devices of DeviceAdded and FirstDevice events are added in the same transaction.
+	AddBatch(ctx context.Context, stream string, events []Event) ([]Event, error)
 	Read(ctx context.Context, stream, lastId string) ([]Event, error)
+	// Get returns the event with the given id or ErrEventNotFound.
+	Get(ctx context.Context, stream, id string) (Event, error)
 	Delete(ctx context.Context, stream string, ids ...string) (int64, error)
+	// Expire is Delete for the events removed by retention, the greatest id is recorded like Trim.
+	Expire(ctx context.Context, stream string, ids ...string) (int64, error)
 	Reset(ctx context.Context, stream string) error
+	Len(ctx context.Context, stream string) (int64, error)
+	Range(ctx context.Context, stream, start, end string, count int64) ([]Event, error)
+	// RevRange is Range in reverse order, newest first, like redis XREVRANGE.
+	RevRange(ctx context.Context, stream, end, start string, count int64) ([]Event, error)
+	// Count returns the number of events between start and end inclusive.
+	Count(ctx context.Context, stream, start, end string) (int64, error)
+	Trim(ctx context.Context, stream, minId string) (int64, error)
+	// MaxDeletedId returns the greatest id trimmed or expired from the stream since it was reset,
+	// or an empty string when no event was removed. Events removed by Delete are not recorded.
+	MaxDeletedId(ctx context.Context, stream string) (string, error)
+	// Import adds events keeping their ids and timestamps,
+	// events not after the last id of the stream are skipped so an import can be repeated.
+	Import(ctx context.Context, stream string, events []Event) (int64, error)
+	// Streams returns the names of all streams having events or devices.
+	Streams(ctx context.Context) ([]string, error)
 	AddDevice(ctx context.Context, stream string, device Device) (Device, error)
 	AddFirstDevice(ctx context.Context, stream string, device Device) (Device, error)
 	GetDevices(ctx context.Context, stream string) ([]Device, error)
+	// Close stops background work and releases the connections of the store.
+	Close() error
 }
 
-type RedisStreamConfig struct {
+// SessionStore keeps the login sessions of the streams.
+type SessionStore interface {
+	// SetSession adds or updates a session of the stream, expired sessions are removed.
+	SetSession(ctx context.Context, stream string, session Session) error
+	// UpdateSession returns ErrSessionNotFound instead of adding the session again if it was revoked.
+	UpdateSession(ctx context.Context, stream string, session Session) error
+	// GetSession returns the unexpired session with the given id or ErrSessionNotFound.
+	GetSession(ctx context.Context, stream, id string) (Session, error)
+	// GetSessions returns the unexpired sessions of the stream ordered by creation.
+	GetSessions(ctx context.Context, stream string) ([]Session, error)
+	// DeleteSession returns ErrSessionNotFound if the stream has no session with the id.
+	DeleteSession(ctx context.Context, stream, id string) error
+	// DeleteSessions revokes all sessions of the stream.
+	DeleteSessions(ctx context.Context, stream string) error
+}
+
+// ApiTokenStore keeps the api tokens of the streams.
+type ApiTokenStore interface {
+	AddApiToken(ctx context.Context, stream string, token ApiToken) error
+	// GetApiTokens returns the tokens of the stream ordered by creation.
+	GetApiTokens(ctx context.Context, stream string) ([]ApiToken, error)
+	// GetApiToken returns the token with the given hash or ErrApiTokenNotFound.
+	GetApiToken(ctx context.Context, hash string) (ApiToken, error)
+	// DeleteApiToken returns ErrApiTokenNotFound if the stream has no token with the id.
+	DeleteApiToken(ctx context.Context, stream, id string) error
+}
+
+// IdempotencyStore keeps the Idempotency-Keys of the events added to the streams.
+type IdempotencyStore interface {
+	// ReserveIdempotencyKey claims key for ttl with the hash of the request and reports whether it was claimed,
+	// otherwise it returns the recorded key, its EventId is empty while the first request is in progress.
+	ReserveIdempotencyKey(ctx context.Context, stream, key, requestHash string, ttl time.Duration) (IdempotencyKey, bool, error)
+	// SetIdempotencyKey records the event added for a reserved key.
+	SetIdempotencyKey(ctx context.Context, stream, key string, record IdempotencyKey, ttl time.Duration) error
+	DeleteIdempotencyKey(ctx context.Context, stream, key string) error
+}
+
+// Store is a backend keeping the sessions, api tokens and idempotency keys beside the events,
+// decorators like NewArchivingStreamService and NewStreamHub wrap only its StreamService.
+type Store interface {
+	StreamService
+	SessionStore
+	ApiTokenStore
+	IdempotencyStore
+}
+
+// IdempotencyKey is recorded for an Idempotency-Key of a stream.
+type IdempotencyKey struct {
+	// RequestHash identifies the request first sent with the key, it is empty for keys recorded before it was kept.
+	RequestHash string
+	EventId     string
+}
+
+var (
+	ErrEventNotFound    = errors.New("event not found")
+	ErrApiTokenNotFound = errors.New("api token not found")
+	ErrSessionNotFound  = errors.New("session not found")
+)
+
+// batchDevice is a device added by an event of a batch.
+type batchDevice struct {
+	Device
+	// first fails the batch if the stream already has a device
+	first bool
+}
+
+// batchDevices parses the devices of the DeviceAdded and FirstDevice events before a batch is written,
+// a FirstDevice event must come before any other device of the batch.
+func batchDevices(stream string, events []Event) ([]batchDevice, error) {
+	devices := make([]batchDevice, 0)
+	for _, event := range events {
+		if !strings.Contains(event.Kind, "Device") {
+			continue
+		}
+		var device Device
+		if err := json.Unmarshal([]byte(event.Payload), &device); err != nil {
+			return nil, fmt.Errorf("invalid device event payload, %w", err)
+		}
+		switch event.Kind {
+		case "DeviceAdded":
+			devices = append(devices, batchDevice{Device: device})
+		case "FirstDevice":
+			if len(devices) > 0 {
+				return nil, errDeviceExists(stream)
+			}
+			devices = append(devices, batchDevice{Device: device, first: true})
+		}
+	}
+	return devices, nil
+}
+
+func errDeviceExists(stream string) error {
+	return fmt.Errorf("device already exists for stream: %v", stream)
+}
+
+type StreamConfig struct {
 	MaxLen    int64
 	ReadCount int64
 	ReadBlock time.Duration
 }
 
+// OpenStreamService picks the Store implementation by the scheme of storeUrl,
+// eg. redis://localhost:6379, sqlite:///var/lib/mypaste.db or postgres://localhost:5432/mypaste.
+// An empty storeUrl gives an in-memory stream service.
+func OpenStreamService(storeUrl string, config StreamConfig) (Store, error) {
+	if storeUrl == "" {
+		return NewMemoryStreamService(config), nil
+	}
+	u, err := url.Parse(storeUrl)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse store url, %w", err)
+	}
+	switch u.Scheme {
+	case "redis", "rediss", "redis+sentinel", "rediss+sentinel", "redis+cluster", "rediss+cluster":
+		client, err := NewRedisClient(storeUrl)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse redis url, %w", err)
+		}
+		return NewRedisStreamService(client, config), nil
+	case "sqlite":
+		db, err := OpenSqliteDB(u.Host + u.Path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open sqlite db, %w", err)
+		}
+		return NewSqliteStreamService(db, config)
+	case "postgres", "postgresql":
+		pool, err := pgxpool.New(context.Background(), storeUrl)
+		if err != nil {
+			return nil, fmt.Errorf("failed to connect postgres, %w", err)
+		}
+		svc, err := NewPostgresStreamService(context.Background(), pool, config)
+		if err != nil {
+			pool.Close()
+		}
+		return svc, err
+	}
+	return nil, fmt.Errorf("unsupported store url scheme: %v", u.Scheme)
+}
+
 type redisStreamService struct {
-	client *redis.Client
-	config RedisStreamConfig
+	client redis.UniversalClient
+	config StreamConfig
 }
 
-var _ StreamService = (*redisStreamService)(nil)
+var _ Store = (*redisStreamService)(nil)
 
-func NewRedisStreamService(client *redis.Client, config RedisStreamConfig) StreamService {
+func NewRedisStreamService(client redis.UniversalClient, config StreamConfig) Store {
 	return &redisStreamService{
 		client: client,
 		config: config,
@@ -41,70 +204,545 @@ func NewRedisStreamService(client *redis.Client, config RedisStreamConfig) Strea
 
 func (s *redisStreamService) Add(ctx context.Context, stream string, event Event) (Event, error) {
 	event.Timestamp = time.Now().Unix()
-	id, err := s.client.XAdd(ctx, &redis.XAddArgs{
-		Stream: s.eventsKey(stream),
-		Values: s.eventValues(event),
-		MaxLen: s.config.MaxLen,
-		Approx: true,
-	}).Result()
+	var cmd *redis.StringCmd
+	_, err := s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		cmd = pipe.XAdd(ctx, &redis.XAddArgs{
+			Stream: s.eventsKey(stream),
+			Values: s.eventValues(event),
+		})
+		s.trimMaxLen(ctx, pipe, stream)
+		return nil
+	})
 	if err != nil {
 		return event, err
 	}
-	event.Id = id
+	event.Id = cmd.Val()
 	return event, nil
 }
 
-func (s *redisStreamService) Read(ctx context.Context, stream, lastId string) ([]Event, error) {
-	if lastId == "" {
-		lastId = "0"
+func (s *redisStreamService) AddBatch(ctx context.Context, stream string, events []Event) ([]Event, error) {
+	if len(events) == 0 {
+		return []Event{}, nil
 	}
-	res, err := s.client.XRead(ctx, &redis.XReadArgs{
-		Streams: []string{s.eventsKey(stream), lastId},
-		Count:   s.config.ReadCount,
-		Block:   s.config.ReadBlock,
-	}).Result()
-	if err == redis.Nil {
-		return s.toEvents(nil), nil
+	devices, err := batchDevices(stream, events)
+	if err != nil {
+		return nil, err
+	}
+	added := make([]Event, len(events))
+	cmds := make([]*redis.StringCmd, len(events))
+	addBatch := func(tx redis.Cmdable) error {
+		_, err := tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			for _, device := range devices {
+				pipe.HSet(ctx, s.devicesKey(stream), device.Id, device.Description)
+			}
+			for i, event := range events {
+				event.Timestamp = time.Now().Unix()
+				added[i] = event
+				cmds[i] = pipe.XAdd(ctx, &redis.XAddArgs{
+					Stream: s.eventsKey(stream),
+					Values: s.eventValues(event),
+				})
+			}
+			s.trimMaxLen(ctx, pipe, stream)
+			return nil
+		})
+		return err
+	}
+	if len(devices) > 0 && devices[0].first {
+		devicesKey := s.devicesKey(stream)
+		err = s.client.Watch(ctx, func(tx *redis.Tx) error {
+			count, err := tx.HLen(ctx, devicesKey).Result()
+			if err != nil {
+				return err
+			}
+			if count > 0 {
+				return errDeviceExists(stream)
+			}
+			return addBatch(tx)
+		}, devicesKey)
+	} else {
+		err = addBatch(s.client)
 	}
 	if err != nil {
 		return nil, err
 	}
-	return s.toEvents(res[0].Messages), nil
+	for i, cmd := range cmds {
+		added[i].Id = cmd.Val()
+	}
+	return added, nil
+}
+
+func (s *redisStreamService) Read(ctx context.Context, stream, lastId string) ([]Event, error) {
+	if lastId == "" {
+		lastId = "0"
+	}
+	for {
+		res, err := s.client.XRead(ctx, &redis.XReadArgs{
+			Streams: []string{s.eventsKey(stream), lastId},
+			Count:   s.config.ReadCount,
+			Block:   readBlockOf(ctx, s.config.ReadBlock),
+		}).Result()
+		if err == redis.Nil {
+			return s.toEvents(stream, nil), nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		messages := res[0].Messages
+		events := s.toEvents(stream, messages)
+		if len(events) > 0 || len(messages) == 0 {
+			return events, nil
+		}
+		// only corrupt events were read, continue after them so they cannot hold back the events after them
+		lastId = messages[len(messages)-1].ID
+	}
 }
 
-func (s *redisStreamService) toEvents(messages []redis.XMessage) []Event {
+func (s *redisStreamService) toEvents(stream string, messages []redis.XMessage) []Event {
 	events := make([]Event, 0, len(messages))
 	for _, m := range messages {
-		events = append(events, s.toEvent(m))
+		if event, ok := s.toEvent(stream, m); ok {
+			events = append(events, event)
+		}
 	}
 	return events
 }
 
 func (s *redisStreamService) eventValues(event Event) map[string]interface{} {
-	jsonValue, _ := json.Marshal(event)
 	return map[string]interface{}{
-		"Json": string(jsonValue),
+		"V":    eventEncodingVersion,
+		"Json": encodeEvent(event),
+	}
+}
+
+func (s *redisStreamService) toEvent(stream string, message redis.XMessage) (Event, bool) {
+	version := 1
+	if v, ok := message.Values["V"].(string); ok {
+		var err error
+		if version, err = strconv.Atoi(v); err != nil {
+			reportCorruptEvent(&CorruptEventError{Stream: stream, Id: message.ID, Err: err})
+			return Event{}, false
+		}
+	}
+	jsonValue, ok := message.Values["Json"].(string)
+	if !ok {
+		reportCorruptEvent(&CorruptEventError{Stream: stream, Id: message.ID, Err: fmt.Errorf("missing Json field")})
+		return Event{}, false
 	}
+	return decodeStoredEvent(stream, message.ID, version, jsonValue)
 }
 
-func (s *redisStreamService) toEvent(message redis.XMessage) Event {
-	var event Event
-	if jsonValue, ok := message.Values["Json"].(string); ok {
-		json.Unmarshal([]byte(jsonValue), &event)
+func (s *redisStreamService) Get(ctx context.Context, stream, id string) (Event, error) {
+	parsed, err := parseStreamId(id)
+	if err != nil {
+		return Event{}, err
+	}
+	res, err := s.client.XRangeN(ctx, s.eventsKey(stream), parsed.String(), parsed.String(), 1).Result()
+	if err != nil {
+		return Event{}, err
+	}
+	events := s.toEvents(stream, res)
+	if len(events) == 0 {
+		return Event{}, ErrEventNotFound
 	}
-	event.Id = message.ID
-	return event
+	return events[0], nil
 }
 
 func (s *redisStreamService) Delete(ctx context.Context, stream string, ids ...string) (int64, error) {
-	return s.client.XDel(ctx, s.eventsKey(stream), ids...).Result()
+	parsed, err := parseStreamIds(ids)
+	if err != nil {
+		return 0, err
+	}
+	return s.client.XDel(ctx, s.eventsKey(stream), parsed...).Result()
+}
+
+func (s *redisStreamService) Expire(ctx context.Context, stream string, ids ...string) (int64, error) {
+	parsed, err := parseStreamIds(ids)
+	if err != nil {
+		return 0, err
+	}
+	args := make([]interface{}, len(parsed))
+	for i, id := range parsed {
+		args[i] = id
+	}
+	return redisExpireScript.Run(ctx, s.client, []string{s.eventsKey(stream), s.maxDeletedKey(stream)}, args...).Int64()
+}
+
+// parseStreamIds validates ids and returns them in their canonical form.
+func parseStreamIds(ids []string) ([]string, error) {
+	parsed := make([]string, len(ids))
+	for i, id := range ids {
+		p, err := parseStreamId(id)
+		if err != nil {
+			return nil, err
+		}
+		parsed[i] = p.String()
+	}
+	return parsed, nil
 }
 
 func (s *redisStreamService) Reset(ctx context.Context, stream string) error {
-	_, err := s.client.Del(ctx, s.eventsKey(stream)).Result()
+	_, err := s.client.Del(ctx, s.eventsKey(stream), s.maxDeletedKey(stream)).Result()
+	return err
+}
+
+func (s *redisStreamService) Len(ctx context.Context, stream string) (int64, error) {
+	return s.client.XLen(ctx, s.eventsKey(stream)).Result()
+}
+
+func (s *redisStreamService) Range(ctx context.Context, stream, start, end string, count int64) ([]Event, error) {
+	var res []redis.XMessage
+	var err error
+	if count > 0 {
+		res, err = s.client.XRangeN(ctx, s.eventsKey(stream), start, end, count).Result()
+	} else {
+		res, err = s.client.XRange(ctx, s.eventsKey(stream), start, end).Result()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s.toEvents(stream, res), nil
+}
+
+func (s *redisStreamService) RevRange(ctx context.Context, stream, end, start string, count int64) ([]Event, error) {
+	var res []redis.XMessage
+	var err error
+	if count > 0 {
+		res, err = s.client.XRevRangeN(ctx, s.eventsKey(stream), end, start, count).Result()
+	} else {
+		res, err = s.client.XRevRange(ctx, s.eventsKey(stream), end, start).Result()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s.toEvents(stream, res), nil
+}
+
+// Count pages through the range as redis has no command to count part of a stream.
+func (s *redisStreamService) Count(ctx context.Context, stream, start, end string) (int64, error) {
+	var count int64
+	for {
+		res, err := s.client.XRangeN(ctx, s.eventsKey(stream), start, end, redisCountPageSize).Result()
+		if err != nil {
+			return 0, err
+		}
+		count += int64(len(res))
+		if len(res) < redisCountPageSize {
+			return count, nil
+		}
+		lastId, err := parseStreamId(res[len(res)-1].ID)
+		if err != nil {
+			return 0, err
+		}
+		start = lastId.successor().String()
+	}
+}
+
+func (s *redisStreamService) Trim(ctx context.Context, stream, minId string) (int64, error) {
+	min, err := parseStreamId(minId)
+	if err != nil {
+		return 0, err
+	}
+	if min == (streamId{}) {
+		return 0, nil
+	}
+	keys := []string{s.eventsKey(stream), s.maxDeletedKey(stream)}
+	return redisTrimMinIdScript.Run(ctx, s.client, keys, min.String(), min.predecessor().String()).Int64()
+}
+
+// trimMaxLen trims the stream to MaxLen exactly, unlike XADD MAXLEN, so the greatest trimmed id can be recorded.
+func (s *redisStreamService) trimMaxLen(ctx context.Context, pipe redis.Pipeliner, stream string) {
+	if s.config.MaxLen > 0 {
+		redisTrimMaxLenScript.Eval(ctx, pipe, []string{s.eventsKey(stream), s.maxDeletedKey(stream)}, s.config.MaxLen)
+	}
+}
+
+func (s *redisStreamService) MaxDeletedId(ctx context.Context, stream string) (string, error) {
+	id, err := s.client.Get(ctx, s.maxDeletedKey(stream)).Result()
+	if err == redis.Nil {
+		return "", nil
+	}
+	return id, err
+}
+
+func (s *redisStreamService) Import(ctx context.Context, stream string, events []Event) (int64, error) {
+	cmds, err := s.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, event := range events {
+			pipe.XAdd(ctx, &redis.XAddArgs{
+				Stream: s.eventsKey(stream),
+				ID:     event.Id,
+				Values: s.eventValues(event),
+			})
+		}
+		return nil
+	})
+	var count int64
+	for _, cmd := range cmds {
+		if cmd.Err() == nil {
+			count++
+		} else if !isRedisIdTooSmall(cmd.Err()) {
+			return count, cmd.Err()
+		}
+	}
+	if err != nil && !isRedisIdTooSmall(err) {
+		return count, err
+	}
+	_, err = s.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		s.trimMaxLen(ctx, pipe, stream)
+		return nil
+	})
+	return count, err
+}
+
+func isRedisIdTooSmall(err error) bool {
+	return strings.Contains(err.Error(), "equal or smaller than the target stream top item")
+}
+
+func (s *redisStreamService) Streams(ctx context.Context) ([]string, error) {
+	found := make(map[string]bool)
+	for _, prefix := range []string{redisEventKeyPrefix, redisDeviceKeyPrefix} {
+		err := scanRedisKeys(ctx, s.client, prefix+"{*}", func(key string) {
+			stream := strings.TrimPrefix(key, prefix)
+			found[stream[1:len(stream)-1]] = true
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return sortedKeys(found), nil
+}
+
+func sortedKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (s *redisStreamService) ReserveIdempotencyKey(ctx context.Context, stream, key, requestHash string, ttl time.Duration) (IdempotencyKey, bool, error) {
+	record := IdempotencyKey{RequestHash: requestHash}
+	value, err := json.Marshal(record)
+	if err != nil {
+		return record, false, err
+	}
+	reserved, err := s.client.SetNX(ctx, s.idempotencyKey(stream, key), value, ttl).Result()
+	if err != nil || reserved {
+		return record, reserved, err
+	}
+	existing, err := s.client.Get(ctx, s.idempotencyKey(stream, key)).Result()
+	if err == redis.Nil {
+		// expired in between, the caller retries later
+		return IdempotencyKey{}, false, nil
+	}
+	if err != nil {
+		return IdempotencyKey{}, false, err
+	}
+	if err := json.Unmarshal([]byte(existing), &record); err != nil {
+		// recorded before the request hash was kept, the value is the event id
+		return IdempotencyKey{EventId: existing}, false, nil
+	}
+	return record, false, nil
+}
+
+func (s *redisStreamService) SetIdempotencyKey(ctx context.Context, stream, key string, record IdempotencyKey, ttl time.Duration) error {
+	value, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	return s.client.Set(ctx, s.idempotencyKey(stream, key), value, ttl).Err()
+}
+
+func (s *redisStreamService) DeleteIdempotencyKey(ctx context.Context, stream, key string) error {
+	return s.client.Del(ctx, s.idempotencyKey(stream, key)).Err()
+}
+
+func (s *redisStreamService) AddApiToken(ctx context.Context, stream string, token ApiToken) error {
+	value, err := json.Marshal(token)
+	if err != nil {
+		return err
+	}
+	// the token key is not hash tagged by stream, it is looked up by hash alone
+	if err := s.client.Set(ctx, s.apiTokenKey(token.Hash), value, 0).Err(); err != nil {
+		return err
+	}
+	return s.client.HSet(ctx, s.apiTokensKey(stream), token.Id, token.Hash).Err()
+}
+
+func (s *redisStreamService) GetApiTokens(ctx context.Context, stream string) ([]ApiToken, error) {
+	hashes, err := s.client.HGetAll(ctx, s.apiTokensKey(stream)).Result()
+	if err != nil && err != redis.Nil {
+		return nil, err
+	}
+	tokens := make([]ApiToken, 0, len(hashes))
+	for _, hash := range hashes {
+		token, err := s.GetApiToken(ctx, hash)
+		if err == ErrApiTokenNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, token)
+	}
+	sortApiTokens(tokens)
+	return tokens, nil
+}
+
+func (s *redisStreamService) GetApiToken(ctx context.Context, hash string) (ApiToken, error) {
+	value, err := s.client.Get(ctx, s.apiTokenKey(hash)).Result()
+	if err == redis.Nil {
+		return ApiToken{}, ErrApiTokenNotFound
+	}
+	if err != nil {
+		return ApiToken{}, err
+	}
+	return decodeApiToken(hash, value)
+}
+
+func (s *redisStreamService) DeleteApiToken(ctx context.Context, stream, id string) error {
+	hash, err := s.client.HGet(ctx, s.apiTokensKey(stream), id).Result()
+	if err == redis.Nil {
+		return ErrApiTokenNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if err := s.client.Del(ctx, s.apiTokenKey(hash)).Err(); err != nil {
+		return err
+	}
+	return s.client.HDel(ctx, s.apiTokensKey(stream), id).Err()
+}
+
+func decodeApiToken(hash, value string) (ApiToken, error) {
+	var token ApiToken
+	if err := json.Unmarshal([]byte(value), &token); err != nil {
+		return ApiToken{}, fmt.Errorf("failed to decode api token, %w", err)
+	}
+	token.Hash = hash
+	return token, nil
+}
+
+func (s *redisStreamService) SetSession(ctx context.Context, stream string, session Session) error {
+	values, err := s.client.HGetAll(ctx, s.sessionsKey(stream)).Result()
+	if err != nil && err != redis.Nil {
+		return err
+	}
+	now := time.Now().Unix()
+	for id, value := range values {
+		if existing, err := decodeSession(value); err != nil || existing.ExpiresAt <= now {
+			s.client.HDel(ctx, s.sessionsKey(stream), id)
+		}
+	}
+	value, err := json.Marshal(session)
+	if err != nil {
+		return err
+	}
+	return s.client.HSet(ctx, s.sessionsKey(stream), session.Id, value).Err()
+}
+
+func (s *redisStreamService) UpdateSession(ctx context.Context, stream string, session Session) error {
+	value, err := json.Marshal(session)
+	if err != nil {
+		return err
+	}
+	sessionsKey := s.sessionsKey(stream)
+	return s.client.Watch(ctx, func(tx *redis.Tx) error {
+		exists, err := tx.HExists(ctx, sessionsKey, session.Id).Result()
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return ErrSessionNotFound
+		}
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			return pipe.HSet(ctx, sessionsKey, session.Id, value).Err()
+		})
+		return err
+	}, sessionsKey)
+}
+
+func (s *redisStreamService) GetSession(ctx context.Context, stream, id string) (Session, error) {
+	value, err := s.client.HGet(ctx, s.sessionsKey(stream), id).Result()
+	if err == redis.Nil {
+		return Session{}, ErrSessionNotFound
+	}
+	if err != nil {
+		return Session{}, err
+	}
+	session, err := decodeSession(value)
+	if err != nil {
+		return Session{}, err
+	}
+	if session.ExpiresAt <= time.Now().Unix() {
+		return Session{}, ErrSessionNotFound
+	}
+	return session, nil
+}
+
+func (s *redisStreamService) GetSessions(ctx context.Context, stream string) ([]Session, error) {
+	values, err := s.client.HGetAll(ctx, s.sessionsKey(stream)).Result()
+	if err != nil && err != redis.Nil {
+		return nil, err
+	}
+	now := time.Now().Unix()
+	sessions := make([]Session, 0, len(values))
+	for _, value := range values {
+		session, err := decodeSession(value)
+		if err != nil {
+			return nil, err
+		}
+		if session.ExpiresAt > now {
+			sessions = append(sessions, session)
+		}
+	}
+	sortSessions(sessions)
+	return sessions, nil
+}
+
+func (s *redisStreamService) DeleteSession(ctx context.Context, stream, id string) error {
+	count, err := s.client.HDel(ctx, s.sessionsKey(stream), id).Result()
+	if err == nil && count == 0 {
+		return ErrSessionNotFound
+	}
 	return err
 }
 
+func (s *redisStreamService) DeleteSessions(ctx context.Context, stream string) error {
+	return s.client.Del(ctx, s.sessionsKey(stream)).Err()
+}
+
+func decodeSession(value string) (Session, error) {
+	var session Session
+	if err := json.Unmarshal([]byte(value), &session); err != nil {
+		return Session{}, fmt.Errorf("failed to decode session, %w", err)
+	}
+	return session, nil
+}
+
+func sortSessions(sessions []Session) {
+	sort.Slice(sessions, func(i, j int) bool {
+		if sessions[i].CreatedAt != sessions[j].CreatedAt {
+			return sessions[i].CreatedAt < sessions[j].CreatedAt
+		}
+		return sessions[i].Id < sessions[j].Id
+	})
+}
+
+func sortApiTokens(tokens []ApiToken) {
+	sort.Slice(tokens, func(i, j int) bool {
+		if tokens[i].CreatedAt != tokens[j].CreatedAt {
+			return tokens[i].CreatedAt < tokens[j].CreatedAt
+		}
+		return tokens[i].Id < tokens[j].Id
+	})
+}
+
+func (s *redisStreamService) Close() error {
+	return s.client.Close()
+}
+
 func (s *redisStreamService) AddDevice(ctx context.Context, stream string, device Device) (Device, error) {
 	_, err := s.client.HSet(ctx, s.devicesKey(stream), device.Id, device.Description).Result()
 	return device, err
@@ -118,7 +756,7 @@ func (s *redisStreamService) AddFirstDevice(ctx context.Context, stream string,
 			return err
 		}
 		if len(devices) > 0 {
-			return fmt.Errorf("device already exists for stream: %v", stream)
+			return errDeviceExists(stream)
 		}
 		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
 			_, err := pipe.HSet(ctx, devicesKey, device.Id, device.Description).Result()
@@ -144,10 +782,97 @@ func (s *redisStreamService) GetDevices(ctx context.Context, stream string) ([]D
 	return devices, nil
 }
 
+const redisCountPageSize = 1000
+
+const (
+	redisEventKeyPrefix       = "mypaste:event:"
+	redisDeviceKeyPrefix      = "mypaste:device:"
+	redisIdempotencyKeyPrefix = "mypaste:idempotency:"
+	redisApiTokensKeyPrefix   = "mypaste:apitokens:"
+	redisApiTokenKeyPrefix    = "mypaste:apitoken:"
+	redisSessionKeyPrefix     = "mypaste:sessions:"
+	redisMaxDeletedKeyPrefix  = "mypaste:maxdeleted:"
+)
+
+// redisRecordDeleted is the lua function shared by the scripts removing events,
+// it keeps the greatest removed id at KEYS[2].
+const redisRecordDeleted = `
+local function recordDeleted(id)
+	local current = redis.call('GET', KEYS[2])
+	if current then
+		local ms, seq = string.match(id, '^(%d+)-(%d+)$')
+		local currentMs, currentSeq = string.match(current, '^(%d+)-(%d+)$')
+		ms, seq, currentMs, currentSeq = tonumber(ms), tonumber(seq), tonumber(currentMs), tonumber(currentSeq)
+		if ms < currentMs or (ms == currentMs and seq <= currentSeq) then
+			return
+		end
+	end
+	redis.call('SET', KEYS[2], id)
+end
+`
+
+var (
+	redisExpireScript = redis.NewScript(redisRecordDeleted + `
+local count = 0
+for _, id in ipairs(ARGV) do
+	if redis.call('XDEL', KEYS[1], id) > 0 then
+		count = count + 1
+		recordDeleted(id)
+	end
+end
+return count
+`)
+	// ARGV[1] is the min id and ARGV[2] is its predecessor, the greatest id trimmed
+	redisTrimMinIdScript = redis.NewScript(redisRecordDeleted + `
+local last = redis.call('XREVRANGE', KEYS[1], ARGV[2], '-', 'COUNT', 1)
+if #last == 0 then
+	return 0
+end
+local count = redis.call('XTRIM', KEYS[1], 'MINID', ARGV[1])
+recordDeleted(last[1][1])
+return count
+`)
+	redisTrimMaxLenScript = redis.NewScript(redisRecordDeleted + `
+local count = redis.call('XLEN', KEYS[1]) - tonumber(ARGV[1])
+if count <= 0 then
+	return 0
+end
+local trimmed = redis.call('XRANGE', KEYS[1], '-', '+', 'COUNT', count)
+redis.call('XTRIM', KEYS[1], 'MAXLEN', ARGV[1])
+recordDeleted(trimmed[#trimmed][1])
+return count
+`)
+)
+
+// redisKey hash tags the stream so all keys of a stream stay in the same cluster slot.
+func redisKey(prefix, stream string) string {
+	return prefix + "{" + stream + "}"
+}
+
 func (s *redisStreamService) eventsKey(stream string) string {
-	return "mypaste:event:" + stream
+	return redisKey(redisEventKeyPrefix, stream)
 }
 
 func (s *redisStreamService) devicesKey(stream string) string {
-	return "mypaste:device:" + stream
+	return redisKey(redisDeviceKeyPrefix, stream)
+}
+
+func (s *redisStreamService) idempotencyKey(stream, key string) string {
+	return redisKey(redisIdempotencyKeyPrefix, stream) + ":" + key
+}
+
+func (s *redisStreamService) apiTokensKey(stream string) string {
+	return redisKey(redisApiTokensKeyPrefix, stream)
+}
+
+func (s *redisStreamService) apiTokenKey(hash string) string {
+	return redisApiTokenKeyPrefix + hash
+}
+
+func (s *redisStreamService) maxDeletedKey(stream string) string {
+	return redisKey(redisMaxDeletedKeyPrefix, stream)
+}
+
+func (s *redisStreamService) sessionsKey(stream string) string {
+	return redisKey(redisSessionKeyPrefix, stream)
 }
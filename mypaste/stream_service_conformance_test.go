@@ -0,0 +1,16 @@
+package mypaste_test
+
+import (
+	"testing"
+
+	"github.com/aungmawjj/mypaste/mypaste"
+	"github.com/aungmawjj/mypaste/mypaste/streamservicetest"
+)
+
+func TestStreamServiceConformance(t *testing.T) {
+	for name, newTestStreamService := range mypaste.TestStreamServices {
+		t.Run(name, func(t *testing.T) {
+			streamservicetest.Run(t, newTestStreamService)
+		})
+	}
+}
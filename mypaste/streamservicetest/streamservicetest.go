@@ -0,0 +1,632 @@
+// Package streamservicetest provides a conformance test suite for mypaste.Store implementations.
+package streamservicetest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aungmawjj/mypaste/mypaste"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Factory returns a new empty Store using the given config.
+type Factory func(t *testing.T, config mypaste.StreamConfig) mypaste.Store
+
+// Run runs the conformance suite against the Store created by newStreamService.
+func Run(t *testing.T, newStreamService Factory) {
+	config := func(readBlock time.Duration) mypaste.StreamConfig {
+		return mypaste.StreamConfig{MaxLen: 10, ReadCount: 10, ReadBlock: readBlock}
+	}
+
+	t.Run("add sets id and timestamp", func(t *testing.T) {
+		svc := newStreamService(t, config(time.Millisecond))
+		start := time.Now().Unix()
+		event := add(t, svc, "email", "hello")
+		assert.NotEmpty(t, event.Id)
+		assert.GreaterOrEqual(t, event.Timestamp, start)
+		assert.Equal(t, "hello", event.Payload)
+	})
+
+	t.Run("read in order", func(t *testing.T) {
+		svc := newStreamService(t, config(time.Millisecond))
+		e1 := add(t, svc, "email", "hello 1")
+		e2 := add(t, svc, "email", "hello 2")
+		e3 := add(t, svc, "email", "hello 3")
+		assert.Equal(t, []mypaste.Event{e1, e2, e3}, read(t, svc, "email", ""))
+		assert.Equal(t, []mypaste.Event{e1, e2, e3}, read(t, svc, "email", "0"))
+		assert.Equal(t, []mypaste.Event{e2, e3}, read(t, svc, "email", e1.Id))
+		assert.Equal(t, []mypaste.Event{e3}, read(t, svc, "email", e2.Id))
+		assert.Empty(t, read(t, svc, "email", e3.Id))
+	})
+
+	t.Run("read keeps all fields", func(t *testing.T) {
+		svc := newStreamService(t, config(time.Millisecond))
+		event, err := svc.Add(context.Background(), "email", mypaste.Event{
+			Payload:     "secret",
+			Kind:        "Text",
+			IsSensitive: true,
+		})
+		require.NoError(t, err)
+		assert.Equal(t, []mypaste.Event{event}, read(t, svc, "email", ""))
+	})
+
+	t.Run("read count", func(t *testing.T) {
+		svc := newStreamService(t, mypaste.StreamConfig{MaxLen: 10, ReadCount: 2, ReadBlock: time.Millisecond})
+		e1 := add(t, svc, "email", "hello 1")
+		e2 := add(t, svc, "email", "hello 2")
+		e3 := add(t, svc, "email", "hello 3")
+		assert.Equal(t, []mypaste.Event{e1, e2}, read(t, svc, "email", ""))
+		assert.Equal(t, []mypaste.Event{e3}, read(t, svc, "email", e2.Id))
+	})
+
+	t.Run("read blocks until timeout", func(t *testing.T) {
+		readBlock := 50 * time.Millisecond
+		svc := newStreamService(t, config(readBlock))
+		start := time.Now()
+		events := read(t, svc, "email", "")
+		assert.GreaterOrEqual(t, time.Since(start), readBlock, "should block for at least read block")
+		assert.Less(t, time.Since(start), 20*readBlock, "should return after read block")
+		assert.Empty(t, events)
+	})
+
+	t.Run("read returns after add", func(t *testing.T) {
+		readBlock := 5 * time.Second
+		svc := newStreamService(t, config(readBlock))
+		last := add(t, svc, "email", "hello 1")
+		eventsCh := make(chan []mypaste.Event)
+		go func() {
+			events, _ := svc.Read(context.Background(), "email", last.Id)
+			eventsCh <- events
+		}()
+		time.Sleep(20 * time.Millisecond)
+		event := add(t, svc, "email", "hello 2")
+		select {
+		case <-time.After(readBlock / 2):
+			assert.Fail(t, "should not block after add")
+		case events := <-eventsCh:
+			assert.Equal(t, []mypaste.Event{event}, events)
+		}
+	})
+
+	t.Run("read returns on context done", func(t *testing.T) {
+		svc := newStreamService(t, config(time.Minute))
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		errCh := make(chan error)
+		go func() {
+			_, err := svc.Read(ctx, "email", "")
+			errCh <- err
+		}()
+		select {
+		case <-time.After(5 * time.Second):
+			assert.Fail(t, "should return when context is done")
+		case err := <-errCh:
+			assert.Error(t, err)
+		}
+	})
+
+	t.Run("isolated for each stream", func(t *testing.T) {
+		svc := newStreamService(t, config(time.Millisecond))
+		e1 := add(t, svc, "email1", "hello 1")
+		e2 := add(t, svc, "email2", "hello 2")
+		assert.Equal(t, []mypaste.Event{e1}, read(t, svc, "email1", ""))
+		assert.Equal(t, []mypaste.Event{e2}, read(t, svc, "email2", ""))
+
+		_, err := svc.AddDevice(context.Background(), "email1", mypaste.Device{Id: "d1", Description: "device 1"})
+		require.NoError(t, err)
+		assert.Empty(t, devices(t, svc, "email2"))
+
+		require.NoError(t, svc.Reset(context.Background(), "email1"))
+		assert.Empty(t, read(t, svc, "email1", ""))
+		assert.Equal(t, []mypaste.Event{e2}, read(t, svc, "email2", ""))
+	})
+
+	t.Run("trim to max len", func(t *testing.T) {
+		maxLen := 5
+		svc := newStreamService(t, mypaste.StreamConfig{MaxLen: int64(maxLen), ReadCount: 100, ReadBlock: time.Millisecond})
+		var added []mypaste.Event
+		for i := 0; i < 4*maxLen; i++ {
+			added = append(added, add(t, svc, "email", "hello"))
+		}
+		// a store archiving trimmed events pages through them first
+		var events []mypaste.Event
+		for lastId := ""; ; {
+			res := read(t, svc, "email", lastId)
+			if len(res) == 0 {
+				break
+			}
+			events = append(events, res...)
+			lastId = res[len(res)-1].Id
+		}
+		// trimming may be approximate, but the newest max len events must be kept in order
+		assert.GreaterOrEqual(t, len(events), maxLen)
+		assert.Equal(t, added[len(added)-len(events):], events)
+	})
+
+	t.Run("no trim without max len", func(t *testing.T) {
+		svc := newStreamService(t, mypaste.StreamConfig{ReadCount: 100, ReadBlock: time.Millisecond})
+		var added []mypaste.Event
+		for i := 0; i < 30; i++ {
+			added = append(added, add(t, svc, "email", "hello"))
+		}
+		assert.Equal(t, added, read(t, svc, "email", ""))
+	})
+
+	t.Run("len", func(t *testing.T) {
+		svc := newStreamService(t, config(time.Millisecond))
+		assert.Equal(t, int64(0), length(t, svc, "email"))
+		add(t, svc, "email", "hello 1")
+		add(t, svc, "email", "hello 2")
+		assert.Equal(t, int64(2), length(t, svc, "email"))
+		assert.Equal(t, int64(0), length(t, svc, "email2"))
+	})
+
+	t.Run("range", func(t *testing.T) {
+		svc := newStreamService(t, config(time.Millisecond))
+		e1 := add(t, svc, "email", "hello 1")
+		e2 := add(t, svc, "email", "hello 2")
+		e3 := add(t, svc, "email", "hello 3")
+		assert.Equal(t, []mypaste.Event{e1, e2, e3}, rangeEvents(t, svc, "email", "-", "+", 0))
+		assert.Equal(t, []mypaste.Event{e1, e2}, rangeEvents(t, svc, "email", "-", "+", 2))
+		assert.Equal(t, []mypaste.Event{e2, e3}, rangeEvents(t, svc, "email", e2.Id, "+", 0))
+		assert.Equal(t, []mypaste.Event{e1, e2}, rangeEvents(t, svc, "email", "-", e2.Id, 0))
+		assert.Equal(t, []mypaste.Event{e2}, rangeEvents(t, svc, "email", e2.Id, e2.Id, 0))
+		assert.Empty(t, rangeEvents(t, svc, "email2", "-", "+", 0))
+	})
+
+	t.Run("add batch", func(t *testing.T) {
+		svc := newStreamService(t, config(time.Millisecond))
+		e1 := add(t, svc, "email", "hello 1")
+		added, err := svc.AddBatch(context.Background(), "email", []mypaste.Event{
+			{Payload: "hello 2"}, {Payload: "hello 3"}, {Payload: "hello 4"},
+		})
+		require.NoError(t, err)
+		require.Len(t, added, 3)
+		for i, event := range added {
+			assert.NotEmpty(t, event.Id)
+			assert.NotZero(t, event.Timestamp)
+			assert.Equal(t, fmt.Sprintf("hello %d", i+2), event.Payload)
+		}
+		assert.Equal(t, append([]mypaste.Event{e1}, added...), read(t, svc, "email", ""))
+
+		added, err = svc.AddBatch(context.Background(), "email", nil)
+		require.NoError(t, err)
+		assert.Empty(t, added)
+	})
+
+	t.Run("idempotency key", func(t *testing.T) {
+		svc := newStreamService(t, config(time.Millisecond))
+		ctx := context.Background()
+		reserve := func(stream, key, requestHash string) (mypaste.IdempotencyKey, bool) {
+			record, reserved, err := svc.ReserveIdempotencyKey(ctx, stream, key, requestHash, time.Minute)
+			require.NoError(t, err)
+			return record, reserved
+		}
+		_, reserved := reserve("email", "key1", "hash1")
+		assert.True(t, reserved)
+		record, reserved := reserve("email", "key1", "hash2")
+		assert.False(t, reserved)
+		assert.Equal(t, mypaste.IdempotencyKey{RequestHash: "hash1"}, record)
+
+		require.NoError(t, svc.SetIdempotencyKey(ctx, "email", "key1",
+			mypaste.IdempotencyKey{RequestHash: "hash1", EventId: "1-0"}, time.Minute))
+		record, reserved = reserve("email", "key1", "hash1")
+		assert.False(t, reserved)
+		assert.Equal(t, mypaste.IdempotencyKey{RequestHash: "hash1", EventId: "1-0"}, record)
+
+		_, reserved = reserve("email2", "key1", "hash1")
+		assert.True(t, reserved)
+
+		require.NoError(t, svc.DeleteIdempotencyKey(ctx, "email", "key1"))
+		_, reserved = reserve("email", "key1", "hash1")
+		assert.True(t, reserved)
+	})
+
+	t.Run("get", func(t *testing.T) {
+		svc := newStreamService(t, config(time.Millisecond))
+		add(t, svc, "email", "hello 1")
+		e2 := add(t, svc, "email", "hello 2")
+		event, err := svc.Get(context.Background(), "email", e2.Id)
+		require.NoError(t, err)
+		assert.Equal(t, e2, event)
+
+		_, err = svc.Get(context.Background(), "email2", e2.Id)
+		assert.ErrorIs(t, err, mypaste.ErrEventNotFound)
+		_, err = svc.Get(context.Background(), "email", "0-1")
+		assert.ErrorIs(t, err, mypaste.ErrEventNotFound)
+		_, err = svc.Get(context.Background(), "email", "invalid")
+		assert.Error(t, err)
+	})
+
+	t.Run("rev range", func(t *testing.T) {
+		svc := newStreamService(t, config(time.Millisecond))
+		e1 := add(t, svc, "email", "hello 1")
+		e2 := add(t, svc, "email", "hello 2")
+		e3 := add(t, svc, "email", "hello 3")
+		assert.Equal(t, []mypaste.Event{e3, e2, e1}, revRangeEvents(t, svc, "email", "+", "-", 0))
+		assert.Equal(t, []mypaste.Event{e3, e2}, revRangeEvents(t, svc, "email", "+", "-", 2))
+		assert.Equal(t, []mypaste.Event{e2, e1}, revRangeEvents(t, svc, "email", e2.Id, "-", 0))
+		assert.Equal(t, []mypaste.Event{e3, e2}, revRangeEvents(t, svc, "email", "+", e2.Id, 0))
+		assert.Equal(t, []mypaste.Event{e2}, revRangeEvents(t, svc, "email", e2.Id, e2.Id, 0))
+		assert.Empty(t, revRangeEvents(t, svc, "email2", "+", "-", 0))
+	})
+
+	t.Run("count", func(t *testing.T) {
+		svc := newStreamService(t, config(time.Millisecond))
+		add(t, svc, "email", "hello 1")
+		e2 := add(t, svc, "email", "hello 2")
+		e3 := add(t, svc, "email", "hello 3")
+		assert.Equal(t, int64(3), count(t, svc, "email", "-", "+"))
+		assert.Equal(t, int64(2), count(t, svc, "email", e2.Id, e3.Id))
+		assert.Equal(t, int64(1), count(t, svc, "email", e3.Id, "+"))
+		assert.Equal(t, int64(0), count(t, svc, "email2", "-", "+"))
+	})
+
+	t.Run("trim", func(t *testing.T) {
+		svc := newStreamService(t, config(time.Millisecond))
+		add(t, svc, "email", "hello 1")
+		add(t, svc, "email", "hello 2")
+		e3 := add(t, svc, "email", "hello 3")
+		count, err := svc.Trim(context.Background(), "email", e3.Id)
+		require.NoError(t, err)
+		assert.Equal(t, int64(2), count)
+		assert.Equal(t, []mypaste.Event{e3}, read(t, svc, "email", ""))
+	})
+
+	t.Run("import keeps ids and timestamps", func(t *testing.T) {
+		svc := newStreamService(t, config(time.Millisecond))
+		events := []mypaste.Event{
+			{Id: "1000-0", Payload: "hello 1", Timestamp: 1},
+			{Id: "1000-1", Payload: "hello 2", Timestamp: 1},
+			{Id: "2000-0", Payload: "hello 3", Timestamp: 2},
+		}
+		count, err := svc.Import(context.Background(), "email", events[:2])
+		require.NoError(t, err)
+		assert.Equal(t, int64(2), count)
+
+		// repeated import skips events already imported
+		count, err = svc.Import(context.Background(), "email", events)
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), count)
+		assert.Equal(t, events, read(t, svc, "email", ""))
+
+		event := add(t, svc, "email", "hello 4")
+		assert.Equal(t, events[2].Id, read(t, svc, "email", events[1].Id)[0].Id)
+		assert.Equal(t, []mypaste.Event{event}, read(t, svc, "email", events[2].Id))
+	})
+
+	t.Run("streams", func(t *testing.T) {
+		svc := newStreamService(t, config(time.Millisecond))
+		add(t, svc, "email1", "hello")
+		_, err := svc.AddDevice(context.Background(), "email2", mypaste.Device{Id: "d1"})
+		require.NoError(t, err)
+		streams, err := svc.Streams(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, []string{"email1", "email2"}, streams)
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		svc := newStreamService(t, config(time.Millisecond))
+		e1 := add(t, svc, "email", "hello 1")
+		e2 := add(t, svc, "email", "hello 2")
+		e3 := add(t, svc, "email", "hello 3")
+		count, err := svc.Delete(context.Background(), "email", e1.Id, e3.Id)
+		require.NoError(t, err)
+		assert.Equal(t, int64(2), count)
+		assert.Equal(t, []mypaste.Event{e2}, read(t, svc, "email", ""))
+
+		count, err = svc.Delete(context.Background(), "email", e1.Id)
+		require.NoError(t, err)
+		assert.Equal(t, int64(0), count, "should not count missing events")
+	})
+
+	t.Run("ids increase after delete", func(t *testing.T) {
+		svc := newStreamService(t, config(time.Millisecond))
+		e1 := add(t, svc, "email", "hello 1")
+		_, err := svc.Delete(context.Background(), "email", e1.Id)
+		require.NoError(t, err)
+		e2 := add(t, svc, "email", "hello 2")
+		assert.Equal(t, []mypaste.Event{e2}, read(t, svc, "email", e1.Id))
+	})
+
+	t.Run("max deleted id", func(t *testing.T) {
+		ctx := context.Background()
+		svc := newStreamService(t, mypaste.StreamConfig{ReadCount: 100, ReadBlock: time.Millisecond})
+		maxDeletedId := func() string {
+			id, err := svc.MaxDeletedId(ctx, "email")
+			require.NoError(t, err)
+			return id
+		}
+		add(t, svc, "email", "hello 1")
+		e2 := add(t, svc, "email", "hello 2")
+		e3 := add(t, svc, "email", "hello 3")
+		e4 := add(t, svc, "email", "hello 4")
+		e5 := add(t, svc, "email", "hello 5")
+		assert.Empty(t, maxDeletedId())
+
+		count, err := svc.Delete(ctx, "email", e5.Id)
+		require.NoError(t, err)
+		assert.EqualValues(t, 1, count)
+		assert.Empty(t, maxDeletedId(), "should not record an explicit delete")
+
+		_, err = svc.Expire(ctx, "email", e3.Id)
+		require.NoError(t, err)
+		assert.Equal(t, e3.Id, maxDeletedId())
+		_, err = svc.Expire(ctx, "email", e2.Id, "99999999999999-0")
+		require.NoError(t, err)
+		assert.Equal(t, e3.Id, maxDeletedId(), "should keep the greatest id and skip missing events")
+
+		_, err = svc.Trim(ctx, "email", e4.Id)
+		require.NoError(t, err)
+		assert.Equal(t, e3.Id, maxDeletedId())
+		_, err = svc.Trim(ctx, "email", "99999999999999-0")
+		require.NoError(t, err)
+		assert.Equal(t, e4.Id, maxDeletedId())
+
+		require.NoError(t, svc.Reset(ctx, "email"))
+		assert.Empty(t, maxDeletedId())
+	})
+
+	t.Run("max deleted id after trim to max len", func(t *testing.T) {
+		svc := newStreamService(t, mypaste.StreamConfig{MaxLen: 1, ReadCount: 100, ReadBlock: time.Millisecond})
+		e1 := add(t, svc, "email", "hello 1")
+		for i := 0; i < 5; i++ {
+			add(t, svc, "email", "hello")
+		}
+		id, err := svc.MaxDeletedId(context.Background(), "email")
+		require.NoError(t, err)
+		// a store archiving trimmed events keeps them readable instead
+		if events := read(t, svc, "email", ""); events[0].Id == e1.Id {
+			assert.Empty(t, id)
+		} else {
+			assert.NotEmpty(t, id)
+		}
+	})
+
+	t.Run("reset", func(t *testing.T) {
+		svc := newStreamService(t, config(time.Millisecond))
+		add(t, svc, "email", "hello 1")
+		add(t, svc, "email", "hello 2")
+		_, err := svc.AddDevice(context.Background(), "email", mypaste.Device{Id: "d1", Description: "device 1"})
+		require.NoError(t, err)
+
+		require.NoError(t, svc.Reset(context.Background(), "email"))
+		assert.Empty(t, read(t, svc, "email", ""))
+		assert.Len(t, devices(t, svc, "email"), 1, "should keep devices")
+
+		event := add(t, svc, "email", "hello 3")
+		assert.Equal(t, []mypaste.Event{event}, read(t, svc, "email", ""))
+	})
+
+	t.Run("add device", func(t *testing.T) {
+		svc := newStreamService(t, config(time.Millisecond))
+		assert.Empty(t, devices(t, svc, "email"))
+		d1 := mypaste.Device{Id: "d1", Description: "device 1"}
+		d2 := mypaste.Device{Id: "d2", Description: "device 2"}
+		for _, d := range []mypaste.Device{d1, {Id: "d1", Description: "old"}, d1, d2} {
+			device, err := svc.AddDevice(context.Background(), "email", d)
+			require.NoError(t, err)
+			assert.Equal(t, d, device)
+		}
+		assert.ElementsMatch(t, []mypaste.Device{d1, d2}, devices(t, svc, "email"))
+	})
+
+	t.Run("first device", func(t *testing.T) {
+		svc := newStreamService(t, config(time.Millisecond))
+		d1 := mypaste.Device{Id: "d1", Description: "device 1"}
+		device, err := svc.AddFirstDevice(context.Background(), "email", d1)
+		require.NoError(t, err)
+		assert.Equal(t, d1, device)
+
+		_, err = svc.AddFirstDevice(context.Background(), "email", mypaste.Device{Id: "d2", Description: "device 2"})
+		assert.Error(t, err)
+		assert.Equal(t, []mypaste.Device{d1}, devices(t, svc, "email"))
+
+		_, err = svc.AddFirstDevice(context.Background(), "email2", mypaste.Device{Id: "d2", Description: "device 2"})
+		assert.NoError(t, err, "should be isolated for each stream")
+	})
+
+	t.Run("add batch with devices", func(t *testing.T) {
+		svc := newStreamService(t, config(time.Millisecond))
+		ctx := context.Background()
+		first := mypaste.Event{Kind: "FirstDevice", Payload: `{"Id":"d1","Description":"device 1"}`}
+		added, err := svc.AddBatch(ctx, "email", []mypaste.Event{
+			first,
+			{Kind: "DeviceAdded", Payload: `{"Id":"d2","Description":"device 2"}`},
+		})
+		require.NoError(t, err)
+		require.Len(t, added, 2)
+		assert.ElementsMatch(t, []mypaste.Device{{Id: "d1", Description: "device 1"}, {Id: "d2", Description: "device 2"}}, devices(t, svc, "email"))
+
+		// a failed device adds no events
+		_, err = svc.AddBatch(ctx, "email", []mypaste.Event{{Payload: "hello"}, first})
+		assert.Error(t, err)
+		_, err = svc.AddBatch(ctx, "email", []mypaste.Event{{Payload: "hello"}, {Kind: "DeviceAdded", Payload: "invalid"}})
+		assert.Error(t, err)
+		assert.Equal(t, added, read(t, svc, "email", ""))
+
+		// and no devices
+		_, err = svc.AddBatch(ctx, "email2", []mypaste.Event{{Kind: "DeviceAdded", Payload: `{"Id":"d3"}`}, first})
+		assert.Error(t, err)
+		assert.Empty(t, devices(t, svc, "email2"))
+	})
+
+	t.Run("first device race", func(t *testing.T) {
+		svc := newStreamService(t, config(time.Millisecond))
+		const count = 10
+		var wg sync.WaitGroup
+		errs := make(chan error, count)
+		for i := 0; i < count; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				_, err := svc.AddFirstDevice(context.Background(), "email", mypaste.Device{
+					Id:          string(rune('a' + i)),
+					Description: "device",
+				})
+				errs <- err
+			}(i)
+		}
+		wg.Wait()
+		close(errs)
+		succeeded := 0
+		for err := range errs {
+			if err == nil {
+				succeeded++
+			}
+		}
+		assert.Equal(t, 1, succeeded, "only one first device should be added")
+		assert.Len(t, devices(t, svc, "email"), 1)
+	})
+
+	t.Run("api tokens", func(t *testing.T) {
+		svc := newStreamService(t, config(time.Millisecond))
+		ctx := context.Background()
+		user := mypaste.User{Name: "name", Email: "email"}
+		t1 := mypaste.ApiToken{Id: "t1", Name: "token 1", Scopes: []string{"events:read"}, CreatedAt: 2, User: user, Hash: "h1"}
+		t2 := mypaste.ApiToken{Id: "t2", Name: "token 2", Scopes: []string{"events:read", "events:write"}, CreatedAt: 1, User: user, Hash: "h2"}
+		require.NoError(t, svc.AddApiToken(ctx, "email", t1))
+		require.NoError(t, svc.AddApiToken(ctx, "email", t2))
+
+		tokens, err := svc.GetApiTokens(ctx, "email")
+		require.NoError(t, err)
+		assert.Equal(t, []mypaste.ApiToken{t2, t1}, tokens, "should order by creation")
+		tokens, err = svc.GetApiTokens(ctx, "email2")
+		require.NoError(t, err)
+		assert.Empty(t, tokens)
+
+		token, err := svc.GetApiToken(ctx, "h1")
+		require.NoError(t, err)
+		assert.Equal(t, t1, token)
+		_, err = svc.GetApiToken(ctx, "h3")
+		assert.ErrorIs(t, err, mypaste.ErrApiTokenNotFound)
+
+		assert.ErrorIs(t, svc.DeleteApiToken(ctx, "email2", "t1"), mypaste.ErrApiTokenNotFound,
+			"should not delete token of other stream")
+		require.NoError(t, svc.DeleteApiToken(ctx, "email", "t1"))
+		_, err = svc.GetApiToken(ctx, "h1")
+		assert.ErrorIs(t, err, mypaste.ErrApiTokenNotFound)
+		assert.ErrorIs(t, svc.DeleteApiToken(ctx, "email", "t1"), mypaste.ErrApiTokenNotFound)
+		tokens, err = svc.GetApiTokens(ctx, "email")
+		require.NoError(t, err)
+		assert.Equal(t, []mypaste.ApiToken{t2}, tokens)
+	})
+
+	t.Run("sessions", func(t *testing.T) {
+		svc := newStreamService(t, config(time.Millisecond))
+		ctx := context.Background()
+		expiresAt := time.Now().Add(time.Hour).Unix()
+		s1 := mypaste.Session{Id: "s1", CreatedAt: 1, ExpiresAt: expiresAt}
+		s2 := mypaste.Session{Id: "s2", CreatedAt: 2, ExpiresAt: expiresAt}
+		expired := mypaste.Session{Id: "s3", CreatedAt: 3, ExpiresAt: time.Now().Unix() - 1}
+		for _, session := range []mypaste.Session{s1, s2, expired} {
+			require.NoError(t, svc.SetSession(ctx, "email", session))
+		}
+
+		session, err := svc.GetSession(ctx, "email", "s1")
+		require.NoError(t, err)
+		assert.Equal(t, s1, session)
+		_, err = svc.GetSession(ctx, "email", "s3")
+		assert.ErrorIs(t, err, mypaste.ErrSessionNotFound, "should not return expired session")
+		_, err = svc.GetSession(ctx, "email2", "s1")
+		assert.ErrorIs(t, err, mypaste.ErrSessionNotFound, "should be isolated for each stream")
+
+		s1.ExpiresAt++
+		require.NoError(t, svc.SetSession(ctx, "email", s1))
+		session, err = svc.GetSession(ctx, "email", "s1")
+		require.NoError(t, err)
+		assert.Equal(t, s1, session, "should update session")
+
+		require.NoError(t, svc.DeleteSession(ctx, "email", "s1"))
+		_, err = svc.GetSession(ctx, "email", "s1")
+		assert.ErrorIs(t, err, mypaste.ErrSessionNotFound)
+		assert.ErrorIs(t, svc.DeleteSession(ctx, "email", "s1"), mypaste.ErrSessionNotFound)
+
+		require.NoError(t, svc.SetSession(ctx, "email2", s1))
+		require.NoError(t, svc.DeleteSessions(ctx, "email"))
+		_, err = svc.GetSession(ctx, "email", "s2")
+		assert.ErrorIs(t, err, mypaste.ErrSessionNotFound)
+		_, err = svc.GetSession(ctx, "email2", "s1")
+		assert.NoError(t, err, "should keep sessions of other streams")
+	})
+
+	t.Run("update and list sessions", func(t *testing.T) {
+		svc := newStreamService(t, config(time.Millisecond))
+		ctx := context.Background()
+		expiresAt := time.Now().Add(time.Hour).Unix()
+		s1 := mypaste.Session{Id: "s1", CreatedAt: 2, ExpiresAt: expiresAt, Ip: "10.0.0.1", UserAgent: "curl"}
+		s2 := mypaste.Session{Id: "s2", CreatedAt: 1, ExpiresAt: expiresAt}
+		require.NoError(t, svc.SetSession(ctx, "email", s1))
+		require.NoError(t, svc.SetSession(ctx, "email", s2))
+		require.NoError(t, svc.SetSession(ctx, "email", mypaste.Session{Id: "s3", ExpiresAt: time.Now().Unix() - 1}))
+
+		s1.LastUsedAt = 3
+		s1.Ip = "10.0.0.2"
+		require.NoError(t, svc.UpdateSession(ctx, "email", s1))
+		sessions, err := svc.GetSessions(ctx, "email")
+		require.NoError(t, err)
+		assert.Equal(t, []mypaste.Session{s2, s1}, sessions, "should order by creation without expired sessions")
+
+		require.NoError(t, svc.DeleteSession(ctx, "email", "s2"))
+		assert.ErrorIs(t, svc.UpdateSession(ctx, "email", s2), mypaste.ErrSessionNotFound,
+			"should not add revoked session again")
+		sessions, err = svc.GetSessions(ctx, "email2")
+		require.NoError(t, err)
+		assert.Empty(t, sessions)
+	})
+
+	t.Run("device event payload round trip", func(t *testing.T) {
+		svc := newStreamService(t, config(time.Millisecond))
+		payload, _ := json.Marshal(mypaste.Device{Id: "d1", Description: "device 1"})
+		event, err := svc.Add(context.Background(), "email", mypaste.Event{Kind: "DeviceAdded", Payload: string(payload)})
+		require.NoError(t, err)
+		assert.Equal(t, []mypaste.Event{event}, read(t, svc, "email", ""))
+	})
+}
+
+func add(t *testing.T, svc mypaste.StreamService, stream, payload string) mypaste.Event {
+	event, err := svc.Add(context.Background(), stream, mypaste.Event{Payload: payload})
+	require.NoError(t, err)
+	return event
+}
+
+func read(t *testing.T, svc mypaste.StreamService, stream, lastId string) []mypaste.Event {
+	events, err := svc.Read(context.Background(), stream, lastId)
+	require.NoError(t, err)
+	return events
+}
+
+func length(t *testing.T, svc mypaste.StreamService, stream string) int64 {
+	count, err := svc.Len(context.Background(), stream)
+	require.NoError(t, err)
+	return count
+}
+
+func rangeEvents(t *testing.T, svc mypaste.StreamService, stream, start, end string, count int64) []mypaste.Event {
+	events, err := svc.Range(context.Background(), stream, start, end, count)
+	require.NoError(t, err)
+	return events
+}
+
+func count(t *testing.T, svc mypaste.StreamService, stream, start, end string) int64 {
+	count, err := svc.Count(context.Background(), stream, start, end)
+	require.NoError(t, err)
+	return count
+}
+
+func revRangeEvents(t *testing.T, svc mypaste.StreamService, stream, end, start string, count int64) []mypaste.Event {
+	events, err := svc.RevRange(context.Background(), stream, end, start, count)
+	require.NoError(t, err)
+	return events
+}
+
+func devices(t *testing.T, svc mypaste.StreamService, stream string) []mypaste.Device {
+	devices, err := svc.GetDevices(context.Background(), stream)
+	require.NoError(t, err)
+	return devices
+}
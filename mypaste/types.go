@@ -17,3 +17,23 @@ type Device struct {
 	Id          string
 	Description string
 }
+
+// ApiToken is a named credential for scripts, only the sha256 hash of the secret is stored.
+type ApiToken struct {
+	Id        string
+	Name      string
+	Scopes    []string
+	CreatedAt int64
+	User      User
+	Hash      string `json:"-"`
+}
+
+// Session is a login of a user, the login jwt carries the session id and is valid only while the session exists.
+type Session struct {
+	Id         string
+	CreatedAt  int64
+	LastUsedAt int64
+	ExpiresAt  int64
+	Ip         string
+	UserAgent  string
+}
@@ -0,0 +1,235 @@
+package mypaste
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"golang.org/x/net/websocket"
+)
+
+// WebSocketFrame is the json frame sent in both directions over the websocket.
+//
+// Client frames:
+//
+//	{"Type":"add","RequestId":"1","Event":{...}}  adds an event, answered by an ack or error frame
+//	{"Type":"subscribe","LastId":"..."}           receives events after LastId as events frames
+//
+// Server frames:
+//
+//	{"Type":"ack","RequestId":"1","Event":{...}}  the added event with its assigned id
+//	{"Type":"events","Events":[...]}
+//	{"Type":"error","RequestId":"1","Error":"..."}
+type WebSocketFrame struct {
+	Type      string
+	RequestId string  `json:",omitempty"`
+	LastId    string  `json:",omitempty"`
+	Event     *Event  `json:",omitempty"`
+	Events    []Event `json:",omitempty"`
+	Error     string  `json:",omitempty"`
+}
+
+const (
+	wsFrameAdd       = "add"
+	wsFrameSubscribe = "subscribe"
+	wsFrameAck       = "ack"
+	wsFrameEvents    = "events"
+	wsFrameError     = "error"
+)
+
+// WebSocketHandler serves the websocket, maxPayloadBytes limits the size of a client frame like the request body limit.
+// The session is checked again every revokeCheckInterval and the connection is closed with an error frame once it is revoked.
+func WebSocketHandler(streamService StreamService, sessions SessionStore, apiTokens ApiTokenStore, maxPayloadBytes int, revokeCheckInterval time.Duration) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		claims := getTokenClaims(c)
+		user := claims.User
+		server := websocket.Server{
+			Handshake: checkWebSocketOrigin,
+			Handler: func(conn *websocket.Conn) {
+				defer conn.Close()
+				conn.MaxPayloadBytes = maxPayloadBytes
+				session := &webSocketSession{
+					conn:                conn,
+					streamService:       streamService,
+					sessions:            sessions,
+					apiTokens:           apiTokens,
+					stream:              user.Email,
+					claims:              claims,
+					revokeCheckInterval: revokeCheckInterval,
+				}
+				if err := session.serve(c.Request().Context()); err != nil {
+					c.Logger().Infof("websocket closed, email: %v, %v", user.Email, err)
+				}
+			},
+		}
+		server.ServeHTTP(c.Response(), c.Request())
+		return nil
+	}
+}
+
+// checkWebSocketOrigin rejects browsers connecting from other sites with the auth cookie,
+// clients without the Origin header are allowed.
+func checkWebSocketOrigin(config *websocket.Config, req *http.Request) error {
+	origin := req.Header.Get("Origin")
+	if origin == "" {
+		return nil
+	}
+	u, err := url.Parse(origin)
+	if err != nil || u.Host != req.Host {
+		return fmt.Errorf("websocket origin not allowed: %v", origin)
+	}
+	config.Origin = u
+	return nil
+}
+
+type webSocketSession struct {
+	conn                *websocket.Conn
+	streamService       StreamService
+	sessions            SessionStore
+	apiTokens           ApiTokenStore
+	stream              string
+	claims              *TokenClaims
+	revokeCheckInterval time.Duration
+
+	mtx         sync.Mutex
+	unsubscribe context.CancelFunc
+
+	closeMtx sync.Mutex
+	closeErr error
+}
+
+func (s *webSocketSession) serve(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go s.checkRevoked(ctx)
+	for {
+		var frame WebSocketFrame
+		if err := websocket.JSON.Receive(s.conn, &frame); err != nil {
+			// a failed send or a revoked session closes the connection, which is the cause of the receive error
+			if closeErr := s.failure(); closeErr != nil {
+				return closeErr
+			}
+			return err
+		}
+		var err error
+		switch frame.Type {
+		case wsFrameAdd:
+			err = s.add(ctx, frame)
+		case wsFrameSubscribe:
+			if subscribeErr := s.subscribe(ctx, frame.LastId); subscribeErr != nil {
+				err = s.send(WebSocketFrame{Type: wsFrameError, RequestId: frame.RequestId, Error: subscribeErr.Error()})
+			}
+		default:
+			err = s.send(WebSocketFrame{Type: wsFrameError, RequestId: frame.RequestId, Error: "unknown frame type: " + frame.Type})
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (s *webSocketSession) add(ctx context.Context, frame WebSocketFrame) error {
+	if frame.Event == nil {
+		return s.send(WebSocketFrame{Type: wsFrameError, RequestId: frame.RequestId, Error: "missing event"})
+	}
+	event, err := addEvent(ctx, s.streamService, s.stream, *frame.Event)
+	if err != nil {
+		return s.send(WebSocketFrame{Type: wsFrameError, RequestId: frame.RequestId, Error: err.Error()})
+	}
+	return s.send(WebSocketFrame{Type: wsFrameAck, RequestId: frame.RequestId, Event: &event})
+}
+
+// subscribe replaces the previous subscription of the session.
+func (s *webSocketSession) subscribe(ctx context.Context, lastId string) error {
+	if lastId != "" {
+		if _, err := parseStreamId(lastId); err != nil {
+			return err
+		}
+	}
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if s.unsubscribe != nil {
+		s.unsubscribe()
+	}
+	ctx, s.unsubscribe = context.WithCancel(ctx)
+
+	eventsCh := make(chan []Event)
+	go func() {
+		err := subscribeEvents(ctx, s.streamService, s.stream, lastId, eventsCh)
+		s.sendSubscribed(ctx, WebSocketFrame{Type: wsFrameError, Error: err.Error()})
+	}()
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case events := <-eventsCh:
+				if s.sendSubscribed(ctx, WebSocketFrame{Type: wsFrameEvents, Events: events}) != nil {
+					return
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+// sendSubscribed sends a frame of the subscription with ctx unless it was replaced,
+// checking under the lock so no frame of it follows a new subscribe.
+func (s *webSocketSession) sendSubscribed(ctx context.Context, frame WebSocketFrame) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return s.send(frame)
+}
+
+// checkRevoked closes the connection once the session or api token of the connection is revoked.
+func (s *webSocketSession) checkRevoked(ctx context.Context) {
+	ticker := time.NewTicker(s.revokeCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if err := checkRevoked(ctx, s.sessions, s.apiTokens, s.claims); isRevoked(err) {
+			s.send(WebSocketFrame{Type: wsFrameError, Error: err.Error()})
+			s.close(err)
+			return
+		}
+	}
+}
+
+// send is safe to call from multiple goroutines as websocket.Conn serializes writes.
+// A failed send closes the connection so serve stops receiving.
+func (s *webSocketSession) send(frame WebSocketFrame) error {
+	err := websocket.JSON.Send(s.conn, frame)
+	if err == nil {
+		return nil
+	}
+	err = fmt.Errorf("failed to send websocket frame, %w", err)
+	s.close(err)
+	return err
+}
+
+// close records the first cause of closing the connection for serve to return.
+func (s *webSocketSession) close(err error) {
+	s.closeMtx.Lock()
+	if s.closeErr == nil {
+		s.closeErr = err
+	}
+	s.closeMtx.Unlock()
+	s.conn.Close()
+}
+
+func (s *webSocketSession) failure() error {
+	s.closeMtx.Lock()
+	defer s.closeMtx.Unlock()
+	return s.closeErr
+}
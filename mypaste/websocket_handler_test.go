@@ -0,0 +1,145 @@
+package mypaste
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/websocket"
+)
+
+func TestWebSocketHandler(t *testing.T) {
+	for name, newTestStreamService := range testStreamServices {
+		t.Run(name, func(t *testing.T) {
+			svc := newTestStreamService(t, StreamConfig{MaxLen: 10, ReadCount: 10, ReadBlock: 50 * time.Millisecond})
+			server := newTestWebSocketServer(t, svc, "email")
+			e1 := addEventWithJustPayloadT(t, svc, "email", "hello 1")
+			conn := dialWebSocketT(t, server.URL, server.URL)
+
+			sendWebSocketFrameT(t, conn, WebSocketFrame{Type: "subscribe", LastId: e1.Id})
+			sendWebSocketFrameT(t, conn, WebSocketFrame{Type: "add", RequestId: "r1", Event: &Event{Payload: "hello 2"}})
+
+			var e2 Event
+			var received []Event
+			for e2.Id == "" || len(received) == 0 {
+				frame := receiveWebSocketFrameT(t, conn)
+				switch frame.Type {
+				case "ack":
+					assert.Equal(t, "r1", frame.RequestId)
+					require.NotNil(t, frame.Event)
+					e2 = *frame.Event
+				case "events":
+					received = append(received, frame.Events...)
+				default:
+					require.Fail(t, "unexpected frame", frame)
+				}
+			}
+			assert.Equal(t, "hello 2", e2.Payload)
+			assert.NotEmpty(t, e2.Id)
+			assert.Equal(t, []Event{e2}, received)
+			assert.Equal(t, []Event{e1, e2}, readEventsT(t, svc, "email", ""))
+
+			// subscribing again replaces the previous subscription
+			sendWebSocketFrameT(t, conn, WebSocketFrame{Type: "subscribe"})
+			frame := receiveWebSocketFrameT(t, conn)
+			assert.Equal(t, WebSocketFrame{Type: "events", Events: []Event{e1, e2}}, frame)
+		})
+	}
+}
+
+func TestWebSocketHandlerErrors(t *testing.T) {
+	svc := NewMemoryStreamService(StreamConfig{ReadCount: 10, ReadBlock: time.Minute})
+	server := newTestWebSocketServer(t, svc, "email")
+	conn := dialWebSocketT(t, server.URL, server.URL)
+
+	sendWebSocketFrameT(t, conn, WebSocketFrame{Type: "add", RequestId: "r1"})
+	assert.Equal(t, WebSocketFrame{Type: "error", RequestId: "r1", Error: "missing event"}, receiveWebSocketFrameT(t, conn))
+
+	sendWebSocketFrameT(t, conn, WebSocketFrame{Type: "subscribe", RequestId: "r2", LastId: "invalid"})
+	frame := receiveWebSocketFrameT(t, conn)
+	assert.Equal(t, "error", frame.Type)
+	assert.Equal(t, "r2", frame.RequestId)
+
+	sendWebSocketFrameT(t, conn, WebSocketFrame{Type: "unknown", RequestId: "r3"})
+	frame = receiveWebSocketFrameT(t, conn)
+	assert.Equal(t, "error", frame.Type)
+	assert.Equal(t, "r3", frame.RequestId)
+}
+
+func TestWebSocketHandlerOrigin(t *testing.T) {
+	svc := NewMemoryStreamService(StreamConfig{ReadCount: 10, ReadBlock: time.Minute})
+	server := newTestWebSocketServer(t, svc, "email")
+	_, err := websocket.Dial(webSocketURL(server.URL), "", "https://evil.example.com")
+	assert.Error(t, err)
+}
+
+func TestWebSocketHandlerMaxPayload(t *testing.T) {
+	svc := NewMemoryStreamService(StreamConfig{ReadCount: 10, ReadBlock: time.Minute})
+	server := newTestWebSocketServer(t, svc, "email")
+	conn := dialWebSocketT(t, server.URL, server.URL)
+
+	sendWebSocketFrameT(t, conn, WebSocketFrame{Type: "add", RequestId: "r1", Event: &Event{Payload: strings.Repeat("a", testWebSocketMaxPayload)}})
+	var frame WebSocketFrame
+	assert.Error(t, websocket.JSON.Receive(conn, &frame), "should close the connection")
+	length, err := svc.Len(context.Background(), "email")
+	require.NoError(t, err)
+	assert.Zero(t, length)
+}
+
+func TestWebSocketHandlerRevokedSession(t *testing.T) {
+	svc := NewMemoryStreamService(StreamConfig{ReadCount: 10, ReadBlock: time.Minute})
+	server := newTestWebSocketServer(t, svc, "email")
+	conn := dialWebSocketT(t, server.URL, server.URL)
+	sendWebSocketFrameT(t, conn, WebSocketFrame{Type: "subscribe"})
+
+	require.NoError(t, svc.DeleteSessions(context.Background(), "email"))
+	assert.Equal(t, WebSocketFrame{Type: "error", Error: ErrSessionNotFound.Error()}, receiveWebSocketFrameT(t, conn))
+	var frame WebSocketFrame
+	assert.Error(t, websocket.JSON.Receive(conn, &frame), "should close the connection")
+}
+
+const (
+	testWebSocketMaxPayload = 1 << 10
+	testRevokeCheckInterval = 10 * time.Millisecond
+)
+
+func newTestWebSocketServer(t *testing.T, svc Store, email string) *httptest.Server {
+	e := echo.New()
+	sessionId := newTestSessionT(t, svc, email)
+	e.GET("/", WebSocketHandler(svc, svc, svc, testWebSocketMaxPayload, testRevokeCheckInterval), func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			c.Set("user", generateToken(User{"name", email}, sessionId))
+			return next(c)
+		}
+	})
+	server := httptest.NewServer(e)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func webSocketURL(serverURL string) string {
+	return "ws" + strings.TrimPrefix(serverURL, "http") + "/"
+}
+
+func dialWebSocketT(t *testing.T, serverURL, origin string) *websocket.Conn {
+	conn, err := websocket.Dial(webSocketURL(serverURL), "", origin)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func sendWebSocketFrameT(t *testing.T, conn *websocket.Conn, frame WebSocketFrame) {
+	require.NoError(t, websocket.JSON.Send(conn, frame))
+}
+
+func receiveWebSocketFrameT(t *testing.T, conn *websocket.Conn) WebSocketFrame {
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var frame WebSocketFrame
+	require.NoError(t, websocket.JSON.Receive(conn, &frame))
+	return frame
+}
@@ -30,4 +30,8 @@ async function logout() {
   await Promise.all([backend.logout(), persistence.deleteCurrentUser()]);
 }
 
-export { getLoginedUser, logout };
+async function logoutEverywhere() {
+  await Promise.all([backend.logoutEverywhere(), persistence.deleteCurrentUser()]);
+}
+
+export { getLoginedUser, logout, logoutEverywhere };
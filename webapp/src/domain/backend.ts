@@ -16,8 +16,14 @@ function logout() {
   return axios.post("/api/auth/logout", null);
 }
 
-function addStreamEvent(event: Omit<StreamEvent, "Id" | "Timestamp">) {
-  return axios.post<StreamEvent>("/api/event", event).then((resp) => resp.data);
+function logoutEverywhere() {
+  return axios.post("/api/auth/logout-everywhere", null);
+}
+
+function addStreamEvent(event: Omit<StreamEvent, "Id" | "Timestamp">, idempotencyKey = window.crypto.randomUUID()) {
+  return axios
+    .post<StreamEvent>("/api/event", event, { headers: { "Idempotency-Key": idempotencyKey } })
+    .then((resp) => resp.data);
 }
 
 function readStreamEvents(signal: AbortSignal, lastId: string) {
@@ -64,4 +70,4 @@ async function withRetry<T>(
   }
 }
 
-export { authenticate, logout, addStreamEvent, readStreamEvents, deleteStreamEvents, getDevices, longPoll, withRetry };
+export { authenticate, logout, logoutEverywhere, addStreamEvent, readStreamEvents, deleteStreamEvents, getDevices, longPoll, withRetry };
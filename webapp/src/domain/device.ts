@@ -25,8 +25,10 @@ async function getOrCreateDeviceId(): Promise<string> {
 
 async function addFirstDevice(signal: AbortSignal, deviceId: string): Promise<CryptoKey> {
   const device: Device = { Id: deviceId, Description: deviceDescription() };
+  // the same key on every retry so a timed out request is not added twice
+  const idempotencyKey = window.crypto.randomUUID();
   await backend.withRetry(signal, () =>
-    backend.addStreamEvent({ Kind: "FirstDevice", Payload: JSON.stringify(device) })
+    backend.addStreamEvent({ Kind: "FirstDevice", Payload: JSON.stringify(device) }, idempotencyKey)
   );
   return generateSharedKey();
 }
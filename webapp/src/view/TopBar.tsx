@@ -24,7 +24,7 @@ import { MdAdd, MdLightMode, MdLogout, MdNightsStay } from "react-icons/md";
 import { useLocation, useNavigate } from "react-router-dom";
 import DarkLogoMyPaste from "../assets/DarkLogoMyPaste.svg?react";
 import LogoMyPaste from "../assets/LogoMyPaste.svg?react";
-import { logout } from "../domain/auth";
+import { logout, logoutEverywhere } from "../domain/auth";
 import { useStream } from "../model/stream";
 
 type Props = {
@@ -40,6 +40,11 @@ function TopBar({ user, height, px }: Readonly<Props>) {
       .then(() => window.location.replace("/login"))
       .catch(console.error);
   }, []);
+  const handleLogoutEverywhere = useCallback(() => {
+    logoutEverywhere()
+      .then(() => window.location.replace("/login"))
+      .catch(console.error);
+  }, []);
 
   const navigate = useNavigate();
   const location = useLocation();
@@ -98,6 +103,9 @@ function TopBar({ user, height, px }: Readonly<Props>) {
               <Button leftIcon={<Icon as={MdLogout} boxSize={6} />} onClick={handleLogout}>
                 Logout
               </Button>
+              <Button variant="link" size="sm" display="block" mx="auto" mt={4} onClick={handleLogoutEverywhere}>
+                Sign out everywhere
+              </Button>
             </PopoverBody>
           </PopoverContent>
         </Popover>